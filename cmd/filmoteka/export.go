@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/routes"
+)
+
+// runExport выполняет подкоманду export: выгружает наборы каталога (movies, actors, actor-movies и др.) в CSV или NDJSON,
+// либо весь каталог в архив (archive), который восстанавливается командой import -archive
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "ndjson", "csv or ndjson (ignored for archive)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: export [-format csv|ndjson] <movies|actors|actor-movies|crew|genres|tags|movie-genres|movie-tags|archive> [file]")
+	}
+
+	var output io.Writer = os.Stdout
+	if flags.NArg() == 2 && flags.Arg(1) != "-" {
+		file, err := os.Create(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	if err := routes.InitConnPool(); err != nil {
+		return err
+	}
+
+	if flags.Arg(0) == "archive" {
+		counts, err := routes.WriteArchive(context.Background(), output)
+		if err != nil {
+			return err
+		}
+		log.Printf("Exported %d actor(s), %d movie(s), %d actor-movie link(s)\n",
+			counts.Actors, counts.Movies, counts.ActorMovies)
+		return nil
+	}
+
+	count, err := routes.WriteExport(context.Background(), output, flags.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d row(s)\n", count)
+	return nil
+}
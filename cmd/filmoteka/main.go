@@ -10,12 +10,45 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Error running migrations: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			log.Fatalf("Error exporting catalog: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatalf("Error importing movies: %v", err)
+		}
+		return
+	}
+
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		if err := migrateOnStart(); err != nil {
+			log.Fatalf("Error running migrations: %v", err)
+		}
+	}
+
 	err := routes.InitConnPool()
 
 	if err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
 
+	err = routes.StartPurgeJob()
+
+	if err != nil {
+		log.Fatalf("Error starting purge job: %v", err)
+	}
+
 	mux := http.NewServeMux()
 
 	filmotekahandler.SetupRoutes(mux)
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/postgres"
+)
+
+// runMigrate выполняет подкоманду migrate: up, down [n] или status
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [n] | status")
+	}
+
+	db, err := postgres.Dial()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		count, err := postgres.MigrateUp(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migration(s)\n", count)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		count, err := postgres.MigrateDown(db, steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rolled back %d migration(s)\n", count)
+	case "status":
+		states, err := postgres.MigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			if state.Applied {
+				fmt.Printf("%04d_%s\tapplied at %s\n", state.Version, state.Name, state.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%04d_%s\tpending\n", state.Version, state.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	return nil
+}
+
+// migrateOnStart применяет миграции при запуске сервера, если это не отключено через MIGRATE_ON_START=false
+func migrateOnStart() error {
+	db, err := postgres.Dial()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := postgres.MigrateUp(db)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Applied %d migration(s) on start\n", count)
+	return nil
+}
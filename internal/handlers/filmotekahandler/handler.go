@@ -13,8 +13,11 @@ func SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("PUT /api/update-actor", routes.UpdateActor)
 	mux.HandleFunc("DELETE /api/delete-actor", routes.DeleteActor)
 	mux.HandleFunc("POST /api/get-actors-with-id", routes.GetActorsWithID)
+	mux.HandleFunc("POST /api/search-actor", routes.SearchActor)
 
 	mux.HandleFunc("GET /api/actors", routes.GetActors)
+	mux.HandleFunc("GET /api/actors/{id}", routes.GetActor)
+	mux.HandleFunc("GET /api/people/{id}", routes.GetPerson)
 
 	mux.HandleFunc("POST /api/add-movie", routes.AddMovie)
 	mux.HandleFunc("PUT /api/update-movie", routes.UpdateMovie)
@@ -22,7 +25,76 @@ func SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/get-movies-with-id", routes.GetMoviesWithID)
 	mux.HandleFunc("POST /api/add-actor-to-movie", routes.AddActorToMovie)
 	mux.HandleFunc("DELETE /api/delete-actor-from-movie", routes.DeleteActorFromMovie)
+	mux.HandleFunc("POST /api/movies/{id}/actors/{actorID}", routes.LinkActor)
+	mux.HandleFunc("PUT /api/movies/{id}/actors/{actorID}", routes.UpdateCredit)
+	mux.HandleFunc("DELETE /api/movies/{id}/actors/{actorID}", routes.UnlinkActor)
+	mux.HandleFunc("POST /api/movies/{id}/crew/{personID}", routes.AddCrew)
+	mux.HandleFunc("DELETE /api/movies/{id}/crew/{personID}", routes.RemoveCrew)
+	mux.HandleFunc("POST /api/movies/{id}/genres/{genreID}", routes.AttachGenre)
+	mux.HandleFunc("DELETE /api/movies/{id}/genres/{genreID}", routes.DetachGenre)
+	mux.HandleFunc("POST /api/movies/{id}/tags", routes.AttachTag)
+	mux.HandleFunc("DELETE /api/movies/{id}/tags/{tagID}", routes.DetachTag)
+	mux.HandleFunc("PUT /api/movies/{id}/rating", routes.RateMovie)
+	mux.HandleFunc("DELETE /api/movies/{id}/rating", routes.DeleteRating)
+	mux.HandleFunc("POST /api/movies/{id}/watchlist", routes.AddToWatchlist)
+	mux.HandleFunc("DELETE /api/movies/{id}/watchlist", routes.RemoveFromWatchlist)
+	mux.HandleFunc("PUT /api/movies/{id}/watched", routes.MarkWatched)
+	mux.HandleFunc("DELETE /api/movies/{id}/watched", routes.UnmarkWatched)
+	mux.HandleFunc("GET /api/watchlist", routes.GetWatchlist)
+	mux.HandleFunc("GET /api/watched", routes.GetWatched)
+	mux.HandleFunc("GET /api/movies/{id}/reviews", routes.GetMovieReviews)
+	mux.HandleFunc("POST /api/movies/{id}/reviews", routes.AddReview)
+	mux.HandleFunc("GET /api/users/{id}/reviews", routes.GetUserReviews)
+	mux.HandleFunc("GET /api/reviews/{id}", routes.GetReview)
+	mux.HandleFunc("PUT /api/reviews/{id}", routes.UpdateReview)
+	mux.HandleFunc("DELETE /api/reviews/{id}", routes.DeleteReview)
+	mux.HandleFunc("POST /api/reviews/{id}/reports", routes.ReportReview)
+	mux.HandleFunc("PUT /api/reviews/{id}/status", routes.ModerateReview)
+	mux.HandleFunc("GET /api/moderation/reviews", routes.GetModerationQueue)
+	mux.HandleFunc("POST /api/lists", routes.AddMovieList)
+	mux.HandleFunc("GET /api/lists/{id}", routes.GetMovieList)
+	mux.HandleFunc("PUT /api/lists/{id}", routes.UpdateMovieList)
+	mux.HandleFunc("DELETE /api/lists/{id}", routes.DeleteMovieList)
+	mux.HandleFunc("POST /api/lists/{id}/movies", routes.AddMovieToList)
+	mux.HandleFunc("DELETE /api/lists/{id}/movies/{movieID}", routes.RemoveMovieFromList)
+	mux.HandleFunc("PUT /api/lists/{id}/order", routes.ReorderMovieList)
+	mux.HandleFunc("GET /api/users/{id}", routes.GetUserProfile)
+	mux.HandleFunc("GET /api/movies/{id}/recommendations", routes.GetMovieRecommendations)
+	mux.HandleFunc("GET /api/recommendations", routes.GetRecommendations)
+	mux.HandleFunc("GET /api/actors/{id}/costars", routes.GetCoStars)
+	mux.HandleFunc("GET /api/actors/{id}/path/{targetID}", routes.GetActorPath)
+	mux.HandleFunc("GET /api/genres", routes.GetGenres)
+	mux.HandleFunc("POST /api/genres", routes.AddGenre)
+	mux.HandleFunc("PUT /api/genres/{id}", routes.UpdateGenre)
+	mux.HandleFunc("DELETE /api/genres/{id}", routes.DeleteGenre)
+	mux.HandleFunc("GET /api/tags", routes.GetTags)
+	mux.HandleFunc("POST /api/tags", routes.AddTag)
+	mux.HandleFunc("PUT /api/tags/{id}", routes.UpdateTag)
+	mux.HandleFunc("DELETE /api/tags/{id}", routes.DeleteTag)
+	mux.HandleFunc("PUT /api/movies/{id}/actors", routes.SetMovieCast)
 
 	mux.HandleFunc("GET /api/movies", routes.GetMoviesOrdered)
+	mux.HandleFunc("GET /api/movies/{id}", routes.GetMovie)
+	mux.HandleFunc("GET /api/movies/lookup", routes.GetMovieByExternalID)
 	mux.HandleFunc("POST /api/search-movie", routes.SearchMovie)
+	mux.HandleFunc("POST /api/filter-movies", routes.FilterMovies)
+	mux.HandleFunc("POST /api/import-movies", routes.ImportMovies)
+	mux.HandleFunc("GET /api/export/{dataset}", routes.Export)
+	mux.HandleFunc("GET /api/export-archive", routes.ExportArchive)
+	mux.HandleFunc("POST /api/import-archive", routes.ImportArchive)
+	mux.HandleFunc("POST /api/batch", routes.Batch)
+
+	mux.HandleFunc("GET /api/suggest", routes.Suggest)
+
+	mux.HandleFunc("PUT /api/restore-actor", routes.RestoreActor)
+	mux.HandleFunc("PUT /api/restore-movie", routes.RestoreMovie)
+	mux.HandleFunc("GET /api/deleted-items", routes.GetDeletedItems)
+	mux.HandleFunc("GET /api/audit-log", routes.GetAuditLog)
+
+	mux.HandleFunc("GET /api/actors/{id}/revisions", routes.GetActorRevisions)
+	mux.HandleFunc("GET /api/actors/{id}/revisions/diff", routes.DiffActorRevisions)
+	mux.HandleFunc("POST /api/actors/{id}/revisions/{revision}/restore", routes.RestoreActorRevision)
+	mux.HandleFunc("GET /api/movies/{id}/revisions", routes.GetMovieRevisions)
+	mux.HandleFunc("GET /api/movies/{id}/revisions/diff", routes.DiffMovieRevisions)
+	mux.HandleFunc("POST /api/movies/{id}/revisions/{revision}/restore", routes.RestoreMovieRevision)
 }
@@ -109,21 +109,90 @@ func TestSetupRoutes_RegisterRoutesWithCorrectHandlers(t *testing.T) {
 	SetupRoutes(mux)
 
 	routes := map[string]http.HandlerFunc{
-		"POST /api/signup":                    routes.SignupPerson,
-		"POST /api/login":                     routes.LoginPerson,
-		"POST /api/add-actor":                 routes.AddActor,
-		"PUT /api/update-actor":               routes.UpdateActor,
-		"DELETE /api/delete-actor":            routes.DeleteActor,
-		"POST /api/get-actors-with-id":        routes.GetActorsWithID,
-		"GET /api/actors":                     routes.GetActors,
-		"POST /api/add-movie":                 routes.AddMovie,
-		"PUT /api/update-movie":               routes.UpdateMovie,
-		"DELETE /api/delete-movie":            routes.DeleteMovie,
-		"POST /api/get-movies-with-id":        routes.GetMoviesWithID,
-		"POST /api/add-actor-to-movie":        routes.AddActorToMovie,
-		"DELETE /api/delete-actor-from-movie": routes.DeleteActorFromMovie,
-		"GET /api/movies":                     routes.GetMoviesOrdered,
-		"POST /api/search-movie":              routes.SearchMovie,
+		"POST /api/signup":                                   routes.SignupPerson,
+		"POST /api/login":                                    routes.LoginPerson,
+		"POST /api/add-actor":                                routes.AddActor,
+		"PUT /api/update-actor":                              routes.UpdateActor,
+		"DELETE /api/delete-actor":                           routes.DeleteActor,
+		"POST /api/get-actors-with-id":                       routes.GetActorsWithID,
+		"POST /api/search-actor":                             routes.SearchActor,
+		"GET /api/actors":                                    routes.GetActors,
+		"GET /api/actors/{id}":                               routes.GetActor,
+		"GET /api/people/{id}":                               routes.GetPerson,
+		"POST /api/add-movie":                                routes.AddMovie,
+		"PUT /api/update-movie":                              routes.UpdateMovie,
+		"DELETE /api/delete-movie":                           routes.DeleteMovie,
+		"POST /api/get-movies-with-id":                       routes.GetMoviesWithID,
+		"POST /api/add-actor-to-movie":                       routes.AddActorToMovie,
+		"DELETE /api/delete-actor-from-movie":                routes.DeleteActorFromMovie,
+		"POST /api/movies/{id}/actors/{actorID}":             routes.LinkActor,
+		"PUT /api/movies/{id}/actors/{actorID}":              routes.UpdateCredit,
+		"DELETE /api/movies/{id}/actors/{actorID}":           routes.UnlinkActor,
+		"POST /api/movies/{id}/crew/{personID}":              routes.AddCrew,
+		"POST /api/movies/{id}/genres/{genreID}":             routes.AttachGenre,
+		"DELETE /api/movies/{id}/genres/{genreID}":           routes.DetachGenre,
+		"POST /api/movies/{id}/tags":                         routes.AttachTag,
+		"DELETE /api/movies/{id}/tags/{tagID}":               routes.DetachTag,
+		"PUT /api/movies/{id}/rating":                        routes.RateMovie,
+		"DELETE /api/movies/{id}/rating":                     routes.DeleteRating,
+		"POST /api/movies/{id}/watchlist":                    routes.AddToWatchlist,
+		"DELETE /api/movies/{id}/watchlist":                  routes.RemoveFromWatchlist,
+		"PUT /api/movies/{id}/watched":                       routes.MarkWatched,
+		"DELETE /api/movies/{id}/watched":                    routes.UnmarkWatched,
+		"GET /api/watchlist":                                 routes.GetWatchlist,
+		"GET /api/watched":                                   routes.GetWatched,
+		"GET /api/movies/{id}/reviews":                       routes.GetMovieReviews,
+		"POST /api/movies/{id}/reviews":                      routes.AddReview,
+		"GET /api/users/{id}/reviews":                        routes.GetUserReviews,
+		"GET /api/reviews/{id}":                              routes.GetReview,
+		"PUT /api/reviews/{id}":                              routes.UpdateReview,
+		"DELETE /api/reviews/{id}":                           routes.DeleteReview,
+		"POST /api/reviews/{id}/reports":                     routes.ReportReview,
+		"PUT /api/reviews/{id}/status":                       routes.ModerateReview,
+		"GET /api/moderation/reviews":                        routes.GetModerationQueue,
+		"POST /api/lists":                                    routes.AddMovieList,
+		"GET /api/lists/{id}":                                routes.GetMovieList,
+		"PUT /api/lists/{id}":                                routes.UpdateMovieList,
+		"DELETE /api/lists/{id}":                             routes.DeleteMovieList,
+		"POST /api/lists/{id}/movies":                        routes.AddMovieToList,
+		"DELETE /api/lists/{id}/movies/{movieID}":            routes.RemoveMovieFromList,
+		"PUT /api/lists/{id}/order":                          routes.ReorderMovieList,
+		"GET /api/users/{id}":                                routes.GetUserProfile,
+		"GET /api/movies/{id}/recommendations":               routes.GetMovieRecommendations,
+		"GET /api/recommendations":                           routes.GetRecommendations,
+		"GET /api/actors/{id}/costars":                       routes.GetCoStars,
+		"GET /api/actors/{id}/path/{targetID}":               routes.GetActorPath,
+		"GET /api/movies/lookup":                             routes.GetMovieByExternalID,
+		"GET /api/genres":                                    routes.GetGenres,
+		"POST /api/genres":                                   routes.AddGenre,
+		"PUT /api/genres/{id}":                               routes.UpdateGenre,
+		"DELETE /api/genres/{id}":                            routes.DeleteGenre,
+		"GET /api/tags":                                      routes.GetTags,
+		"POST /api/tags":                                     routes.AddTag,
+		"PUT /api/tags/{id}":                                 routes.UpdateTag,
+		"DELETE /api/tags/{id}":                              routes.DeleteTag,
+		"DELETE /api/movies/{id}/crew/{personID}":            routes.RemoveCrew,
+		"PUT /api/movies/{id}/actors":                        routes.SetMovieCast,
+		"GET /api/movies":                                    routes.GetMoviesOrdered,
+		"GET /api/movies/{id}":                               routes.GetMovie,
+		"POST /api/search-movie":                             routes.SearchMovie,
+		"POST /api/filter-movies":                            routes.FilterMovies,
+		"POST /api/import-movies":                            routes.ImportMovies,
+		"GET /api/export/{dataset}":                          routes.Export,
+		"GET /api/export-archive":                            routes.ExportArchive,
+		"POST /api/import-archive":                           routes.ImportArchive,
+		"POST /api/batch":                                    routes.Batch,
+		"GET /api/suggest":                                   routes.Suggest,
+		"PUT /api/restore-actor":                             routes.RestoreActor,
+		"PUT /api/restore-movie":                             routes.RestoreMovie,
+		"GET /api/deleted-items":                             routes.GetDeletedItems,
+		"GET /api/audit-log":                                 routes.GetAuditLog,
+		"GET /api/actors/{id}/revisions":                     routes.GetActorRevisions,
+		"GET /api/actors/{id}/revisions/diff":                routes.DiffActorRevisions,
+		"POST /api/actors/{id}/revisions/{revision}/restore": routes.RestoreActorRevision,
+		"GET /api/movies/{id}/revisions":                     routes.GetMovieRevisions,
+		"GET /api/movies/{id}/revisions/diff":                routes.DiffMovieRevisions,
+		"POST /api/movies/{id}/revisions/{revision}/restore": routes.RestoreMovieRevision,
 	}
 
 	for route, handler := range routes {
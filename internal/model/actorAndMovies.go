@@ -3,10 +3,10 @@ package model
 import "time"
 
 type ActorAndMovies struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Sex       string    `json:"sex"`
-	BirthDate time.Time `json:"birthDate"`
-	Movies    []Movie   `json:"movies"`
+	ID        int                `json:"id"`
+	FirstName string             `json:"firstName"`
+	LastName  string             `json:"lastName"`
+	Sex       string             `json:"sex"`
+	BirthDate time.Time          `json:"birthDate"`
+	Movies    []FilmographyEntry `json:"movies"`
 }
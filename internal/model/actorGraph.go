@@ -0,0 +1,19 @@
+package model
+
+// CoStar - актёр, снимавшийся в одних фильмах с другим актёром
+type CoStar struct {
+	Actor
+	SharedMovies int `json:"sharedMovies"` // количество общих фильмов
+}
+
+// ActorPath - кратчайшая цепочка актёров, связанных общими фильмами. Degrees - количество фильмов в цепочке.
+type ActorPath struct {
+	Degrees int             `json:"degrees"`
+	Steps   []ActorPathStep `json:"steps"`
+}
+
+// ActorPathStep - актёр в цепочке и фильм, связывающий его с предыдущим актёром (у первого актёра фильма нет)
+type ActorPathStep struct {
+	Actor
+	Movie *Movie `json:"movie,omitempty"`
+}
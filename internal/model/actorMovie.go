@@ -3,9 +3,13 @@ package model
 import "time"
 
 type ActorMovie struct {
-	MovieID   int       `json:"movieID"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Sex       string    `json:"sex"`
-	BirthDate time.Time `json:"birthDate"`
+	MovieID      int       `json:"movieID"`
+	ActorID      int       `json:"actorID,omitempty"` // если задан, добавляется существующий актёр, остальные поля не нужны
+	FirstName    string    `json:"firstName"`
+	LastName     string    `json:"lastName"`
+	Sex          string    `json:"sex"`
+	BirthDate    time.Time `json:"birthDate"`
+	Character    string    `json:"character,omitempty"`
+	BillingOrder int       `json:"billingOrder,omitempty"`
+	RoleType     string    `json:"roleType,omitempty"`
 }
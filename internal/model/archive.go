@@ -0,0 +1,74 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ExportActor struct {
+	ID        int        `json:"id"`
+	FirstName string     `json:"firstName"`
+	LastName  string     `json:"lastName"`
+	Sex       string     `json:"sex"`
+	BirthDate time.Time  `json:"birthDate"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"` // только в архиве
+}
+
+type ExportMovie struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Date        time.Time `json:"date"`
+	Rating      int16     `json:"rating"`
+	// Сведения о фильме, пустое значение означает, что сведения не указаны. В архиве версии 1 их нет.
+	Runtime          int        `json:"runtime,omitempty"`
+	Countries        []string   `json:"countries,omitempty"`
+	OriginalLanguage string     `json:"originalLanguage,omitempty"`
+	OriginalTitle    string     `json:"originalTitle,omitempty"`
+	AgeCertification string     `json:"ageCertification,omitempty"`
+	IMDbID           string     `json:"imdbID,omitempty"`
+	TMDbID           string     `json:"tmdbID,omitempty"`
+	KinopoiskID      string     `json:"kinopoiskID,omitempty"`
+	DeletedAt        *time.Time `json:"deletedAt,omitempty"` // только в архиве
+}
+
+type ExportActorMovie struct {
+	ActorID      int    `json:"actorID"`
+	MovieID      int    `json:"movieID"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billingOrder,omitempty"`
+	RoleType     string `json:"roleType,omitempty"`
+}
+
+type ExportCrew struct {
+	PersonID   int    `json:"personID"`
+	MovieID    int    `json:"movieID"`
+	Department string `json:"department"`
+	Job        string `json:"job,omitempty"`
+}
+
+// ArchiveHeader - первая строка архива
+type ArchiveHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ArchiveRecord - строка архива после заголовка. Type: actor, movie, actorMovie, crew,
+// genre, tag, movieGenre, movieTag или end
+type ArchiveRecord struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ArchiveCounts записывается в последнюю строку архива (type end) и возвращается после восстановления
+type ArchiveCounts struct {
+	Actors      int `json:"actors"`
+	Movies      int `json:"movies"`
+	ActorMovies int `json:"actorMovies"`
+	Crew        int `json:"crew"`
+	Genres      int `json:"genres"`
+	Tags        int `json:"tags"`
+	MovieGenres int `json:"movieGenres"`
+	MovieTags   int `json:"movieTags"`
+}
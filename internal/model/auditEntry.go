@@ -0,0 +1,29 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	PersonID  int             `json:"personID"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  int             `json:"entityID"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// AuditFilter - условия выборки журнала аудита. Пустые поля не ограничивают выборку.
+type AuditFilter struct {
+	PersonID int
+	Action   string
+	Entity   string
+	EntityID int
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
@@ -0,0 +1,28 @@
+package model
+
+import "encoding/json"
+
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	IfMatch string          `json:"ifMatch,omitempty"`
+	Data    json.RawMessage `json:"data"`
+	// Refs подставляет в поле data id, полученный одной из предыдущих операций: поле -> индекс операции
+	Refs map[string]int `json:"refs,omitempty"`
+}
+
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Status int    `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
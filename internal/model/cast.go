@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+type Cast struct {
+	ActorIDs []int `json:"actorIDs"`
+}
+
+type CastChange struct {
+	ActorIDs []int `json:"actorIDs"`
+	Added    []int `json:"added"`
+	Removed  []int `json:"removed"`
+}
+
+// Credit - сведения о роли актёра в фильме. Пустые поля означают, что сведения не указаны.
+type Credit struct {
+	ActorID      int    `json:"actorID,omitempty"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billingOrder,omitempty"` // место в титрах, начиная с 1
+	RoleType     string `json:"roleType,omitempty"`     // lead, supporting, cameo или voice
+}
+
+// CastMember - актёр в составе фильма вместе с ролью
+type CastMember struct {
+	ID           int       `json:"id"`
+	FirstName    string    `json:"firstName"`
+	LastName     string    `json:"lastName"`
+	Sex          string    `json:"sex"`
+	BirthDate    time.Time `json:"birthDate"`
+	Character    string    `json:"character,omitempty"`
+	BillingOrder int       `json:"billingOrder,omitempty"`
+	RoleType     string    `json:"roleType,omitempty"`
+}
+
+// FilmographyEntry - фильм в фильмографии актёра вместе с ролью
+type FilmographyEntry struct {
+	Movie
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billingOrder,omitempty"`
+	RoleType     string `json:"roleType,omitempty"`
+}
+
+type MovieDetails struct {
+	Movie
+	Cast   []CastMember `json:"cast"`
+	Crew   []CrewMember `json:"crew"`
+	Genres []Genre      `json:"genres"`
+	Tags   []Tag        `json:"tags"`
+	// MyRating - оценка текущего пользователя, 0 если он не оценивал фильм
+	MyRating int16 `json:"myRating,omitempty"`
+}
+
+type ActorDetails struct {
+	Actor
+	Filmography []FilmographyEntry `json:"filmography"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// CrewCredit - участие человека в фильме вне актёрского состава
+type CrewCredit struct {
+	PersonID   int    `json:"personID,omitempty"`
+	Department string `json:"department"`    // directing, writing, production или music
+	Job        string `json:"job,omitempty"` // должность, например Director или Screenplay
+}
+
+type CrewMember struct {
+	ID         int    `json:"id"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	Department string `json:"department"`
+	Job        string `json:"job,omitempty"`
+}
+
+// PersonCredit - фильм в фильмографии человека. Для актёрских ролей заполнены сведения о роли, для остальных - должность.
+type PersonCredit struct {
+	Movie
+	Job          string `json:"job,omitempty"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billingOrder,omitempty"`
+	RoleType     string `json:"roleType,omitempty"`
+}
+
+type PersonDetails struct {
+	ID        int       `json:"id"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Sex       string    `json:"sex"`
+	BirthDate time.Time `json:"birthDate"`
+	// Departments - фильмография по отделам: acting, directing, writing, production, music
+	Departments map[string][]PersonCredit `json:"departments"`
+}
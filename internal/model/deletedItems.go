@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type DeletedActor struct {
+	ID        int       `json:"id"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Sex       string    `json:"sex"`
+	BirthDate time.Time `json:"birthDate"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+type DeletedMovie struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Date        time.Time `json:"date"`
+	Rating      int16     `json:"rating"`
+	DeletedAt   time.Time `json:"deletedAt"`
+}
+
+type DeletedItems struct {
+	Actors []DeletedActor `json:"actors"`
+	Movies []DeletedMovie `json:"movies"`
+}
@@ -0,0 +1,23 @@
+package model
+
+// Genre - жанр из курируемого списка
+type Genre struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Tag - произвольная метка фильма
+type Tag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ExportMovieGenre struct {
+	MovieID int `json:"movieID"`
+	GenreID int `json:"genreID"`
+}
+
+type ExportMovieTag struct {
+	MovieID int `json:"movieID"`
+	TagID   int `json:"tagID"`
+}
@@ -0,0 +1,20 @@
+package model
+
+type ImportRowResult struct {
+	Row     int    `json:"row"`    // номер строки данных, начиная с 1
+	Status  string `json:"status"` // created, updated, skipped или failed
+	MovieID int    `json:"movieID,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+	// Error - причина, по которой чтение файла прервалось. Строки в Rows к этому моменту уже обработаны.
+	Error string `json:"error,omitempty"`
+}
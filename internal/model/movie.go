@@ -7,6 +7,44 @@ type Movie struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Date        time.Time `json:"date"`
-	Rating      int16     `json:"rating"`
-	Actors      []Actor   `json:"actors,omitempty"`
+	Rating      int16     `json:"rating"` // редакционный рейтинг
+	// CommunityRating - оценки пользователей, заполняется не во всех ответах
+	CommunityRating *RatingSummary `json:"communityRating,omitempty"`
+	// OnWatchlist, Watched и WatchedOn - отметки текущего пользователя, заполняются не во всех ответах
+	OnWatchlist bool       `json:"onWatchlist,omitempty"`
+	Watched     bool       `json:"watched,omitempty"`
+	WatchedOn   *time.Time `json:"watchedOn,omitempty"`
+	Actors      []Actor    `json:"actors,omitempty"`
+	// Сведения ниже заполняются не во всех ответах, пустое значение означает, что сведения не указаны
+	Runtime          int          `json:"runtime,omitempty"`          // длительность в минутах
+	Countries        []string     `json:"countries,omitempty"`        // страны производства, ISO 3166-1 alpha-2
+	OriginalLanguage string       `json:"originalLanguage,omitempty"` // ISO 639-1
+	OriginalTitle    string       `json:"originalTitle,omitempty"`
+	AgeCertification string       `json:"ageCertification,omitempty"` // 0+, 6+, 12+, 16+ или 18+
+	ExternalIDs      *ExternalIDs `json:"externalIDs,omitempty"`
+}
+
+// ExternalIDs - идентификаторы фильма во внешних каталогах
+type ExternalIDs struct {
+	IMDb      string `json:"imdb,omitempty"`
+	TMDb      string `json:"tmdb,omitempty"`
+	Kinopoisk string `json:"kinopoisk,omitempty"`
+}
+
+// MovieUpdate - тело изменения фильма. Сведения о фильме заданы указателями: отсутствующее поле
+// не меняется, пустое значение ("" или 0) стирает сведения. Countries заменяется целиком, если задано.
+type MovieUpdate struct {
+	Movie
+	Runtime          *int               `json:"runtime"`
+	OriginalLanguage *string            `json:"originalLanguage"`
+	OriginalTitle    *string            `json:"originalTitle"`
+	AgeCertification *string            `json:"ageCertification"`
+	ExternalIDs      *ExternalIDsUpdate `json:"externalIDs"`
+}
+
+// ExternalIDsUpdate - изменение идентификаторов фильма во внешних каталогах, "" удаляет идентификатор
+type ExternalIDsUpdate struct {
+	IMDb      *string `json:"imdb"`
+	TMDb      *string `json:"tmdb"`
+	Kinopoisk *string `json:"kinopoisk"`
 }
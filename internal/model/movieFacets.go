@@ -0,0 +1,24 @@
+package model
+
+type FacetCount struct {
+	Value int `json:"value"`
+	Count int `json:"count"`
+}
+
+type ActorFacet struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Count     int    `json:"count"`
+}
+
+type MovieFacets struct {
+	Decades []FacetCount `json:"decades"`
+	Ratings []FacetCount `json:"ratings"`
+	Actors  []ActorFacet `json:"actors"`
+}
+
+type MovieSearchResult struct {
+	Movies []Movie      `json:"movies"`
+	Facets *MovieFacets `json:"facets,omitempty"`
+}
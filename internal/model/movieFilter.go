@@ -0,0 +1,21 @@
+package model
+
+import "encoding/json"
+
+// MovieFilter - узел дерева фильтра. Заполняется ровно одно из: And, Or, Not или Field.
+type MovieFilter struct {
+	And   []MovieFilter   `json:"and,omitempty"`
+	Or    []MovieFilter   `json:"or,omitempty"`
+	Not   *MovieFilter    `json:"not,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type FilterMovies struct {
+	Filter *MovieFilter `json:"filter"`
+	By     string       `json:"by"`
+	Order  string       `json:"order"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+// MovieList - именованный список фильмов пользователя без самих фильмов
+type MovieList struct {
+	ID          int       `json:"id"`
+	PersonID    int       `json:"personID"`
+	Username    string    `json:"username"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Public      bool      `json:"public"`
+	MovieCount  int       `json:"movieCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// MovieListInput - название, описание и видимость списка от владельца
+type MovieListInput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+}
+
+// MovieListItem - фильм в списке с его позицией
+type MovieListItem struct {
+	Movie
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+type MovieListDetails struct {
+	MovieList
+	Movies []MovieListItem `json:"movies"`
+}
+
+// MovieListAdd - фильм, добавляемый в список. Пустая позиция означает конец списка.
+type MovieListAdd struct {
+	MovieID  int `json:"movieID"`
+	Position int `json:"position,omitempty"`
+}
+
+// MovieListOrder - новый порядок всех фильмов списка
+type MovieListOrder struct {
+	MovieIDs []int `json:"movieIDs"`
+}
+
+// UserProfile - профиль пользователя со списками, которые видны текущему пользователю
+type UserProfile struct {
+	ID       int         `json:"id"`
+	Username string      `json:"username"`
+	Lists    []MovieList `json:"lists"`
+}
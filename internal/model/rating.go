@@ -0,0 +1,18 @@
+package model
+
+// RatingSummary - средняя оценка пользователей и количество оценок
+type RatingSummary struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// UserRating - оценка фильма пользователем от 1 до 10
+type UserRating struct {
+	Score int16 `json:"score"`
+}
+
+type UserRatingResult struct {
+	MovieID         int           `json:"movieID"`
+	Score           int16         `json:"score,omitempty"` // пусто после удаления оценки
+	CommunityRating RatingSummary `json:"communityRating"`
+}
@@ -0,0 +1,16 @@
+package model
+
+// Recommendation - рекомендованный фильм с баллом и объяснением
+type Recommendation struct {
+	Movie
+	Score   int                    `json:"score"`
+	Reasons []RecommendationReason `json:"reasons"`
+}
+
+// RecommendationReason объясняет рекомендацию. Kind: cast и genre - общие актёры и жанры с фильмом,
+// rated и watched - похожие фильмы, которые пользователь высоко оценил или посмотрел, popular - высокая
+// средняя оценка пользователей. Names - имена актёров, названия жанров или фильмов.
+type RecommendationReason struct {
+	Kind  string   `json:"kind"`
+	Names []string `json:"names,omitempty"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// Review - рецензия пользователя на фильм. Status: pending, approved или hidden.
+type Review struct {
+	ID        int       `json:"id"`
+	MovieID   int       `json:"movieID"`
+	MovieName string    `json:"movieName"`
+	PersonID  int       `json:"personID"`
+	Username  string    `json:"username"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	Reports   int       `json:"reports,omitempty"` // заполняется только в очереди модерации
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ReviewInput - текст рецензии от автора
+type ReviewInput struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// ReviewReport - жалоба пользователя на рецензию
+type ReviewReport struct {
+	Reason string `json:"reason"`
+}
+
+// ReviewModeration - решение модератора: approved или hidden
+type ReviewModeration struct {
+	Status string `json:"status"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+type SearchActor struct {
+	Name      string    `json:"name"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Sex       string    `json:"sex"`
+	BirthDate time.Time `json:"birthDate"`
+	MatchAny  bool      `json:"matchAny"`
+	Limit     *int      `json:"limit,omitempty"`
+}
+
+type ActorSearchResult struct {
+	ID         int       `json:"id"`
+	FirstName  string    `json:"firstName"`
+	LastName   string    `json:"lastName"`
+	Sex        string    `json:"sex"`
+	BirthDate  time.Time `json:"birthDate"`
+	Similarity float64   `json:"similarity"`
+}
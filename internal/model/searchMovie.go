@@ -10,4 +10,6 @@ type SearchMovie struct {
 	Rating         int16     `json:"rating"`
 	ActorFirstName string    `json:"actorFirstName"`
 	ActorLastName  string    `json:"actorLastName"`
+	Genre          string    `json:"genre,omitempty"` // название жанра
+	Tag            string    `json:"tag,omitempty"`   // название тега
 }
@@ -0,0 +1,17 @@
+package model
+
+type MovieSuggestion struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ActorSuggestion struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type Suggestions struct {
+	Movies []MovieSuggestion `json:"movies"`
+	Actors []ActorSuggestion `json:"actors"`
+}
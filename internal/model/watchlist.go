@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// WatchState - отметки фильма текущим пользователем
+type WatchState struct {
+	MovieID     int        `json:"movieID"`
+	OnWatchlist bool       `json:"onWatchlist"`
+	Watched     bool       `json:"watched"`
+	WatchedOn   *time.Time `json:"watchedOn,omitempty"`
+}
+
+// Watched - дата просмотра фильма. Пустая дата означает сегодня.
+type Watched struct {
+	WatchedOn time.Time `json:"watchedOn"`
+}
+
+// WatchlistEntry - фильм из списка «посмотреть позже» или истории просмотров с датой добавления
+type WatchlistEntry struct {
+	Movie
+	AddedAt time.Time `json:"addedAt"`
+}
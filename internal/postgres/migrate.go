@@ -0,0 +1,349 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID - ключ advisory lock, который не даёт нескольким экземплярам приложения
+// применять миграции одновременно
+const migrationLockID int64 = 7_311_842_016
+
+// migrationTimeout ограничивает время применения или отката всех миграций за один запуск
+const migrationTimeout = 10 * time.Minute
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 скрипта up
+}
+
+type MigrationState struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// LoadMigrations читает встроенные в бинарник миграции, упорядоченные по версии
+func LoadMigrations() ([]Migration, error) {
+	return parseMigrations(migrationFiles, "migrations")
+}
+
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// withMigrationLock выполняет fn на одном соединении, удерживая advisory lock
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		// Блокировка снимается и при закрытии сессии, поэтому ошибка здесь только логируется
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("Failed to release migration lock: %v\n", err)
+		}
+	}()
+
+	createMigrationsTableQuery := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTableQuery); err != nil {
+		return fmt.Errorf("creating schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func loadApplied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var migration appliedMigration
+		if err := rows.Scan(&version, &migration.name, &migration.checksum, &migration.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = migration
+	}
+
+	return applied, rows.Err()
+}
+
+// verifyChecksums проверяет, что уже применённые миграции не были изменены после применения
+func verifyChecksums(migrations []Migration, applied map[int64]appliedMigration) error {
+	known := map[int64]bool{}
+	for _, migration := range migrations {
+		known[migration.Version] = true
+
+		state, ok := applied[migration.Version]
+		if !ok {
+			continue
+		}
+		if state.checksum != migration.Checksum {
+			return fmt.Errorf("checksum mismatch for applied migration %d_%s: database has %s, file has %s",
+				migration.Version, migration.Name, state.checksum, migration.Checksum)
+		}
+	}
+
+	for version, state := range applied {
+		if !known[version] {
+			log.Printf("Database has migration %d_%s which is unknown to this build\n", version, state.name)
+		}
+	}
+
+	return nil
+}
+
+// MigrateUp применяет все ещё не применённые миграции по порядку, каждую в отдельной транзакции.
+// Возвращает количество применённых миграций.
+func MigrateUp(db *sql.DB) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	count := 0
+
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := loadApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if err := verifyChecksums(migrations, applied); err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			if err := runMigration(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+					migration.Version, migration.Name, migration.Checksum)
+				return err
+			}); err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// MigrateDown откатывает steps последних применённых миграций в обратном порядке.
+// Возвращает количество откаченных миграций.
+func MigrateDown(db *sql.DB, steps int) (int, error) {
+	if steps < 1 {
+		return 0, errors.New("number of migrations to roll back must be positive")
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	count := 0
+
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := loadApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if err := verifyChecksums(migrations, applied); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := runMigration(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Rolled back migration %d_%s\n", migration.Version, migration.Name)
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// MigrationStatus возвращает список известных миграций с отметкой о применении
+func MigrationStatus(db *sql.DB) ([]MigrationState, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	var states []MigrationState
+
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := loadApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			state := MigrationState{Version: migration.Version, Name: migration.Name}
+			if appliedState, ok := applied[migration.Version]; ok {
+				state.Applied = true
+				state.AppliedAt = appliedState.appliedAt
+			}
+			states = append(states, state)
+		}
+
+		return verifyChecksums(migrations, applied)
+	})
+
+	return states, err
+}
+
+// runMigration выполняет скрипт и запись в schema_migrations в одной транзакции
+func runMigration(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("Failed to rollback migration transaction: %v\n", rollbackErr)
+		}
+		return err
+	}
+
+	if err := record(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("Failed to rollback migration transaction: %v\n", rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ActorMovie;
+DROP TABLE IF EXISTS Movie;
+DROP TABLE IF EXISTS Actor;
+DROP TABLE IF EXISTS Person;
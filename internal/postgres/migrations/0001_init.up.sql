@@ -1,10 +1,3 @@
-DO $$
-BEGIN
-    IF NOT EXISTS (SELECT FROM pg_database WHERE datname = 'filmoteka') THEN
-        CREATE DATABASE filmoteka;
-    END IF;
-END $$;
-
 CREATE TABLE IF NOT EXISTS Person (
     id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
     username VARCHAR(255) NOT NULL UNIQUE,
@@ -41,4 +34,3 @@ CREATE TABLE IF NOT EXISTS ActorMovie (
     FOREIGN KEY (movie_id) REFERENCES Movie(id),
     PRIMARY KEY (actor_id, movie_id)
 );
-
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS actor_full_name_trgm_idx;
+DROP FUNCTION IF EXISTS f_unaccent(text);
+DROP EXTENSION IF EXISTS unaccent;
+DROP EXTENSION IF EXISTS pg_trgm;
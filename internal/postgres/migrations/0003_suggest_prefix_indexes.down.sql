@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS actor_last_name_prefix_idx;
+DROP INDEX IF EXISTS actor_full_name_prefix_idx;
+DROP INDEX IF EXISTS movie_name_prefix_idx;
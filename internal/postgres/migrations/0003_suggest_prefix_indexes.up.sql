@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS movie_name_prefix_idx ON Movie (lower(name) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS actor_full_name_prefix_idx ON Actor (lower(firstName || ' ' || lastName) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS actor_last_name_prefix_idx ON Actor (lower(lastName) text_pattern_ops);
@@ -0,0 +1,24 @@
+DROP INDEX IF EXISTS actormovie_movie_id_idx;
+
+DELETE FROM Movie WHERE deleted_at IS NOT NULL;
+DELETE FROM Actor WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE ActorMovie
+    DROP CONSTRAINT IF EXISTS actormovie_movie_id_fkey,
+    ADD CONSTRAINT actormovie_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES Movie(id);
+
+ALTER TABLE ActorMovie
+    DROP CONSTRAINT IF EXISTS actormovie_actor_id_fkey,
+    ADD CONSTRAINT actormovie_actor_id_fkey FOREIGN KEY (actor_id) REFERENCES Actor(id);
+
+DROP INDEX IF EXISTS movie_deleted_at_idx;
+DROP INDEX IF EXISTS actor_deleted_at_idx;
+
+DROP INDEX IF EXISTS movie_alive_unique_idx;
+ALTER TABLE Movie ADD CONSTRAINT movie_name_date_key UNIQUE (name, date);
+
+DROP INDEX IF EXISTS actor_alive_unique_idx;
+ALTER TABLE Actor ADD CONSTRAINT actor_firstname_lastname_birthdate_key UNIQUE (firstName, lastName, birthDate);
+
+ALTER TABLE Movie DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE Actor DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,26 @@
+ALTER TABLE Actor ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE Movie ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Удалённые записи не должны мешать добавить актёра или фильм с теми же данными,
+-- поэтому уникальность проверяется только среди неудалённых записей
+ALTER TABLE Actor DROP CONSTRAINT IF EXISTS actor_firstname_lastname_birthdate_key;
+CREATE UNIQUE INDEX IF NOT EXISTS actor_alive_unique_idx ON Actor (firstName, lastName, birthDate)
+    WHERE deleted_at IS NULL;
+
+ALTER TABLE Movie DROP CONSTRAINT IF EXISTS movie_name_date_key;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_alive_unique_idx ON Movie (name, date)
+    WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS actor_deleted_at_idx ON Actor (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS movie_deleted_at_idx ON Movie (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Связи удаляются вместе с актёром или фильмом при окончательной очистке
+ALTER TABLE ActorMovie
+    DROP CONSTRAINT IF EXISTS actormovie_actor_id_fkey,
+    ADD CONSTRAINT actormovie_actor_id_fkey FOREIGN KEY (actor_id) REFERENCES Actor(id) ON DELETE CASCADE;
+
+ALTER TABLE ActorMovie
+    DROP CONSTRAINT IF EXISTS actormovie_movie_id_fkey,
+    ADD CONSTRAINT actormovie_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES Movie(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS actormovie_movie_id_idx ON ActorMovie (movie_id);
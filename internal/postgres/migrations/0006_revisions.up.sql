@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS revision (
+    entity VARCHAR(20) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL, CHECK ( revision > 0 ),
+    data JSONB NOT NULL,
+    person_id INTEGER, -- NULL у исходного состояния, сохранённого перед первым изменением
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (entity, entity_id, revision)
+);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS movie_bump_version ON movie;
+DROP TRIGGER IF EXISTS actor_bump_version ON actor;
+DROP FUNCTION IF EXISTS bump_version();
+
+ALTER TABLE movie DROP COLUMN IF EXISTS version;
+ALTER TABLE actor DROP COLUMN IF EXISTS version;
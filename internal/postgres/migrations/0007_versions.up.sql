@@ -0,0 +1,22 @@
+ALTER TABLE actor ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- Любое изменение строки увеличивает версию, по ней строится ETag
+CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS actor_bump_version ON actor;
+CREATE TRIGGER actor_bump_version
+    BEFORE UPDATE ON actor
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+DROP TRIGGER IF EXISTS movie_bump_version ON movie;
+CREATE TRIGGER movie_bump_version
+    BEFORE UPDATE ON movie
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
@@ -0,0 +1,4 @@
+ALTER TABLE ActorMovie
+    DROP COLUMN IF EXISTS character_name,
+    DROP COLUMN IF EXISTS billing_order,
+    DROP COLUMN IF EXISTS role_type;
@@ -0,0 +1,6 @@
+-- Сведения о роли актёра в фильме: имя персонажа, место в титрах и тип роли.
+-- NULL означает, что сведения не указаны.
+ALTER TABLE ActorMovie ADD COLUMN IF NOT EXISTS character_name VARCHAR(255);
+ALTER TABLE ActorMovie ADD COLUMN IF NOT EXISTS billing_order INTEGER CHECK ( billing_order > 0 );
+ALTER TABLE ActorMovie ADD COLUMN IF NOT EXISTS role_type VARCHAR(10)
+    CHECK ( role_type IN ('lead', 'supporting', 'cameo', 'voice') );
@@ -0,0 +1,12 @@
+-- Участие людей в фильме вне актёрского состава: режиссёры, сценаристы, продюсеры, композиторы.
+-- Люди хранятся в таблице actor, поэтому один человек может быть и актёром, и режиссёром.
+CREATE TABLE IF NOT EXISTS Crew (
+    person_id INTEGER NOT NULL REFERENCES Actor(id) ON DELETE CASCADE,
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    department VARCHAR(20) NOT NULL CHECK ( department IN ('directing', 'writing', 'production', 'music') ),
+    job VARCHAR(100) NOT NULL DEFAULT '',
+    PRIMARY KEY (person_id, movie_id, department, job)
+);
+
+CREATE INDEX IF NOT EXISTS crew_movie_id_idx ON Crew (movie_id);
+CREATE INDEX IF NOT EXISTS crew_department_person_idx ON Crew (department, person_id);
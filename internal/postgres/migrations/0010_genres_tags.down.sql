@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS MovieTag;
+DROP TABLE IF EXISTS MovieGenre;
+DROP TABLE IF EXISTS Tag;
+DROP TABLE IF EXISTS Genre;
@@ -0,0 +1,36 @@
+-- Жанры - курируемый список, который ведут администраторы. Теги - произвольные метки,
+-- создаются при первом добавлении к фильму.
+CREATE TABLE IF NOT EXISTS Genre (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name VARCHAR(50) NOT NULL, CHECK ( name <> '' )
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS genre_name_idx ON Genre (lower(name));
+
+CREATE TABLE IF NOT EXISTS Tag (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name VARCHAR(50) NOT NULL, CHECK ( name <> '' )
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tag_name_idx ON Tag (lower(name));
+
+CREATE TABLE IF NOT EXISTS MovieGenre (
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    genre_id INTEGER NOT NULL REFERENCES Genre(id) ON DELETE CASCADE,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS moviegenre_genre_id_idx ON MovieGenre (genre_id);
+
+CREATE TABLE IF NOT EXISTS MovieTag (
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES Tag(id) ON DELETE CASCADE,
+    PRIMARY KEY (movie_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS movietag_tag_id_idx ON MovieTag (tag_id);
+
+INSERT INTO Genre (name)
+VALUES ('Action'), ('Adventure'), ('Animation'), ('Comedy'), ('Crime'), ('Documentary'), ('Drama'),
+       ('Fantasy'), ('Horror'), ('Mystery'), ('Romance'), ('Science Fiction'), ('Thriller'), ('War'), ('Western')
+ON CONFLICT DO NOTHING;
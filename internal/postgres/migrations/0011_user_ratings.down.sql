@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS UserRating;
+DROP FUNCTION IF EXISTS userrating_aggregate();
+
+DROP INDEX IF EXISTS movie_community_rating_idx;
+ALTER TABLE movie DROP COLUMN IF EXISTS community_rating;
+ALTER TABLE movie DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE movie DROP COLUMN IF EXISTS rating_sum;
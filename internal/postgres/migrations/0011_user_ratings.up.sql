@@ -0,0 +1,46 @@
+-- Оценки пользователей хранятся отдельно от редакционного рейтинга movie.rating
+CREATE TABLE IF NOT EXISTS UserRating (
+    person_id INTEGER NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    score SMALLINT NOT NULL, CHECK ( score BETWEEN 1 AND 10 ),
+    rated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (person_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS userrating_movie_id_idx ON UserRating (movie_id);
+
+-- Сумма и количество оценок хранятся в фильме, чтобы по средней оценке можно было сортировать списки
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS rating_sum INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS community_rating NUMERIC(4, 2)
+    GENERATED ALWAYS AS ( CASE WHEN rating_count > 0 THEN round(rating_sum::numeric / rating_count, 2) END ) STORED;
+
+CREATE INDEX IF NOT EXISTS movie_community_rating_idx ON movie (community_rating);
+
+-- Изменение оценок пересчитывает сумму и количество в фильме, это же меняет версию фильма
+CREATE OR REPLACE FUNCTION userrating_aggregate() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.score = NEW.score THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE movie SET rating_sum = rating_sum - OLD.score, rating_count = rating_count - 1
+        WHERE id = OLD.movie_id;
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE movie SET rating_sum = rating_sum + NEW.score, rating_count = rating_count + 1
+        WHERE id = NEW.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS userrating_aggregate ON UserRating;
+CREATE TRIGGER userrating_aggregate
+    AFTER INSERT OR UPDATE OR DELETE ON UserRating
+    FOR EACH ROW EXECUTE FUNCTION userrating_aggregate();
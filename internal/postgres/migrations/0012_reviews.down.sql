@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ReviewReport;
+DROP TABLE IF EXISTS Review;
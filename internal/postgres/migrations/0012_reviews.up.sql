@@ -0,0 +1,27 @@
+-- Рецензии пользователей. Новые и отредактированные рецензии видны сразу и ждут проверки модератора
+-- в статусе pending, скрытые модератором рецензии видит только автор.
+CREATE TABLE IF NOT EXISTS Review (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    person_id INTEGER NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    title VARCHAR(150) NOT NULL DEFAULT '',
+    body VARCHAR(5000) NOT NULL, CHECK ( body <> '' ),
+    status VARCHAR(10) NOT NULL DEFAULT 'pending', CHECK ( status IN ('pending', 'approved', 'hidden') ),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    moderated_by INTEGER REFERENCES Person(id) ON DELETE SET NULL,
+    moderated_at TIMESTAMPTZ,
+    UNIQUE (person_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS review_movie_id_created_at_idx ON Review (movie_id, created_at);
+CREATE INDEX IF NOT EXISTS review_status_idx ON Review (status) WHERE status = 'pending';
+
+-- Жалобы пользователей на рецензии, решение модератора закрывает жалобы
+CREATE TABLE IF NOT EXISTS ReviewReport (
+    review_id INTEGER NOT NULL REFERENCES Review(id) ON DELETE CASCADE,
+    person_id INTEGER NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    reason VARCHAR(500) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (review_id, person_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS WatchedMovie;
+DROP TABLE IF EXISTS Watchlist;
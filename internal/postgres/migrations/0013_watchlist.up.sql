@@ -0,0 +1,19 @@
+-- Список «посмотреть позже» и история просмотров пользователя
+CREATE TABLE IF NOT EXISTS Watchlist (
+    person_id INTEGER NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (person_id, movie_id)
+);
+
+-- Для каждого фильма хранится дата последнего просмотра
+CREATE TABLE IF NOT EXISTS WatchedMovie (
+    person_id INTEGER NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    watched_on DATE NOT NULL,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (person_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_person_id_added_at_idx ON Watchlist (person_id, added_at);
+CREATE INDEX IF NOT EXISTS watchedmovie_person_id_added_at_idx ON WatchedMovie (person_id, added_at);
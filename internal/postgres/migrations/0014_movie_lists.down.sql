@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS MovieListItem;
+DROP TABLE IF EXISTS MovieList;
@@ -0,0 +1,23 @@
+-- Именованные списки фильмов пользователя. Приватный список видит только владелец.
+CREATE TABLE IF NOT EXISTS MovieList (
+    id INTEGER PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    person_id INTEGER NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    name VARCHAR(150) NOT NULL, CHECK ( name <> '' ),
+    description VARCHAR(1000) NOT NULL DEFAULT '',
+    is_public BOOL NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS movielist_person_id_name_idx ON MovieList (person_id, lower(name));
+
+-- Позиции фильмов в списке начинаются с 1. Проверка уникальности позиций отложена до конца транзакции,
+-- чтобы при вставке и перестановке позиции можно было сдвигать одним запросом.
+CREATE TABLE IF NOT EXISTS MovieListItem (
+    list_id INTEGER NOT NULL REFERENCES MovieList(id) ON DELETE CASCADE,
+    movie_id INTEGER NOT NULL REFERENCES Movie(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL, CHECK ( position > 0 ),
+    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (list_id, movie_id),
+    CONSTRAINT movielistitem_position_key UNIQUE (list_id, position) DEFERRABLE INITIALLY DEFERRED
+);
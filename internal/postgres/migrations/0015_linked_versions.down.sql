@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS movie_bump_actors ON movie;
+DROP TRIGGER IF EXISTS actor_bump_movies ON actor;
+DROP FUNCTION IF EXISTS bump_movie_actors();
+DROP FUNCTION IF EXISTS bump_actor_movies();
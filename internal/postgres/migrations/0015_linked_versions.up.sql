@@ -0,0 +1,39 @@
+-- Фильм показывается в фильмографии актёра, а актёр - в составе и съёмочной группе фильма, поэтому изменение
+-- одной записи меняет версии связанных, чтобы их ETag отразил изменение. Изменение только версии
+-- или оценок пользователей связанные записи не затрагивает.
+CREATE OR REPLACE FUNCTION bump_actor_movies() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    UPDATE movie SET version = version + 1
+    WHERE id IN (SELECT movie_id FROM ActorMovie WHERE actor_id = NEW.id
+                 UNION
+                 SELECT movie_id FROM Crew WHERE person_id = NEW.id);
+    RETURN NULL;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION bump_movie_actors() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    UPDATE actor SET version = version + 1
+    WHERE id IN (SELECT actor_id FROM ActorMovie WHERE movie_id = NEW.id);
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS actor_bump_movies ON actor;
+CREATE TRIGGER actor_bump_movies
+    AFTER UPDATE ON actor
+    FOR EACH ROW
+    WHEN ( (to_jsonb(OLD) - 'version') IS DISTINCT FROM (to_jsonb(NEW) - 'version') )
+    EXECUTE FUNCTION bump_actor_movies();
+
+DROP TRIGGER IF EXISTS movie_bump_actors ON movie;
+CREATE TRIGGER movie_bump_actors
+    AFTER UPDATE ON movie
+    FOR EACH ROW
+    WHEN ( (to_jsonb(OLD) - ARRAY['version', 'rating_sum', 'rating_count', 'community_rating'])
+           IS DISTINCT FROM (to_jsonb(NEW) - ARRAY['version', 'rating_sum', 'rating_count', 'community_rating']) )
+    EXECUTE FUNCTION bump_movie_actors();
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS movie_countries_idx;
+DROP INDEX IF EXISTS movie_kinopoisk_id_idx;
+DROP INDEX IF EXISTS movie_tmdb_id_idx;
+DROP INDEX IF EXISTS movie_imdb_id_idx;
+
+ALTER TABLE movie DROP COLUMN IF EXISTS kinopoisk_id;
+ALTER TABLE movie DROP COLUMN IF EXISTS tmdb_id;
+ALTER TABLE movie DROP COLUMN IF EXISTS imdb_id;
+ALTER TABLE movie DROP COLUMN IF EXISTS age_certification;
+ALTER TABLE movie DROP COLUMN IF EXISTS original_title;
+ALTER TABLE movie DROP COLUMN IF EXISTS original_language;
+ALTER TABLE movie DROP COLUMN IF EXISTS countries;
+ALTER TABLE movie DROP COLUMN IF EXISTS runtime;
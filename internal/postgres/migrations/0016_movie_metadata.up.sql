@@ -0,0 +1,20 @@
+-- Сведения о фильме для интерфейса и партнёров. Незаполненные поля хранятся как NULL,
+-- страны - коды ISO 3166-1 alpha-2, язык - код ISO 639-1.
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS runtime SMALLINT CHECK ( runtime BETWEEN 1 AND 1000 );
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS countries VARCHAR(2)[] NOT NULL DEFAULT '{}';
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS original_language VARCHAR(2);
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS original_title VARCHAR(150);
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS age_certification VARCHAR(3)
+    CHECK ( age_certification IN ('0+', '6+', '12+', '16+', '18+') );
+
+-- Идентификаторы фильма во внешних каталогах. Как и название с датой, они уникальны только
+-- среди неудалённых фильмов.
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS imdb_id VARCHAR(12);
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS tmdb_id VARCHAR(10);
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS kinopoisk_id VARCHAR(10);
+
+CREATE UNIQUE INDEX IF NOT EXISTS movie_imdb_id_idx ON movie (imdb_id) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_tmdb_id_idx ON movie (tmdb_id) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_kinopoisk_id_idx ON movie (kinopoisk_id) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS movie_countries_idx ON movie USING GIN (countries);
@@ -0,0 +1,34 @@
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS rating_sum INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS community_rating NUMERIC(4, 2)
+    GENERATED ALWAYS AS ( CASE WHEN rating_count > 0 THEN round(rating_sum::numeric / rating_count, 2) END ) STORED;
+
+CREATE INDEX IF NOT EXISTS movie_community_rating_idx ON movie (community_rating);
+
+UPDATE movie m SET rating_sum = s.rating_sum, rating_count = s.rating_count
+FROM movie_rating_stats s
+WHERE s.movie_id = m.id;
+
+CREATE OR REPLACE FUNCTION userrating_aggregate() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.score = NEW.score THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE movie SET rating_sum = rating_sum - OLD.score, rating_count = rating_count - 1
+        WHERE id = OLD.movie_id;
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE movie SET rating_sum = rating_sum + NEW.score, rating_count = rating_count + 1
+        WHERE id = NEW.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$;
+
+DROP TABLE IF EXISTS movie_rating_stats;
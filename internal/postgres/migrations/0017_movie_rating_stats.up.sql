@@ -0,0 +1,45 @@
+-- Сумма и количество оценок пользователей хранятся отдельно от фильма: оценка не меняет версию (ETag)
+-- фильма и не блокирует его строку, которую меняют редакторы. Строка появляется с первой оценкой фильма.
+CREATE TABLE IF NOT EXISTS movie_rating_stats (
+    movie_id INTEGER PRIMARY KEY REFERENCES Movie(id) ON DELETE CASCADE,
+    rating_sum INTEGER NOT NULL DEFAULT 0,
+    rating_count INTEGER NOT NULL DEFAULT 0,
+    community_rating NUMERIC(4, 2)
+        GENERATED ALWAYS AS ( CASE WHEN rating_count > 0 THEN round(rating_sum::numeric / rating_count, 2) END ) STORED
+);
+
+CREATE INDEX IF NOT EXISTS movie_rating_stats_community_rating_idx ON movie_rating_stats (community_rating);
+
+INSERT INTO movie_rating_stats (movie_id, rating_sum, rating_count)
+SELECT movie_id, sum(score), count(*) FROM UserRating GROUP BY movie_id
+ON CONFLICT (movie_id) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION userrating_aggregate() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.score = NEW.score THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE movie_rating_stats SET rating_sum = rating_sum - OLD.score, rating_count = rating_count - 1
+        WHERE movie_id = OLD.movie_id;
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO movie_rating_stats (movie_id, rating_sum, rating_count)
+        VALUES (NEW.movie_id, NEW.score, 1)
+        ON CONFLICT (movie_id) DO UPDATE
+            SET rating_sum = movie_rating_stats.rating_sum + EXCLUDED.rating_sum,
+                rating_count = movie_rating_stats.rating_count + 1;
+    END IF;
+
+    RETURN NULL;
+END;
+$$;
+
+DROP INDEX IF EXISTS movie_community_rating_idx;
+ALTER TABLE movie DROP COLUMN IF EXISTS community_rating;
+ALTER TABLE movie DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE movie DROP COLUMN IF EXISTS rating_sum;
@@ -0,0 +1,9 @@
+CREATE OR REPLACE FUNCTION bump_movie_actors() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    UPDATE actor SET version = version + 1
+    WHERE id IN (SELECT actor_id FROM ActorMovie WHERE movie_id = NEW.id);
+    RETURN NULL;
+END;
+$$;
@@ -0,0 +1,13 @@
+-- Фильм показывается в фильмографии и участника съёмочной группы, поэтому изменение фильма меняет
+-- версии не только актёров, но и людей из Crew.
+CREATE OR REPLACE FUNCTION bump_movie_actors() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    UPDATE actor SET version = version + 1
+    WHERE id IN (SELECT actor_id FROM ActorMovie WHERE movie_id = NEW.id
+                 UNION
+                 SELECT person_id FROM Crew WHERE movie_id = NEW.id);
+    RETURN NULL;
+END;
+$$;
@@ -3,6 +3,7 @@ package postgres
 import (
 	"os"
 	"testing"
+	"testing/fstest"
 )
 
 // Returns a *sql.DB and nil error when all environment variables are set correctly
@@ -263,3 +264,95 @@ func TestDial_ConnMaxLifetimeEmpty(t *testing.T) {
 		t.Error("Expected an error, got nil")
 	}
 }
+
+// Parses embedded migrations in version order with both up and down scripts
+func TestLoadMigrations_OrderedWithUpAndDown(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Expected at least one migration")
+	}
+
+	for i, migration := range migrations {
+		if i > 0 && migration.Version <= migrations[i-1].Version {
+			t.Errorf("Migrations are not ordered: %d after %d", migration.Version, migrations[i-1].Version)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			t.Errorf("Migration %d_%s must have up and down scripts", migration.Version, migration.Name)
+		}
+		if len(migration.Checksum) != 64 {
+			t.Errorf("Migration %d_%s has invalid checksum %q", migration.Version, migration.Name, migration.Checksum)
+		}
+	}
+}
+
+// Returns an error for a migration without a down script or with an invalid file name
+func TestParseMigrations_InvalidFiles(t *testing.T) {
+	missingDown := fstest.MapFS{
+		"migrations/0001_init.up.sql": {Data: []byte("CREATE TABLE t (id INTEGER);")},
+	}
+	if _, err := parseMigrations(missingDown, "migrations"); err == nil {
+		t.Error("Expected error for migration without down script, got nil")
+	}
+
+	invalidName := fstest.MapFS{
+		"migrations/init.sql": {Data: []byte("CREATE TABLE t (id INTEGER);")},
+	}
+	if _, err := parseMigrations(invalidName, "migrations"); err == nil {
+		t.Error("Expected error for invalid migration file name, got nil")
+	}
+}
+
+// Returns an error when an applied migration was changed after it was applied
+func TestVerifyChecksums_Mismatch(t *testing.T) {
+	migrations := []Migration{{Version: 1, Name: "init", Checksum: "new"}}
+	applied := map[int64]appliedMigration{1: {name: "init", checksum: "old"}}
+
+	if err := verifyChecksums(migrations, applied); err == nil {
+		t.Error("Expected checksum mismatch error, got nil")
+	}
+}
+
+// Applies all migrations and is idempotent on the second run
+func TestMigrateUp_Idempotent(t *testing.T) {
+	// Set up the test environment
+	os.Setenv("DB_HOST", "filmoteka-postgres-test")
+	os.Setenv("DB_PORT", "5432")
+	os.Setenv("DB_USER", "postgres")
+	os.Setenv("DB_NAME", "filmoteka")
+	os.Setenv("DB_PASSWORD", "postgres")
+	os.Setenv("MAX_OPEN_CONNS", "10")
+	os.Setenv("MAX_IDLE_CONNS", "5")
+	os.Setenv("CONN_MAX_LIFETIME", "30")
+
+	db, err := Dial()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	defer db.Close()
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	count, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no migrations on second run, got %d", count)
+	}
+
+	states, err := MigrationStatus(db)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	for _, state := range states {
+		if !state.Applied {
+			t.Errorf("Expected migration %d_%s to be applied", state.Version, state.Name)
+		}
+	}
+}
@@ -2,13 +2,17 @@ package routes
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/BukhryakovVladimir/vkTest/internal/model"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lib/pq"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -62,37 +66,37 @@ func AddActor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len([]rune(actor.FirstName)) > 255 {
-		http.Error(w, "Maximum firstName string length is 255 symbols", http.StatusBadRequest)
+	if err := validateActor(actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len([]rune(actor.LastName)) > 255 {
-		http.Error(w, "Maximum lastName string length is 255 symbols", http.StatusBadRequest)
-		return
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
 
-	if len([]rune(actor.Sex)) > 10 {
-		http.Error(w, "Maximum sex string length is 10 symbols", http.StatusBadRequest)
-		return
-	}
+	tx, err := db.BeginTx(ctx, nil)
 
-	if actor.BirthDate.After(time.Now()) {
-		http.Error(w, "Birth date cannot be in the future", http.StatusBadRequest)
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
 		return
 	}
 
-	addActorQuery := `
-	INSERT INTO actor (firstName, lastName, sex, birthDate) 
-	VALUES ($1::text, $2::text, $3::text, $4)
-	ON CONFLICT (firstName, lastName, birthDate) DO NOTHING;`
+	_, err = addActorTx(ctx, tx, claims.Issuer, actor)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
-	defer cancel()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("AddActor Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("AddActor transaction rollback")
+		}
 
-	result, err := db.ExecContext(ctx, addActorQuery, actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate)
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("AddActor ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
 
-	if err != nil {
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Println("AddActor ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
@@ -102,21 +106,16 @@ func AddActor(w http.ResponseWriter, r *http.Request) {
 		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
-
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = tx.Commit()
 	if err != nil {
-		log.Println("AddActor error checking rows affected : ", err)
+		log.Println("AddActor error committing transaction: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	if rowsAffected == 0 {
-		log.Println("AddActor actor already exists, no rows affected")
-		http.Error(w, "Actor already exists", http.StatusBadRequest)
-		return
-	}
+	invalidateSuggestions()
 
 	resp, err := json.Marshal("Actor added successfully")
 	if err != nil {
@@ -184,41 +183,37 @@ func UpdateActor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len([]rune(actor.FirstName)) > 255 {
-		http.Error(w, "Maximum firstName string length is 255 symbols", http.StatusBadRequest)
+	if err := validateActor(actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len([]rune(actor.LastName)) > 255 {
-		http.Error(w, "Maximum lastName string length is 255 symbols", http.StatusBadRequest)
-		return
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
 
-	if len([]rune(actor.Sex)) > 10 {
-		http.Error(w, "Maximum sex string length is 10 symbols", http.StatusBadRequest)
-		return
-	}
+	tx, err := db.BeginTx(ctx, nil)
 
-	if actor.BirthDate.After(time.Now()) {
-		http.Error(w, "Birth date cannot be in the future", http.StatusBadRequest)
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
 		return
 	}
 
-	updateActorQuery := `UPDATE actor
-							SET 
-								firstName = COALESCE(NULLIF($1, ''), firstName),
-								lastName = COALESCE(NULLIF($2, ''), lastName),
-								sex = COALESCE(NULLIF($3, ''), sex),
-								birthDate = CASE WHEN $4::date = '0001-01-01' THEN birthDate ELSE $4::date END
-						WHERE id = $5;
-						    `
+	err = updateActorTx(ctx, tx, claims.Issuer, actor, r.Header.Get("If-Match"))
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
-	defer cancel()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("UpdateActor Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("UpdateActor transaction rollback")
+		}
 
-	_, err = db.ExecContext(ctx, updateActorQuery, actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate, actor.ID)
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("UpdateActor ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
 
-	if err != nil {
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Println("UpdateActor ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
@@ -237,9 +232,17 @@ func UpdateActor(w http.ResponseWriter, r *http.Request) {
 		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
+	}
 
+	err = tx.Commit()
+	if err != nil {
+		log.Println("UpdateActor error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
+	invalidateSuggestions()
+
 	resp, err := json.Marshal("Actor updated successfully")
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -323,9 +326,7 @@ func DeleteActor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteActorMovieQuery := `DELETE FROM actormovie WHERE actor_id = $1;`
-
-	result, err := tx.ExecContext(ctx, deleteActorMovieQuery, actor.ID)
+	err = deleteActorTx(ctx, tx, claims.Issuer, actor.ID, r.Header.Get("If-Match"))
 
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -334,78 +335,34 @@ func DeleteActor(w http.ResponseWriter, r *http.Request) {
 			log.Println("DeleteActor transaction rollback")
 		}
 
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			log.Println("DeleteActor ExecContext deadline exceeded: ", err)
-			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("DeleteActor ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
 			return
-		} else {
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteActor Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteActor transaction rollback")
-		}
-
-		log.Println("DeleteActor error checking rows affected : ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if rowsAffected == 0 {
-		log.Println("DeleteActor ActorMovie table. ActorMovie relations don't exist, no rows affected")
-	} else {
-		log.Println("DeleteActor Deleted actor with id = ", actor.ID, " from ActorMovie table")
-	}
-
-	deleteActorQuery := `DELETE FROM actor WHERE id = $1;`
-
-	result, err = tx.ExecContext(ctx, deleteActorQuery, actor.ID)
-
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteActor Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteActor transaction rollback")
 		}
 
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Println("DeleteActor ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
 			return
-		} else {
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	rowsAffected, err = result.RowsAffected()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteActor Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteActor transaction rollback")
 		}
 
-		log.Println("DeleteActor error checking rows affected : ", err)
+		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	if rowsAffected == 0 {
-		log.Println("DeleteActor actor table. Actor doesn't exist, no rows affected")
-		http.Error(w, "Actor doesn't exist. Nothing deleted", http.StatusBadRequest)
+	err = tx.Commit()
+	if err != nil {
+		log.Println("DeleteActor error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("DeleteActor Deleted actor with id = ", actor.ID, " from Actor table")
+	log.Println("DeleteActor Soft deleted actor with id = ", actor.ID)
+
+	invalidateSuggestions()
 
 	resp, err := json.Marshal("Actor deleted successfully")
 	if err != nil {
@@ -413,19 +370,6 @@ func DeleteActor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteActor Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteActor transaction rollback")
-		}
-
-		log.Println("DeleteActor error committing transaction")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	_, err = w.Write(resp)
@@ -507,11 +451,12 @@ func GetActorsWithID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	getActorsQuery := `
-    SELECT * FROM actor
-    WHERE ($1 <> '' AND firstName LIKE '%' || $1 || '%')
+    SELECT id, firstName, lastName, sex, birthDate FROM actor
+    WHERE deleted_at IS NULL
+    AND (($1 <> '' AND firstName LIKE '%' || $1 || '%')
     OR ($2 <> '' AND lastName LIKE '%' || $2 || '%')
     OR (sex = $3)
-    OR ($4 <> '' AND birthDate = $4::date)
+    OR ($4 <> '' AND birthDate = $4::date))
     ORDER BY id;
 `
 
@@ -597,15 +542,19 @@ func GetActors(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	// Список отправляется потоком, пока читается из БД, поэтому ограничен STREAM_TIME_LIMIT,
+	// а запрос отменяется, когда клиент закрывает соединение
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(streamTimeLimit)*time.Second)
 	defer cancel()
 
 	getActorsWithMoviesQuery := `
-		SELECT a.firstName, a.lastName, a.sex, a.birthDate, m.name, m.description, m.date, m.rating
+		SELECT a.id, a.firstName, a.lastName, a.sex, a.birthDate, m.id, m.name, m.description, m.date, m.rating,
+		COALESCE(ma.character_name, ''), COALESCE(ma.billing_order, 0), COALESCE(ma.role_type, '')
 		FROM actor a
 		JOIN actormovie ma ON a.id = ma.actor_id
 		JOIN movie m ON m.id = ma.movie_id
-		ORDER BY a.id
+		WHERE a.deleted_at IS NULL AND m.deleted_at IS NULL
+		ORDER BY a.id, m.date, m.id
 	`
 
 	rows, err := db.QueryContext(ctx, getActorsWithMoviesQuery)
@@ -622,45 +571,212 @@ func GetActors(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var actors []model.ActorAndMovies
+	// Строки одного актёра идут подряд, поэтому актёр отправляется клиенту,
+	// как только начинаются строки следующего
+	stream := newListStreamer(w, r)
 	var currentActor *model.ActorAndMovies
 
 	for rows.Next() {
 		var actor model.ActorAndMovies
-		var movie model.Movie
+		var movie model.FilmographyEntry
 
-		if err := rows.Scan(&actor.FirstName, &actor.LastName, &actor.Sex, &actor.BirthDate,
-			&movie.Name, &movie.Description, &movie.Date, &movie.Rating); err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName, &actor.Sex, &actor.BirthDate,
+			&movie.ID, &movie.Name, &movie.Description, &movie.Date, &movie.Rating,
+			&movie.Character, &movie.BillingOrder, &movie.RoleType); err != nil {
+			stream.fail(ctx, "GetActors", err)
 			return
 		}
 
-		// Check if we're still processing the same movie
-		if currentActor != nil && actor.FirstName == currentActor.FirstName &&
-			actor.LastName == currentActor.LastName &&
-			actor.BirthDate == currentActor.BirthDate {
-			// Add actor to the current movie's actor list
+		if currentActor != nil && actor.ID == currentActor.ID {
 			currentActor.Movies = append(currentActor.Movies, movie)
-		} else {
-			// We've encountered a new movie, so add the previous one to the movies slice
-			if currentActor != nil {
-				actors = append(actors, *currentActor)
+			continue
+		}
+
+		if currentActor != nil {
+			if err := stream.write(currentActor); err != nil {
+				log.Printf("Write failed: %v\n", err)
+				return
 			}
-			// Start aggregating actors for the new movie
-			actor.Movies = []model.Movie{movie}
-			currentActor = &actor
 		}
+
+		actor.Movies = []model.FilmographyEntry{movie}
+		currentActor = &actor
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		stream.fail(ctx, "GetActors", err)
 		return
 	}
 
 	if currentActor != nil {
-		actors = append(actors, *currentActor)
+		if err := stream.write(currentActor); err != nil {
+			log.Printf("Write failed: %v\n", err)
+			return
+		}
+	}
+
+	if err := stream.close(); err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+const (
+	searchActorDefaultLimit = 20
+	searchActorMaxLimit     = 100
+)
+
+// buildSearchActorQuery собирает параметризованный запрос нечёткого поиска актёров.
+// Условия по умолчанию объединяются через AND, при matchAny = true - через OR.
+// Имена сравниваются через pg_trgm без учёта регистра и диакритики.
+func buildSearchActorQuery(search model.SearchActor) (string, []interface{}) {
+	var conditions []string
+	var scores []string
+	var args []interface{}
+
+	fullName := `f_unaccent(lower(firstName || ' ' || lastName))`
+
+	if search.Name != "" {
+		args = append(args, search.Name)
+		param := fmt.Sprintf("f_unaccent(lower($%d))", len(args))
+		conditions = append(conditions, fmt.Sprintf("(%s %% %s OR %s <%% %s)", fullName, param, param, fullName))
+		scores = append(scores, fmt.Sprintf("GREATEST(similarity(%s, %s), word_similarity(%s, %s))",
+			fullName, param, param, fullName))
+	}
+
+	if search.FirstName != "" {
+		args = append(args, search.FirstName)
+		param := fmt.Sprintf("f_unaccent(lower($%d))", len(args))
+		conditions = append(conditions, fmt.Sprintf("f_unaccent(lower(firstName)) %% %s", param))
+		scores = append(scores, fmt.Sprintf("similarity(f_unaccent(lower(firstName)), %s)", param))
+	}
+
+	if search.LastName != "" {
+		args = append(args, search.LastName)
+		param := fmt.Sprintf("f_unaccent(lower($%d))", len(args))
+		conditions = append(conditions, fmt.Sprintf("f_unaccent(lower(lastName)) %% %s", param))
+		scores = append(scores, fmt.Sprintf("similarity(f_unaccent(lower(lastName)), %s)", param))
+	}
+
+	if search.Sex != "" {
+		args = append(args, search.Sex)
+		conditions = append(conditions, fmt.Sprintf("lower(sex) = lower($%d)", len(args)))
+	}
+
+	if !search.BirthDate.IsZero() {
+		args = append(args, search.BirthDate)
+		conditions = append(conditions, fmt.Sprintf("birthDate = $%d::date", len(args)))
+	}
+
+	score := "0::float8"
+	if len(scores) > 0 {
+		score = fmt.Sprintf("(%s) / %d", strings.Join(scores, " + "), len(scores))
+	}
+
+	operator := " AND "
+	if search.MatchAny {
+		operator = " OR "
+	}
+
+	args = append(args, *search.Limit)
+
+	query := fmt.Sprintf(`
+	SELECT id, firstName, lastName, sex, birthDate, %s AS score
+	FROM actor
+	WHERE deleted_at IS NULL AND (%s)
+	ORDER BY score DESC, id
+	LIMIT $%d;
+	`, score, strings.Join(conditions, operator), len(args))
+
+	return query, args
+}
+
+func SearchActor(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	var search model.SearchActor
+	err := json.NewDecoder(r.Body).Decode(&search)
+
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if len([]rune(search.Name)) > 511 {
+		http.Error(w, "Maximum name string length is 511 symbols", http.StatusBadRequest)
+		return
+	}
+
+	if len([]rune(search.FirstName)) > 255 {
+		http.Error(w, "Maximum firstName string length is 255 symbols", http.StatusBadRequest)
+		return
+	}
+
+	if len([]rune(search.LastName)) > 255 {
+		http.Error(w, "Maximum lastName string length is 255 symbols", http.StatusBadRequest)
+		return
+	}
+
+	if len([]rune(search.Sex)) > 10 {
+		http.Error(w, "Maximum sex string length is 10 symbols", http.StatusBadRequest)
+		return
+	}
+
+	if search.Name == "" && search.FirstName == "" && search.LastName == "" &&
+		search.Sex == "" && search.BirthDate.IsZero() {
+		http.Error(w, "At least one search field must be set", http.StatusBadRequest)
+		return
+	}
+
+	if search.Limit == nil {
+		limit := searchActorDefaultLimit
+		search.Limit = &limit
+	}
+
+	if *search.Limit < 1 || *search.Limit > searchActorMaxLimit {
+		http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", searchActorMaxLimit), http.StatusBadRequest)
+		return
+	}
+
+	searchActorQuery, args := buildSearchActorQuery(search)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, searchActorQuery, args...)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("SearchActor QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		} else {
+			log.Println("Database error: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer rows.Close()
+
+	actors := []model.ActorSearchResult{}
+	for rows.Next() {
+		var actor model.ActorSearchResult
+		if err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName,
+			&actor.Sex, &actor.BirthDate, &actor.Similarity); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		actors = append(actors, actor)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
 	resp, err := json.Marshal(actors)
@@ -676,3 +792,78 @@ func GetActors(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Write failed: %v\n", err)
 	}
 }
+
+// GetActor возвращает актёра по id из пути вместе с фильмографией и ролями. Ответ содержит ETag с версией записи,
+// при совпадении If-None-Match возвращается 304 без тела.
+func GetActor(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	getActorQuery := `
+	SELECT id, firstName, lastName, sex, birthDate, version FROM actor
+	WHERE id = $1 AND deleted_at IS NULL;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	// Версия и фильмография читаются в одном снимке, чтобы ETag соответствовал телу ответа
+	tx, err := beginSnapshot(ctx)
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var actor model.ActorDetails
+	var version int
+
+	err = tx.QueryRowContext(ctx, getActorQuery, id).Scan(&actor.ID, &actor.FirstName, &actor.LastName, &actor.Sex, &actor.BirthDate, &version)
+	if err == nil {
+		actor.Filmography, err = getFilmography(ctx, tx, id)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Actor not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetActor QueryRowContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	etag := formatETag(version)
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp, err := json.Marshal(actor)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,271 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+const (
+	auditLogDefaultLimit = 50
+	auditLogMaxLimit     = 500
+)
+
+const (
+	auditEntityActor = "actor"
+	auditEntityMovie = "movie"
+)
+
+// snapshotQueries возвращают состояние записи в виде JSON для журнала аудита.
+// Строка блокируется до конца транзакции, чтобы снимки до и после изменения были согласованы.
+var snapshotQueries = map[string]string{
+	auditEntityActor: `
+	SELECT jsonb_build_object('id', a.id, 'firstName', a.firstName, 'lastName', a.lastName,
+		'sex', a.sex, 'birthDate', a.birthDate, 'deletedAt', a.deleted_at)
+	FROM actor a
+	WHERE a.id = $1
+	FOR UPDATE;
+	`,
+	auditEntityMovie: `
+	SELECT jsonb_build_object('id', m.id, 'name', m.name, 'description', m.description,
+		'date', m.date, 'rating', m.rating, 'deletedAt', m.deleted_at,
+		'runtime', m.runtime, 'countries', m.countries, 'originalLanguage', m.original_language,
+		'originalTitle', m.original_title, 'ageCertification', m.age_certification,
+		'externalIDs', jsonb_strip_nulls(jsonb_build_object('imdb', m.imdb_id, 'tmdb', m.tmdb_id,
+			'kinopoisk', m.kinopoisk_id)),
+		'credits', COALESCE((SELECT jsonb_agg(jsonb_build_object('actorID', ma.actor_id,
+			'character', ma.character_name, 'billingOrder', ma.billing_order, 'roleType', ma.role_type)
+			ORDER BY ma.actor_id)
+			FROM actormovie ma WHERE ma.movie_id = m.id), '[]'::jsonb),
+		'crew', COALESCE((SELECT jsonb_agg(jsonb_build_object('personID', c.person_id,
+			'department', c.department, 'job', c.job)
+			ORDER BY c.person_id, c.department, c.job)
+			FROM crew c WHERE c.movie_id = m.id), '[]'::jsonb),
+		'genres', COALESCE((SELECT jsonb_agg(g.name ORDER BY g.name)
+			FROM moviegenre mg JOIN genre g ON g.id = mg.genre_id WHERE mg.movie_id = m.id), '[]'::jsonb),
+		'tags', COALESCE((SELECT jsonb_agg(t.name ORDER BY t.name)
+			FROM movietag mt JOIN tag t ON t.id = mt.tag_id WHERE mt.movie_id = m.id), '[]'::jsonb))
+	FROM movie m
+	WHERE m.id = $1
+	FOR UPDATE;
+	`,
+}
+
+// snapshot возвращает текущее состояние записи entity с указанным id или nil, если записи нет
+func snapshot(ctx context.Context, tx *sql.Tx, entity string, id int) (json.RawMessage, error) {
+	var data []byte
+
+	err := tx.QueryRowContext(ctx, snapshotQueries[entity], id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// auditChange снимает состояние записи после изменения и добавляет запись в журнал аудита.
+// Вызывается в той же транзакции, что и изменение, поэтому запись в журнале и изменение
+// фиксируются или откатываются вместе.
+func auditChange(ctx context.Context, tx *sql.Tx, issuer, action, entity string, entityID int,
+	before json.RawMessage) error {
+	after, err := snapshot(ctx, tx, entity, entityID)
+	if err != nil {
+		return err
+	}
+
+	addAuditQuery := `
+	INSERT INTO audit_log (person_id, action, entity, entity_id, before, after)
+	VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb);
+	`
+
+	_, err = tx.ExecContext(ctx, addAuditQuery, issuer, action, entity, entityID,
+		nullableJSON(before), nullableJSON(after))
+
+	return err
+}
+
+func nullableJSON(data json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(data), Valid: data != nil}
+}
+
+// parseAuditFilter читает условия выборки журнала из параметров запроса
+func parseAuditFilter(query url.Values) (model.AuditFilter, error) {
+	filter := model.AuditFilter{Limit: auditLogDefaultLimit}
+
+	var err error
+
+	if value := query.Get("personID"); value != "" {
+		if filter.PersonID, err = strconv.Atoi(value); err != nil {
+			return filter, errors.New("personID must be an integer")
+		}
+	}
+
+	if value := query.Get("entityID"); value != "" {
+		if filter.EntityID, err = strconv.Atoi(value); err != nil {
+			return filter, errors.New("entityID must be an integer")
+		}
+	}
+
+	filter.Action = query.Get("action")
+
+	filter.Entity = query.Get("entity")
+	if filter.Entity != "" && filter.Entity != auditEntityActor && filter.Entity != auditEntityMovie {
+		return filter, errors.New("entity must be actor or movie")
+	}
+
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("from must be in RFC 3339 format")
+		}
+	}
+
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("to must be in RFC 3339 format")
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > auditLogMaxLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", auditLogMaxLimit)
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil || filter.Offset < 0 {
+			return filter, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	return filter, nil
+}
+
+// buildAuditLogQuery собирает параметризованный запрос к журналу аудита, новые записи идут первыми
+func buildAuditLogQuery(filter model.AuditFilter) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+
+	if filter.PersonID != 0 {
+		args = append(args, filter.PersonID)
+		conditions = append(conditions, fmt.Sprintf("person_id = $%d", len(args)))
+	}
+
+	if filter.Action != "" {
+		args = append(args, filter.Action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+
+	if filter.Entity != "" {
+		args = append(args, filter.Entity)
+		conditions = append(conditions, fmt.Sprintf("entity = $%d", len(args)))
+	}
+
+	if filter.EntityID != 0 {
+		args = append(args, filter.EntityID)
+		conditions = append(conditions, fmt.Sprintf("entity_id = $%d", len(args)))
+	}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+
+	query := fmt.Sprintf(`
+	SELECT id, person_id, action, entity, entity_id, before, after, created_at
+	FROM audit_log
+	WHERE %s
+	ORDER BY created_at DESC, id DESC
+	LIMIT $%d OFFSET $%d;
+	`, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	return query, args
+}
+
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "get audit log"); !ok {
+		return
+	}
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auditLogQuery, args := buildAuditLogQuery(filter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, auditLogQuery, args...)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetAuditLog QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		} else {
+			log.Println("Database error: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer rows.Close()
+
+	entries := []model.AuditEntry{}
+	for rows.Next() {
+		var entry model.AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.PersonID, &entry.Action, &entry.Entity,
+			&entry.EntityID, &before, &after, &entry.CreatedAt); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		entry.Before, entry.After = before, after
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,413 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+const batchMaxOperations = 100
+
+const (
+	batchOpAddActor             = "add_actor"
+	batchOpUpdateActor          = "update_actor"
+	batchOpDeleteActor          = "delete_actor"
+	batchOpAddMovie             = "add_movie"
+	batchOpUpdateMovie          = "update_movie"
+	batchOpDeleteMovie          = "delete_movie"
+	batchOpAddActorToMovie      = "add_actor_to_movie"
+	batchOpDeleteActorFromMovie = "delete_actor_from_movie"
+	batchOpLinkActor            = "link_actor"
+	batchOpUnlinkActor          = "unlink_actor"
+	batchOpSetCast              = "set_cast"
+	batchOpUpdateCredit         = "update_credit"
+	batchOpAddCrew              = "add_crew"
+	batchOpRemoveCrew           = "remove_crew"
+	batchOpAttachGenre          = "attach_genre"
+	batchOpDetachGenre          = "detach_genre"
+	batchOpAttachTag            = "attach_tag"
+	batchOpDetachTag            = "detach_tag"
+)
+
+// applyBatchRefs подставляет в поля data id, которые вернули предыдущие операции пакета
+func applyBatchRefs(data json.RawMessage, refs map[string]int, ids []int, index int) (json.RawMessage, error) {
+	if len(refs) == 0 {
+		return data, nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if len(data) > 0 && string(data) != "null" {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, badRequest("Operation data must be a JSON object")
+		}
+	}
+
+	for field, ref := range refs {
+		if ref < 0 || ref >= index {
+			return nil, badRequest(fmt.Sprintf("Reference %q must point to an earlier operation", field))
+		}
+
+		if ids[ref] == 0 {
+			return nil, badRequest(fmt.Sprintf("Operation %d did not return an id for %q", ref, field))
+		}
+
+		fields[field] = json.RawMessage(fmt.Sprint(ids[ref]))
+	}
+
+	return json.Marshal(fields)
+}
+
+// executeBatchOperation выполняет одну операцию пакета в общей транзакции.
+// Возвращает id созданной или изменённой записи и код ответа операции.
+func executeBatchOperation(ctx context.Context, tx *sql.Tx, issuer string, op model.BatchOperation,
+	ids []int, index int) (int, int, error) {
+	data, err := applyBatchRefs(op.Data, op.Refs, ids, index)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(data, v); err != nil {
+			return badRequest("Error reading operation data")
+		}
+		return nil
+	}
+
+	switch op.Op {
+	case batchOpAddActor, batchOpUpdateActor, batchOpDeleteActor:
+		var actor model.Actor
+		if err := decode(&actor); err != nil {
+			return 0, 0, err
+		}
+
+		if op.Op == batchOpDeleteActor {
+			if actor.ID == 0 {
+				return 0, 0, badRequest("id is not set, actor is deleted based on id")
+			}
+			return actor.ID, http.StatusOK, deleteActorTx(ctx, tx, issuer, actor.ID, op.IfMatch)
+		}
+
+		if err := validateActor(actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate); err != nil {
+			return 0, 0, err
+		}
+
+		if op.Op == batchOpAddActor {
+			id, err := addActorTx(ctx, tx, issuer, actor)
+			return id, http.StatusCreated, err
+		}
+
+		if actor.ID == 0 {
+			return 0, 0, badRequest("id is not set, actor is updated based on id")
+		}
+		return actor.ID, http.StatusOK, updateActorTx(ctx, tx, issuer, actor, op.IfMatch)
+
+	case batchOpAddMovie:
+		var movie model.Movie
+		if err := decode(&movie); err != nil {
+			return 0, 0, err
+		}
+
+		if err := validateNewMovie(movie); err != nil {
+			return 0, 0, err
+		}
+
+		id, err := addMovieTx(ctx, tx, issuer, movie)
+		return id, http.StatusCreated, err
+
+	case batchOpUpdateMovie, batchOpDeleteMovie:
+		var movie model.MovieUpdate
+		if err := decode(&movie); err != nil {
+			return 0, 0, err
+		}
+
+		if op.Op == batchOpDeleteMovie {
+			if movie.ID == 0 {
+				return 0, 0, badRequest("id is not set, movie is deleted based on id")
+			}
+			return movie.ID, http.StatusOK, deleteMovieTx(ctx, tx, issuer, movie.ID, op.IfMatch)
+		}
+
+		if err := validateMovieUpdate(movie); err != nil {
+			return 0, 0, err
+		}
+
+		if movie.ID == 0 {
+			return 0, 0, badRequest("id is not set, movie is updated based on id")
+		}
+		return movie.ID, http.StatusOK, updateMovieTx(ctx, tx, issuer, movie, op.IfMatch)
+
+	case batchOpAddActorToMovie:
+		var actorMovie model.ActorMovie
+		if err := decode(&actorMovie); err != nil {
+			return 0, 0, err
+		}
+
+		if actorMovie.MovieID == 0 {
+			return 0, 0, badRequest("Movie id cannot be empty")
+		}
+
+		if err := validateActor(actorMovie.FirstName, actorMovie.LastName, actorMovie.Sex, actorMovie.BirthDate); err != nil {
+			return 0, 0, err
+		}
+
+		if err := validateCredit(actorMovie.Character, actorMovie.BillingOrder, actorMovie.RoleType); err != nil {
+			return 0, 0, err
+		}
+
+		id, err := addActorToMovieTx(ctx, tx, issuer, actorMovie)
+		return id, http.StatusCreated, err
+
+	case batchOpDeleteActorFromMovie:
+		var actorMovie model.ID
+		if err := decode(&actorMovie); err != nil {
+			return 0, 0, err
+		}
+
+		if actorMovie.MovieID == 0 {
+			return 0, 0, badRequest("Movie id cannot be empty")
+		}
+
+		if actorMovie.ActorID == 0 {
+			return 0, 0, badRequest("Actor id cannot be empty")
+		}
+
+		return 0, http.StatusOK, deleteActorFromMovieTx(ctx, tx, issuer, actorMovie)
+
+	case batchOpLinkActor, batchOpUnlinkActor, batchOpUpdateCredit:
+		var link struct {
+			MovieID int `json:"movieID"`
+			model.Credit
+		}
+		if err := decode(&link); err != nil {
+			return 0, 0, err
+		}
+
+		if link.MovieID < 1 || link.ActorID < 1 {
+			return 0, 0, badRequest("movieID and actorID must be positive integers")
+		}
+
+		if op.Op == batchOpUnlinkActor {
+			return link.ActorID, http.StatusOK, unlinkActorTx(ctx, tx, issuer, link.MovieID, link.ActorID, op.IfMatch)
+		}
+
+		if err := validateCredit(link.Character, link.BillingOrder, link.RoleType); err != nil {
+			return 0, 0, err
+		}
+
+		if op.Op == batchOpLinkActor {
+			return link.ActorID, http.StatusCreated, linkActorTx(ctx, tx, issuer, link.MovieID, link.Credit, op.IfMatch)
+		}
+		return link.ActorID, http.StatusOK, updateCreditTx(ctx, tx, issuer, link.MovieID, link.Credit, op.IfMatch)
+
+	case batchOpSetCast:
+		var cast struct {
+			MovieID int `json:"movieID"`
+			model.Cast
+		}
+		if err := decode(&cast); err != nil {
+			return 0, 0, err
+		}
+
+		if cast.MovieID < 1 {
+			return 0, 0, badRequest("movieID must be a positive integer")
+		}
+
+		_, err := setCastTx(ctx, tx, issuer, cast.MovieID, cast.ActorIDs, op.IfMatch)
+		return cast.MovieID, http.StatusOK, err
+
+	case batchOpAddCrew, batchOpRemoveCrew:
+		var crew struct {
+			MovieID int `json:"movieID"`
+			model.CrewCredit
+		}
+		if err := decode(&crew); err != nil {
+			return 0, 0, err
+		}
+
+		if crew.MovieID < 1 || crew.PersonID < 1 {
+			return 0, 0, badRequest("movieID and personID must be positive integers")
+		}
+
+		if err := validateCrewCredit(crew.CrewCredit); err != nil {
+			return 0, 0, err
+		}
+
+		if op.Op == batchOpAddCrew {
+			return crew.PersonID, http.StatusCreated, addCrewTx(ctx, tx, issuer, crew.MovieID, crew.CrewCredit, op.IfMatch)
+		}
+		return crew.PersonID, http.StatusOK, removeCrewTx(ctx, tx, issuer, crew.MovieID, crew.CrewCredit, op.IfMatch)
+
+	case batchOpAttachGenre, batchOpDetachGenre, batchOpAttachTag, batchOpDetachTag:
+		var term struct {
+			MovieID int    `json:"movieID"`
+			GenreID int    `json:"genreID"`
+			TagID   int    `json:"tagID"`
+			Name    string `json:"name"`
+		}
+		if err := decode(&term); err != nil {
+			return 0, 0, err
+		}
+
+		if term.MovieID < 1 {
+			return 0, 0, badRequest("movieID must be a positive integer")
+		}
+
+		switch op.Op {
+		case batchOpAttachTag:
+			name := strings.TrimSpace(term.Name)
+			if err := validateTermName(tagTaxonomy, name); err != nil {
+				return 0, 0, err
+			}
+			tag, err := tagMovieTx(ctx, tx, issuer, term.MovieID, name, op.IfMatch)
+			return tag.ID, http.StatusCreated, err
+		case batchOpDetachTag:
+			if term.TagID < 1 {
+				return 0, 0, badRequest("tagID must be a positive integer")
+			}
+			return term.TagID, http.StatusOK, detachTermTx(ctx, tx, issuer, tagTaxonomy, term.MovieID, term.TagID, op.IfMatch)
+		}
+
+		if term.GenreID < 1 {
+			return 0, 0, badRequest("genreID must be a positive integer")
+		}
+
+		if op.Op == batchOpAttachGenre {
+			return term.GenreID, http.StatusCreated,
+				attachTermTx(ctx, tx, issuer, genreTaxonomy, term.MovieID, term.GenreID, op.IfMatch)
+		}
+		return term.GenreID, http.StatusOK, detachTermTx(ctx, tx, issuer, genreTaxonomy, term.MovieID, term.GenreID, op.IfMatch)
+	}
+
+	return 0, 0, badRequest(fmt.Sprintf("Unknown operation %q", op.Op))
+}
+
+// batchErrorStatus переводит ошибку операции в код и сообщение для результата операции
+func batchErrorStatus(ctx context.Context, err error) (int, string) {
+	var errCatalog *catalogError
+	if errors.As(err, &errCatalog) {
+		return errCatalog.status, errCatalog.message
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Println("Batch ExecContext deadline exceeded: ", err)
+		return http.StatusGatewayTimeout, "Database query time limit exceeded"
+	}
+
+	var errPQ *pq.Error
+	if errors.As(err, &errPQ) && errPQ.Code == "23505" {
+		log.Println("Batch record already exists: ", errPQ)
+		return http.StatusBadRequest, "Record with the same data already exists"
+	}
+
+	log.Println("Database error: ", err)
+	return http.StatusInternalServerError, "Internal server error"
+}
+
+// Batch выполняет список операций над каталогом в одной транзакции: либо применяются все,
+// либо ни одна. Для каждой операции возвращается её результат, операции после неудачной
+// не выполняются и получают код 424.
+func Batch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "run batch operations")
+	if !ok {
+		return
+	}
+
+	var request model.BatchRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if len(request.Operations) == 0 {
+		http.Error(w, "Operations list cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	if len(request.Operations) > batchMaxOperations {
+		http.Error(w, fmt.Sprintf("Maximum number of operations in a batch is %d", batchMaxOperations), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	response := model.BatchResponse{Results: make([]model.BatchResult, len(request.Operations))}
+	ids := make([]int, len(request.Operations))
+	failed := -1
+
+	for i, op := range request.Operations {
+		id, status, err := executeBatchOperation(ctx, tx, issuer, op, ids, i)
+
+		response.Results[i] = model.BatchResult{Index: i, Op: op.Op, Status: status, ID: id}
+
+		if err != nil {
+			response.Results[i].ID = 0
+			response.Results[i].Status, response.Results[i].Error = batchErrorStatus(ctx, err)
+			failed = i
+			break
+		}
+
+		ids[i] = id
+	}
+
+	status := http.StatusOK
+
+	if failed >= 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("Batch Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("Batch transaction rollback")
+		}
+
+		for i := failed + 1; i < len(request.Operations); i++ {
+			response.Results[i] = model.BatchResult{Index: i, Op: request.Operations[i].Op,
+				Status: http.StatusFailedDependency, Error: fmt.Sprintf("Not executed because operation %d failed", failed)}
+		}
+
+		status = response.Results[failed].Status
+	} else {
+		err = tx.Commit()
+		if err != nil {
+			log.Println("Batch error committing transaction: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		response.Committed = true
+
+		invalidateSuggestions()
+	}
+
+	resp, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
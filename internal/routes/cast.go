@@ -0,0 +1,268 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+// castOperation меняет состав фильма movieID в транзакции и возвращает тело успешного ответа
+type castOperation func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error)
+
+// LinkActor добавляет существующего актёра в состав фильма по id из пути.
+// Необязательное тело запроса содержит сведения о роли.
+func LinkActor(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "link actors to movies")
+	if !ok {
+		return
+	}
+
+	credit, ok := readCredit(w, r)
+	if !ok {
+		return
+	}
+
+	changeCast(w, r, issuer, "LinkActor", http.StatusCreated,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Actor linked to movie successfully",
+				linkActorTx(ctx, tx, issuer, movieID, credit, r.Header.Get("If-Match"))
+		})
+}
+
+// UpdateCredit заменяет сведения о роли актёра в фильме
+func UpdateCredit(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "update movie credits")
+	if !ok {
+		return
+	}
+
+	credit, ok := readCredit(w, r)
+	if !ok {
+		return
+	}
+
+	changeCast(w, r, issuer, "UpdateCredit", http.StatusOK,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Credit updated successfully",
+				updateCreditTx(ctx, tx, issuer, movieID, credit, r.Header.Get("If-Match"))
+		})
+}
+
+// readCredit читает сведения о роли из тела запроса и id актёра из пути. Пустое тело допустимо.
+// При ошибке ответ уже записан в w.
+func readCredit(w http.ResponseWriter, r *http.Request) (model.Credit, bool) {
+	var credit model.Credit
+
+	actorID, err := strconv.Atoi(r.PathValue("actorID"))
+	if err != nil || actorID < 1 {
+		http.Error(w, "actorID must be a positive integer", http.StatusBadRequest)
+		return credit, false
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&credit)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return credit, false
+	}
+
+	credit.ActorID = actorID
+
+	if err := validateCredit(credit.Character, credit.BillingOrder, credit.RoleType); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return credit, false
+	}
+
+	return credit, true
+}
+
+// UnlinkActor убирает актёра из состава фильма по id из пути
+func UnlinkActor(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "unlink actors from movies")
+	if !ok {
+		return
+	}
+
+	actorID, err := strconv.Atoi(r.PathValue("actorID"))
+	if err != nil || actorID < 1 {
+		http.Error(w, "actorID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeCast(w, r, issuer, "UnlinkActor", http.StatusOK,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Actor unlinked from movie successfully",
+				unlinkActorTx(ctx, tx, issuer, movieID, actorID, r.Header.Get("If-Match"))
+		})
+}
+
+// SetMovieCast заменяет весь состав фильма списком id актёров из тела запроса
+func SetMovieCast(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "set movie cast")
+	if !ok {
+		return
+	}
+
+	var cast model.Cast
+	err := json.NewDecoder(r.Body).Decode(&cast)
+
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	for _, actorID := range cast.ActorIDs {
+		if actorID < 1 {
+			http.Error(w, "actorIDs must be positive integers", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changeCast(w, r, issuer, "SetMovieCast", http.StatusOK,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return setCastTx(ctx, tx, issuer, movieID, cast.ActorIDs, r.Header.Get("If-Match"))
+		})
+}
+
+// changeCast проверяет id фильма из пути и выполняет operation в транзакции от имени issuer.
+// Права администратора проверяются в обработчике до чтения тела запроса.
+func changeCast(w http.ResponseWriter, r *http.Request, issuer, name string, status int, operation castOperation) {
+	movieID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || movieID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := operation(ctx, tx, issuer, movieID)
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("%s Failed to rollback transaction: %v\n", name, rollbackErr)
+		} else {
+			log.Println(name, "transaction rollback")
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println(name, errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(name, "error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// getMovieCast возвращает неудалённых актёров фильма в порядке титров.
+// Актёры без места в титрах идут последними.
+func getMovieCast(ctx context.Context, q queryer, movieID int) ([]model.CastMember, error) {
+	movieCastQuery := `
+	SELECT a.id, a.firstName, a.lastName, a.sex, a.birthDate,
+	COALESCE(ma.character_name, ''), COALESCE(ma.billing_order, 0), COALESCE(ma.role_type, '')
+	FROM actormovie ma
+	JOIN actor a ON a.id = ma.actor_id AND a.deleted_at IS NULL
+	WHERE ma.movie_id = $1
+	ORDER BY ma.billing_order NULLS LAST, a.lastName, a.firstName, a.id;
+	`
+
+	rows, err := q.QueryContext(ctx, movieCastQuery, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cast := []model.CastMember{}
+	for rows.Next() {
+		var member model.CastMember
+		if err := rows.Scan(&member.ID, &member.FirstName, &member.LastName, &member.Sex, &member.BirthDate,
+			&member.Character, &member.BillingOrder, &member.RoleType); err != nil {
+			return nil, err
+		}
+		cast = append(cast, member)
+	}
+
+	return cast, rows.Err()
+}
+
+// getFilmography возвращает неудалённые фильмы актёра по дате выхода вместе с ролями
+func getFilmography(ctx context.Context, q queryer, actorID int) ([]model.FilmographyEntry, error) {
+	filmographyQuery := `
+	SELECT m.id, m.name, m.description, m.date, m.rating,
+	COALESCE(ma.character_name, ''), COALESCE(ma.billing_order, 0), COALESCE(ma.role_type, '')
+	FROM actormovie ma
+	JOIN movie m ON m.id = ma.movie_id AND m.deleted_at IS NULL
+	WHERE ma.actor_id = $1
+	ORDER BY m.date, m.id;
+	`
+
+	rows, err := q.QueryContext(ctx, filmographyQuery, actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filmography := []model.FilmographyEntry{}
+	for rows.Next() {
+		var entry model.FilmographyEntry
+		if err := rows.Scan(&entry.ID, &entry.Name, &entry.Description, &entry.Date, &entry.Rating,
+			&entry.Character, &entry.BillingOrder, &entry.RoleType); err != nil {
+			return nil, err
+		}
+		filmography = append(filmography, entry)
+	}
+
+	return filmography, rows.Err()
+}
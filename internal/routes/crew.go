@@ -0,0 +1,342 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+// Люди хранятся в таблице actor: актёрские роли связаны с фильмами через ActorMovie,
+// остальное участие в фильме - через Crew с отделом и должностью.
+
+const departmentActing = "acting"
+
+// crewDepartments - допустимые отделы съёмочной группы
+var crewDepartments = map[string]bool{"directing": true, "writing": true, "production": true, "music": true}
+
+func validateCrewCredit(credit model.CrewCredit) error {
+	if !crewDepartments[credit.Department] {
+		return badRequest("Department must be one of directing, writing, production, music")
+	}
+
+	if len([]rune(credit.Job)) > 100 {
+		return badRequest("Maximum job string length is 100 symbols")
+	}
+
+	return nil
+}
+
+// addCrewTx добавляет человека credit.PersonID в съёмочную группу фильма
+func addCrewTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, credit model.CrewCredit, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityActor, credit.PersonID)
+	}
+
+	addCrewQuery := `
+	INSERT INTO Crew (person_id, movie_id, department, job)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT DO NOTHING;
+	`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, addCrewQuery, credit.PersonID, movieID, credit.Department, credit.Job)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusConflict, message: "Person already has this credit in the movie"}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityActor, credit.PersonID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "add_crew", auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// removeCrewTx убирает участие человека в фильме. Пустая должность убирает все должности в отделе.
+func removeCrewTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, credit model.CrewCredit, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	removeCrewQuery := `
+	DELETE FROM Crew
+	WHERE person_id = $1 AND movie_id = $2 AND department = $3 AND ($4 = '' OR job = $4);
+	`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, removeCrewQuery, credit.PersonID, movieID, credit.Department, credit.Job)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusNotFound, message: "Person has no such credit in the movie"}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityActor, credit.PersonID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "delete_crew", auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// readCrewCredit читает отдел и должность из тела запроса и id человека из пути.
+// При ошибке ответ уже записан в w.
+func readCrewCredit(w http.ResponseWriter, r *http.Request) (model.CrewCredit, bool) {
+	var credit model.CrewCredit
+
+	personID, err := strconv.Atoi(r.PathValue("personID"))
+	if err != nil || personID < 1 {
+		http.Error(w, "personID must be a positive integer", http.StatusBadRequest)
+		return credit, false
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&credit)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return credit, false
+	}
+
+	credit.PersonID = personID
+
+	if err := validateCrewCredit(credit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return credit, false
+	}
+
+	return credit, true
+}
+
+// AddCrew добавляет человека в съёмочную группу фильма
+func AddCrew(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "add movie crew")
+	if !ok {
+		return
+	}
+
+	credit, ok := readCrewCredit(w, r)
+	if !ok {
+		return
+	}
+
+	changeCast(w, r, issuer, "AddCrew", http.StatusCreated,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Crew credit added successfully",
+				addCrewTx(ctx, tx, issuer, movieID, credit, r.Header.Get("If-Match"))
+		})
+}
+
+// RemoveCrew убирает человека из съёмочной группы фильма
+func RemoveCrew(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "remove movie crew")
+	if !ok {
+		return
+	}
+
+	credit, ok := readCrewCredit(w, r)
+	if !ok {
+		return
+	}
+
+	changeCast(w, r, issuer, "RemoveCrew", http.StatusOK,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Crew credit removed successfully",
+				removeCrewTx(ctx, tx, issuer, movieID, credit, r.Header.Get("If-Match"))
+		})
+}
+
+// getMovieCrew возвращает неудалённых людей из съёмочной группы фильма по отделам
+func getMovieCrew(ctx context.Context, q queryer, movieID int) ([]model.CrewMember, error) {
+	movieCrewQuery := `
+	SELECT a.id, a.firstName, a.lastName, c.department, c.job
+	FROM Crew c
+	JOIN actor a ON a.id = c.person_id AND a.deleted_at IS NULL
+	WHERE c.movie_id = $1
+	ORDER BY c.department, a.lastName, a.firstName, a.id, c.job;
+	`
+
+	rows, err := q.QueryContext(ctx, movieCrewQuery, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	crew := []model.CrewMember{}
+	for rows.Next() {
+		var member model.CrewMember
+		if err := rows.Scan(&member.ID, &member.FirstName, &member.LastName, &member.Department, &member.Job); err != nil {
+			return nil, err
+		}
+		crew = append(crew, member)
+	}
+
+	return crew, rows.Err()
+}
+
+// getPersonCredits возвращает фильмографию человека по отделам. Если department не пуст,
+// возвращается только этот отдел.
+func getPersonCredits(ctx context.Context, q queryer, personID int, department string) (map[string][]model.PersonCredit, error) {
+	personCreditsQuery := `
+	SELECT department, m.id, m.name, m.description, m.date, m.rating, job, character_name, billing_order, role_type
+	FROM (
+		SELECT 'acting' AS department, ma.movie_id, '' AS job, COALESCE(ma.character_name, '') AS character_name,
+		COALESCE(ma.billing_order, 0) AS billing_order, COALESCE(ma.role_type, '') AS role_type
+		FROM actormovie ma
+		WHERE ma.actor_id = $1
+		UNION ALL
+		SELECT c.department, c.movie_id, c.job, '', 0, ''
+		FROM Crew c
+		WHERE c.person_id = $1
+	) credits
+	JOIN movie m ON m.id = credits.movie_id AND m.deleted_at IS NULL
+	WHERE $2 = '' OR department = $2
+	ORDER BY department, m.date, m.id, job;
+	`
+
+	rows, err := q.QueryContext(ctx, personCreditsQuery, personID, department)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := map[string][]model.PersonCredit{}
+	for rows.Next() {
+		var credit model.PersonCredit
+		var creditDepartment string
+		if err := rows.Scan(&creditDepartment, &credit.ID, &credit.Name, &credit.Description, &credit.Date,
+			&credit.Rating, &credit.Job, &credit.Character, &credit.BillingOrder, &credit.RoleType); err != nil {
+			return nil, err
+		}
+		departments[creditDepartment] = append(departments[creditDepartment], credit)
+	}
+
+	return departments, rows.Err()
+}
+
+// GetPerson возвращает человека по id из пути вместе с фильмографией по отделам.
+// Параметр department оставляет в фильмографии только один отдел. ETag совпадает с ETag актёра.
+func GetPerson(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	department := r.URL.Query().Get("department")
+	if department != "" && department != departmentActing && !crewDepartments[department] {
+		http.Error(w, "department must be one of acting, directing, writing, production, music", http.StatusBadRequest)
+		return
+	}
+
+	getPersonQuery := `
+	SELECT id, firstName, lastName, sex, birthDate, version FROM actor
+	WHERE id = $1 AND deleted_at IS NULL;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	var person model.PersonDetails
+	var version int
+
+	err = db.QueryRowContext(ctx, getPersonQuery, id).Scan(&person.ID, &person.FirstName, &person.LastName,
+		&person.Sex, &person.BirthDate, &version)
+	if err == nil {
+		person.Departments, err = getPersonCredits(ctx, db, id, department)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Person not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetPerson QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	etag := formatETag(version)
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp, err := json.Marshal(person)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,428 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+const (
+	purgeDefaultRetentionDays   = 30
+	purgeDefaultIntervalMinutes = 60
+)
+
+func RestoreActor(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "restore actors")
+	if !ok {
+		return
+	}
+
+	var actor model.Actor
+	err := json.NewDecoder(r.Body).Decode(&actor)
+
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if actor.ID == 0 {
+		http.Error(w,
+			"id is not set, actor is restored based on id. Please set id and make a request again",
+			http.StatusBadRequest)
+		return
+	}
+
+	restoreActorQuery := `UPDATE actor SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL;`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	before, err := snapshot(ctx, tx, auditEntityActor, actor.ID)
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, restoreActorQuery, actor.ID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = auditChange(ctx, tx, issuer, "restore_actor", auditEntityActor, actor.ID, before)
+	}
+
+	if err != nil || rowsAffected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("RestoreActor Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("RestoreActor transaction rollback")
+		}
+
+		if err == nil {
+			log.Println("RestoreActor deleted actor doesn't exist, no rows affected")
+			http.Error(w, "Deleted actor doesn't exist. Nothing restored", http.StatusBadRequest)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("RestoreActor ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		// После удаления мог быть добавлен актёр с теми же именем, фамилией и датой рождения
+		var errPQ *pq.Error
+		if errors.As(err, &errPQ) {
+			if errPQ.Code == "23505" {
+				log.Println("RestoreActor actor already exists: ", errPQ)
+				http.Error(w, "Actor already exits", http.StatusBadRequest)
+				return
+			}
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("RestoreActor error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	invalidateSuggestions()
+
+	resp, err := json.Marshal("Actor restored successfully")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func RestoreMovie(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "restore movies")
+	if !ok {
+		return
+	}
+
+	var movie model.Movie
+	err := json.NewDecoder(r.Body).Decode(&movie)
+
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if movie.ID == 0 {
+		http.Error(w,
+			"id is not set, movie is restored based on id. Please set id and make a request again",
+			http.StatusBadRequest)
+		return
+	}
+
+	restoreMovieQuery := `UPDATE movie SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL;`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	before, err := snapshot(ctx, tx, auditEntityMovie, movie.ID)
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, restoreMovieQuery, movie.ID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = auditChange(ctx, tx, issuer, "restore_movie", auditEntityMovie, movie.ID, before)
+	}
+
+	if err != nil || rowsAffected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("RestoreMovie Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("RestoreMovie transaction rollback")
+		}
+
+		if err == nil {
+			log.Println("RestoreMovie deleted movie doesn't exist, no rows affected")
+			http.Error(w, "Deleted movie doesn't exist. Nothing restored", http.StatusBadRequest)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("RestoreMovie ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		// После удаления мог быть добавлен фильм с тем же названием и датой
+		var errPQ *pq.Error
+		if errors.As(err, &errPQ) {
+			if errPQ.Code == "23505" {
+				log.Println("RestoreMovie movie already exists: ", errPQ)
+				http.Error(w, "Movie already exits", http.StatusBadRequest)
+				return
+			}
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("RestoreMovie error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	invalidateSuggestions()
+
+	resp, err := json.Marshal("Movie restored successfully")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetDeletedItems возвращает удалённых, но ещё не очищенных актёров и фильмы
+func GetDeletedItems(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "get deleted items"); !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	items, err := queryDeletedItems(ctx)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetDeletedItems QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(items)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+func queryDeletedItems(ctx context.Context) (model.DeletedItems, error) {
+	items := model.DeletedItems{Actors: []model.DeletedActor{}, Movies: []model.DeletedMovie{}}
+
+	deletedActorsQuery := `
+	SELECT id, firstName, lastName, sex, birthDate, deleted_at FROM actor
+	WHERE deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id;
+	`
+
+	rows, err := db.QueryContext(ctx, deletedActorsQuery)
+	if err != nil {
+		return items, err
+	}
+	for rows.Next() {
+		var actor model.DeletedActor
+		if err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName,
+			&actor.Sex, &actor.BirthDate, &actor.DeletedAt); err != nil {
+			rows.Close()
+			return items, err
+		}
+		items.Actors = append(items.Actors, actor)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return items, err
+	}
+
+	deletedMoviesQuery := `
+	SELECT id, name, description, date, rating, deleted_at FROM movie
+	WHERE deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id;
+	`
+
+	rows, err = db.QueryContext(ctx, deletedMoviesQuery)
+	if err != nil {
+		return items, err
+	}
+	for rows.Next() {
+		var movie model.DeletedMovie
+		if err := rows.Scan(&movie.ID, &movie.Name, &movie.Description,
+			&movie.Date, &movie.Rating, &movie.DeletedAt); err != nil {
+			rows.Close()
+			return items, err
+		}
+		items.Movies = append(items.Movies, movie)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return items, err
+	}
+
+	return items, nil
+}
+
+// purgeDeleted окончательно удаляет фильмы и актёров, удалённые раньше, чем retention назад,
+// вместе с их ревизиями. Связи в actormovie удаляются каскадно внешними ключами.
+func purgeDeleted(ctx context.Context, retention time.Duration) (int64, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	purgeMoviesQuery := `
+	WITH purged AS (
+		DELETE FROM movie WHERE deleted_at < now() - make_interval(secs => $1) RETURNING id
+	), revisions AS (
+		DELETE FROM revision WHERE entity = 'movie' AND entity_id IN (SELECT id FROM purged)
+	)
+	SELECT COUNT(*) FROM purged;
+	`
+
+	var movies int64
+	err = tx.QueryRowContext(ctx, purgeMoviesQuery, retention.Seconds()).Scan(&movies)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	purgeActorsQuery := `
+	WITH purged AS (
+		DELETE FROM actor WHERE deleted_at < now() - make_interval(secs => $1) RETURNING id
+	), revisions AS (
+		DELETE FROM revision WHERE entity = 'actor' AND entity_id IN (SELECT id FROM purged)
+	)
+	SELECT COUNT(*) FROM purged;
+	`
+
+	var actors int64
+	err = tx.QueryRowContext(ctx, purgeActorsQuery, retention.Seconds()).Scan(&actors)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return movies, actors, tx.Commit()
+}
+
+// positiveIntEnv читает целое положительное значение переменной среды name, если она не задана - def
+func positiveIntEnv(name string, def int) (int, error) {
+	str := os.Getenv(name)
+	if str == "" {
+		return def, nil
+	}
+
+	value, err := strconv.Atoi(str)
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("environment variable %s must be a positive integer", name)
+	}
+
+	return value, nil
+}
+
+// StartPurgeJob запускает фоновую очистку удалённых записей.
+// Срок хранения задаётся PURGE_RETENTION_DAYS, период запуска - PURGE_INTERVAL_MINUTES.
+func StartPurgeJob() error {
+	retentionDays, err := positiveIntEnv("PURGE_RETENTION_DAYS", purgeDefaultRetentionDays)
+	if err != nil {
+		return err
+	}
+	intervalMinutes, err := positiveIntEnv("PURGE_INTERVAL_MINUTES", purgeDefaultIntervalMinutes)
+	if err != nil {
+		return err
+	}
+
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+	interval := time.Duration(intervalMinutes) * time.Minute
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+			movies, actors, err := purgeDeleted(ctx, retention)
+			cancel()
+
+			if err != nil {
+				log.Println("Purge of deleted items failed: ", err)
+			} else if movies > 0 || actors > 0 {
+				log.Printf("Purged %d deleted movies and %d deleted actors\n", movies, actors)
+				invalidateSuggestions()
+			}
+
+			<-ticker.C
+		}
+	}()
+
+	return nil
+}
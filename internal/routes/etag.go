@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// errPreconditionFailed возвращается, если версия записи не совпадает с заголовком If-Match
+var errPreconditionFailed = errors.New("precondition failed")
+
+// versionQueries возвращают текущую версию неудалённой записи
+var versionQueries = map[string]string{
+	auditEntityActor: `SELECT version FROM actor WHERE id = $1 AND deleted_at IS NULL;`,
+	auditEntityMovie: `SELECT version FROM movie WHERE id = $1 AND deleted_at IS NULL;`,
+}
+
+// bumpVersionQueries увеличивают версию записи, когда меняются связанные с ней данные
+var bumpVersionQueries = map[string]string{
+	auditEntityActor: `UPDATE actor SET version = version + 1 WHERE id = $1;`,
+	auditEntityMovie: `UPDATE movie SET version = version + 1 WHERE id = $1;`,
+}
+
+// formatETag строит ETag по версии записи
+func formatETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// formatStateETag строит ETag по версии записи и данным ответа, которые версию не меняют: оценке текущего
+// пользователя, его отметкам «буду смотреть» и «просмотрено», средней оценке пользователей. Такой ETag у разных пользователей разный, поэтому ответ
+// отправляется с Vary: Cookie, а в If-Match сверяется только версия.
+func formatStateETag(version int, state ...interface{}) string {
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%v", state)
+
+	return fmt.Sprintf(`"%d-%08x"`, version, hash.Sum32())
+}
+
+// versionETags оставляет в ETag из заголовка только версии записей, отбрасывая часть formatStateETag
+func versionETags(header string) string {
+	candidates := strings.Split(header, ",")
+
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if j := strings.Index(candidate, "-"); j >= 0 && strings.HasSuffix(candidate, `"`) {
+			candidate = candidate[:j] + `"`
+		}
+		candidates[i] = candidate
+	}
+
+	return strings.Join(candidates, ",")
+}
+
+// etagMatches проверяет, есть ли etag в списке из заголовка If-Match или If-None-Match.
+// При слабом сравнении (If-None-Match) префикс W/ не учитывается, при сильном (If-Match)
+// слабые ETag не совпадают ни с чем.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch сверяет версию записи с заголовком If-Match в транзакции изменения.
+// Пустой заголовок означает безусловное изменение. Если записи нет, условие не выполняется.
+// ETag из formatStateETag сверяется только по версии.
+func checkIfMatch(ctx context.Context, tx *sql.Tx, entity string, id int, header string) error {
+	if header == "" {
+		return nil
+	}
+
+	var version int
+
+	err := tx.QueryRowContext(ctx, versionQueries[entity], id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errPreconditionFailed
+	}
+	if err != nil {
+		return err
+	}
+
+	if !etagMatches(versionETags(header), formatETag(version), false) {
+		return errPreconditionFailed
+	}
+
+	return nil
+}
+
+// bumpVersion меняет версию записи без изменения её полей.
+// Версию увеличивает триггер, поэтому значение растёт ровно на единицу.
+func bumpVersion(ctx context.Context, tx *sql.Tx, entity string, id int) error {
+	_, err := tx.ExecContext(ctx, bumpVersionQueries[entity], id)
+	return err
+}
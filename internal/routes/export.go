@@ -0,0 +1,773 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+const (
+	exportDatasetMovies      = "movies"
+	exportDatasetActors      = "actors"
+	exportDatasetActorMovies = "actor-movies"
+	exportDatasetCrew        = "crew"
+	exportDatasetGenres      = "genres"
+	exportDatasetTags        = "tags"
+	exportDatasetMovieGenres = "movie-genres"
+	exportDatasetMovieTags   = "movie-tags"
+)
+
+// exportFlushEvery - через сколько строк накопленная часть выгрузки отправляется клиенту
+const exportFlushEvery = 500
+
+const (
+	archiveFormat  = "filmoteka-archive"
+	archiveVersion = 2
+	// archiveMinVersion - самая старая версия архива, которую можно восстановить.
+	// Версия 1 отличается от 2 только отсутствием сведений о фильмах.
+	archiveMinVersion = 1
+)
+
+const (
+	archiveTypeActor      = "actor"
+	archiveTypeMovie      = "movie"
+	archiveTypeActorMovie = "actorMovie"
+	archiveTypeCrew       = "crew"
+	archiveTypeGenre      = "genre"
+	archiveTypeTag        = "tag"
+	archiveTypeMovieGenre = "movieGenre"
+	archiveTypeMovieTag   = "movieTag"
+	archiveTypeEnd        = "end"
+)
+
+var (
+	errInvalidArchive         = errors.New("invalid archive")
+	errArchiveCatalogNotEmpty = errors.New("catalog is not empty")
+)
+
+// exportDataset описывает выгрузку одной таблицы. Параметр $1 запроса включает в выгрузку
+// удалённые записи, это нужно только архиву. Запрос справочника с complete выгружает его целиком
+// и параметров не принимает. scan возвращает строку для NDJSON и для CSV.
+type exportDataset struct {
+	query    string
+	columns  []string
+	complete bool
+	scan     func(rows *sql.Rows) (interface{}, []string, error)
+}
+
+var exportDatasets = map[string]exportDataset{
+	exportDatasetActors: {
+		query: `
+		SELECT id, firstName, lastName, sex, birthDate, deleted_at FROM actor
+		WHERE $1 OR deleted_at IS NULL
+		ORDER BY id;
+		`,
+		columns: []string{"id", "firstName", "lastName", "sex", "birthDate"},
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var actor model.ExportActor
+			var deletedAt sql.NullTime
+
+			err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName, &actor.Sex, &actor.BirthDate, &deletedAt)
+			if deletedAt.Valid {
+				actor.DeletedAt = &deletedAt.Time
+			}
+
+			return actor, []string{strconv.Itoa(actor.ID), actor.FirstName, actor.LastName, actor.Sex,
+				actor.BirthDate.Format("2006-01-02")}, err
+		},
+	},
+	exportDatasetMovies: {
+		query: `
+		SELECT id, name, description, date, rating, COALESCE(runtime, 0), countries,
+		COALESCE(original_language, ''), COALESCE(original_title, ''), COALESCE(age_certification, ''),
+		COALESCE(imdb_id, ''), COALESCE(tmdb_id, ''), COALESCE(kinopoisk_id, ''), deleted_at
+		FROM movie
+		WHERE $1 OR deleted_at IS NULL
+		ORDER BY id;
+		`,
+		columns: []string{"id", "name", "description", "date", "rating", "runtime", "countries",
+			"originalLanguage", "originalTitle", "ageCertification", "imdbID", "tmdbID", "kinopoiskID"},
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var movie model.ExportMovie
+			var deletedAt sql.NullTime
+
+			err := rows.Scan(&movie.ID, &movie.Name, &movie.Description, &movie.Date, &movie.Rating,
+				&movie.Runtime, pq.Array(&movie.Countries), &movie.OriginalLanguage, &movie.OriginalTitle,
+				&movie.AgeCertification, &movie.IMDbID, &movie.TMDbID, &movie.KinopoiskID, &deletedAt)
+			if deletedAt.Valid {
+				movie.DeletedAt = &deletedAt.Time
+			}
+
+			runtime := ""
+			if movie.Runtime > 0 {
+				runtime = strconv.Itoa(movie.Runtime)
+			}
+
+			return movie, []string{strconv.Itoa(movie.ID), movie.Name, movie.Description,
+				movie.Date.Format("2006-01-02"), strconv.Itoa(int(movie.Rating)), runtime,
+				strings.Join(movie.Countries, "|"), movie.OriginalLanguage, movie.OriginalTitle,
+				movie.AgeCertification, movie.IMDbID, movie.TMDbID, movie.KinopoiskID}, err
+		},
+	},
+	exportDatasetActorMovies: {
+		query: `
+		SELECT am.actor_id, am.movie_id, COALESCE(am.character_name, ''), COALESCE(am.billing_order, 0),
+		COALESCE(am.role_type, '')
+		FROM actormovie am
+		JOIN actor a ON a.id = am.actor_id
+		JOIN movie m ON m.id = am.movie_id
+		WHERE $1 OR (a.deleted_at IS NULL AND m.deleted_at IS NULL)
+		ORDER BY am.movie_id, am.actor_id;
+		`,
+		columns: []string{"actorID", "movieID", "character", "billingOrder", "roleType"},
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var actorMovie model.ExportActorMovie
+
+			err := rows.Scan(&actorMovie.ActorID, &actorMovie.MovieID, &actorMovie.Character,
+				&actorMovie.BillingOrder, &actorMovie.RoleType)
+
+			billingOrder := ""
+			if actorMovie.BillingOrder > 0 {
+				billingOrder = strconv.Itoa(actorMovie.BillingOrder)
+			}
+
+			return actorMovie, []string{strconv.Itoa(actorMovie.ActorID), strconv.Itoa(actorMovie.MovieID),
+				actorMovie.Character, billingOrder, actorMovie.RoleType}, err
+		},
+	},
+	exportDatasetCrew: {
+		query: `
+		SELECT c.person_id, c.movie_id, c.department, c.job
+		FROM crew c
+		JOIN actor a ON a.id = c.person_id
+		JOIN movie m ON m.id = c.movie_id
+		WHERE $1 OR (a.deleted_at IS NULL AND m.deleted_at IS NULL)
+		ORDER BY c.movie_id, c.person_id, c.department, c.job;
+		`,
+		columns: []string{"personID", "movieID", "department", "job"},
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var crew model.ExportCrew
+
+			err := rows.Scan(&crew.PersonID, &crew.MovieID, &crew.Department, &crew.Job)
+
+			return crew, []string{strconv.Itoa(crew.PersonID), strconv.Itoa(crew.MovieID),
+				crew.Department, crew.Job}, err
+		},
+	},
+	// Жанры и теги - справочники, которые ведут администраторы, поэтому выгружаются и записи без фильмов
+	exportDatasetGenres: {
+		query: `
+		SELECT id, name FROM genre
+		ORDER BY id;
+		`,
+		columns:  []string{"id", "name"},
+		complete: true,
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var genre model.Genre
+
+			err := rows.Scan(&genre.ID, &genre.Name)
+
+			return genre, []string{strconv.Itoa(genre.ID), genre.Name}, err
+		},
+	},
+	exportDatasetTags: {
+		query: `
+		SELECT id, name FROM tag
+		ORDER BY id;
+		`,
+		columns:  []string{"id", "name"},
+		complete: true,
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var tag model.Tag
+
+			err := rows.Scan(&tag.ID, &tag.Name)
+
+			return tag, []string{strconv.Itoa(tag.ID), tag.Name}, err
+		},
+	},
+	exportDatasetMovieGenres: {
+		query: `
+		SELECT mg.movie_id, mg.genre_id
+		FROM moviegenre mg
+		JOIN movie m ON m.id = mg.movie_id
+		WHERE $1 OR m.deleted_at IS NULL
+		ORDER BY mg.movie_id, mg.genre_id;
+		`,
+		columns: []string{"movieID", "genreID"},
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var movieGenre model.ExportMovieGenre
+
+			err := rows.Scan(&movieGenre.MovieID, &movieGenre.GenreID)
+
+			return movieGenre, []string{strconv.Itoa(movieGenre.MovieID), strconv.Itoa(movieGenre.GenreID)}, err
+		},
+	},
+	exportDatasetMovieTags: {
+		query: `
+		SELECT mt.movie_id, mt.tag_id
+		FROM movietag mt
+		JOIN movie m ON m.id = mt.movie_id
+		WHERE $1 OR m.deleted_at IS NULL
+		ORDER BY mt.movie_id, mt.tag_id;
+		`,
+		columns: []string{"movieID", "tagID"},
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var movieTag model.ExportMovieTag
+
+			err := rows.Scan(&movieTag.MovieID, &movieTag.TagID)
+
+			return movieTag, []string{strconv.Itoa(movieTag.MovieID), strconv.Itoa(movieTag.TagID)}, err
+		},
+	},
+}
+
+// flushExport отправляет клиенту уже записанную часть ответа, если w - http.ResponseWriter
+func flushExport(w io.Writer) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// streamDataset построчно передаёт результат запроса выгрузки в emit, не загружая его в память.
+// Каждые exportFlushEvery строк вызывается flush.
+func streamDataset(ctx context.Context, q queryer, dataset exportDataset, includeDeleted bool,
+	emit func(value interface{}, record []string) error, flush func()) (int, error) {
+	args := []interface{}{includeDeleted}
+	if dataset.complete {
+		args = nil
+	}
+
+	rows, err := q.QueryContext(ctx, dataset.query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		value, record, err := dataset.scan(rows)
+		if err != nil {
+			return count, err
+		}
+
+		if err := emit(value, record); err != nil {
+			return count, err
+		}
+
+		count++
+		if count%exportFlushEvery == 0 {
+			flush()
+		}
+	}
+
+	return count, rows.Err()
+}
+
+// WriteExport выгружает неудалённые записи набора name (movies, actors, actor-movies, crew, genres, tags,
+// movie-genres или movie-tags) в формате csv или ndjson и возвращает количество выгруженных строк
+func WriteExport(ctx context.Context, w io.Writer, name, format string) (int, error) {
+	dataset, ok := exportDatasets[name]
+	if !ok {
+		return 0, errors.New("dataset must be movies, actors, actor-movies, crew, genres, tags, movie-genres or movie-tags")
+	}
+
+	switch format {
+	case importFormatCSV:
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(dataset.columns); err != nil {
+			return 0, err
+		}
+
+		count, err := streamDataset(ctx, db, dataset, false, func(_ interface{}, record []string) error {
+			return csvWriter.Write(record)
+		}, func() {
+			csvWriter.Flush()
+			flushExport(w)
+		})
+
+		csvWriter.Flush()
+		if err == nil {
+			err = csvWriter.Error()
+		}
+
+		return count, err
+	case importFormatNDJSON:
+		encoder := json.NewEncoder(w)
+
+		return streamDataset(ctx, db, dataset, false, func(value interface{}, _ []string) error {
+			return encoder.Encode(value)
+		}, func() {
+			flushExport(w)
+		})
+	default:
+		return 0, errors.New("format must be csv or ndjson")
+	}
+}
+
+// WriteArchive выгружает весь каталог, включая удалённые записи, в архив формата NDJSON:
+// заголовок с версией формата, актёры, фильмы, связи и завершающая строка с количеством записей.
+// Все таблицы читаются в одной транзакции REPEATABLE READ, поэтому архив согласован.
+func WriteArchive(ctx context.Context, w io.Writer) (model.ArchiveCounts, error) {
+	var counts model.ArchiveCounts
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback()
+
+	encoder := json.NewEncoder(w)
+
+	err = encoder.Encode(model.ArchiveHeader{Format: archiveFormat, Version: archiveVersion, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return counts, err
+	}
+
+	parts := []struct {
+		dataset    string
+		recordType string
+		count      *int
+	}{
+		{exportDatasetActors, archiveTypeActor, &counts.Actors},
+		{exportDatasetMovies, archiveTypeMovie, &counts.Movies},
+		{exportDatasetActorMovies, archiveTypeActorMovie, &counts.ActorMovies},
+		{exportDatasetCrew, archiveTypeCrew, &counts.Crew},
+		{exportDatasetGenres, archiveTypeGenre, &counts.Genres},
+		{exportDatasetTags, archiveTypeTag, &counts.Tags},
+		{exportDatasetMovieGenres, archiveTypeMovieGenre, &counts.MovieGenres},
+		{exportDatasetMovieTags, archiveTypeMovieTag, &counts.MovieTags},
+	}
+
+	for _, part := range parts {
+		*part.count, err = streamDataset(ctx, tx, exportDatasets[part.dataset], true,
+			func(value interface{}, _ []string) error {
+				data, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				return encoder.Encode(model.ArchiveRecord{Type: part.recordType, Data: data})
+			}, func() {
+				flushExport(w)
+			})
+		if err != nil {
+			return counts, err
+		}
+	}
+
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return counts, err
+	}
+
+	return counts, encoder.Encode(model.ArchiveRecord{Type: archiveTypeEnd, Data: data})
+}
+
+// restoreArchiveRecord добавляет одну запись архива с её исходным id
+func restoreArchiveRecord(ctx context.Context, tx *sql.Tx, record model.ArchiveRecord, counts *model.ArchiveCounts) error {
+	switch record.Type {
+	case archiveTypeActor:
+		var actor model.ExportActor
+		if err := json.Unmarshal(record.Data, &actor); err != nil {
+			return fmt.Errorf("%w: actor: %v", errInvalidArchive, err)
+		}
+
+		restoreActorQuery := `
+		INSERT INTO actor (id, firstName, lastName, sex, birthDate, deleted_at)
+		OVERRIDING SYSTEM VALUE
+		VALUES ($1, $2, $3, $4, $5, $6);
+		`
+
+		_, err := tx.ExecContext(ctx, restoreActorQuery, actor.ID, actor.FirstName, actor.LastName,
+			actor.Sex, actor.BirthDate, actor.DeletedAt)
+		counts.Actors++
+
+		return err
+	case archiveTypeMovie:
+		var movie model.ExportMovie
+		if err := json.Unmarshal(record.Data, &movie); err != nil {
+			return fmt.Errorf("%w: movie: %v", errInvalidArchive, err)
+		}
+
+		restoreMovieQuery := `
+		INSERT INTO movie (id, name, description, date, rating, runtime, countries, original_language,
+			original_title, age_certification, imdb_id, tmdb_id, kinopoisk_id, deleted_at)
+		OVERRIDING SYSTEM VALUE
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6::integer, 0), COALESCE($7::varchar[], '{}'), NULLIF($8, ''),
+			NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), $14);
+		`
+
+		_, err := tx.ExecContext(ctx, restoreMovieQuery, movie.ID, movie.Name, movie.Description,
+			movie.Date, movie.Rating, movie.Runtime, pq.Array(movie.Countries), movie.OriginalLanguage,
+			movie.OriginalTitle, movie.AgeCertification, movie.IMDbID, movie.TMDbID, movie.KinopoiskID,
+			movie.DeletedAt)
+		counts.Movies++
+
+		return err
+	case archiveTypeActorMovie:
+		var actorMovie model.ExportActorMovie
+		if err := json.Unmarshal(record.Data, &actorMovie); err != nil {
+			return fmt.Errorf("%w: actorMovie: %v", errInvalidArchive, err)
+		}
+
+		restoreActorMovieQuery := `
+		INSERT INTO ActorMovie (actor_id, movie_id, character_name, billing_order, role_type)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, ''));
+		`
+
+		_, err := tx.ExecContext(ctx, restoreActorMovieQuery, actorMovie.ActorID, actorMovie.MovieID,
+			actorMovie.Character, actorMovie.BillingOrder, actorMovie.RoleType)
+		counts.ActorMovies++
+
+		return err
+	case archiveTypeCrew:
+		var crew model.ExportCrew
+		if err := json.Unmarshal(record.Data, &crew); err != nil {
+			return fmt.Errorf("%w: crew: %v", errInvalidArchive, err)
+		}
+
+		restoreCrewQuery := `
+		INSERT INTO Crew (person_id, movie_id, department, job)
+		VALUES ($1, $2, $3, $4);
+		`
+
+		_, err := tx.ExecContext(ctx, restoreCrewQuery, crew.PersonID, crew.MovieID, crew.Department, crew.Job)
+		counts.Crew++
+
+		return err
+	case archiveTypeGenre, archiveTypeTag:
+		var term model.Genre
+		if err := json.Unmarshal(record.Data, &term); err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidArchive, record.Type, err)
+		}
+
+		// Тип записи совпадает с именем таблицы
+		restoreTermQuery := `
+		INSERT INTO ` + record.Type + ` (id, name)
+		OVERRIDING SYSTEM VALUE
+		VALUES ($1, $2);
+		`
+
+		_, err := tx.ExecContext(ctx, restoreTermQuery, term.ID, term.Name)
+		if record.Type == archiveTypeGenre {
+			counts.Genres++
+		} else {
+			counts.Tags++
+		}
+
+		return err
+	case archiveTypeMovieGenre:
+		var movieGenre model.ExportMovieGenre
+		if err := json.Unmarshal(record.Data, &movieGenre); err != nil {
+			return fmt.Errorf("%w: movieGenre: %v", errInvalidArchive, err)
+		}
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO MovieGenre (movie_id, genre_id) VALUES ($1, $2);`,
+			movieGenre.MovieID, movieGenre.GenreID)
+		counts.MovieGenres++
+
+		return err
+	case archiveTypeMovieTag:
+		var movieTag model.ExportMovieTag
+		if err := json.Unmarshal(record.Data, &movieTag); err != nil {
+			return fmt.Errorf("%w: movieTag: %v", errInvalidArchive, err)
+		}
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO MovieTag (movie_id, tag_id) VALUES ($1, $2);`,
+			movieTag.MovieID, movieTag.TagID)
+		counts.MovieTags++
+
+		return err
+	default:
+		return fmt.Errorf("%w: unknown record type %q", errInvalidArchive, record.Type)
+	}
+}
+
+// archiveUserTables - данные пользователей, которые ссылаются на фильмы каталога и удаляются вместе с ними.
+// В архив они не входят, поэтому при замене каталога сохраняются во временных таблицах restore_<таблица>
+// и после восстановления возвращаются запросом relinkUserDataQuery.
+var archiveUserTables = []string{"userrating", "review", "reviewreport", "watchlist", "watchedmovie", "movielistitem"}
+
+// relinkUserDataQuery возвращает данные пользователей к восстановленным фильмам с теми же id.
+// Данные фильмов, которых нет в архиве, отбрасываются, позиции в списках сдвигаются без пропусков.
+// Оценки добавляются через триггер, поэтому средняя оценка фильмов пересчитывается.
+const relinkUserDataQuery = `
+	INSERT INTO userrating
+	SELECT r.* FROM restore_userrating r WHERE EXISTS (SELECT 1 FROM movie m WHERE m.id = r.movie_id);
+
+	INSERT INTO review OVERRIDING SYSTEM VALUE
+	SELECT r.* FROM restore_review r WHERE EXISTS (SELECT 1 FROM movie m WHERE m.id = r.movie_id);
+
+	INSERT INTO reviewreport
+	SELECT r.* FROM restore_reviewreport r WHERE EXISTS (SELECT 1 FROM review v WHERE v.id = r.review_id);
+
+	INSERT INTO watchlist
+	SELECT r.* FROM restore_watchlist r WHERE EXISTS (SELECT 1 FROM movie m WHERE m.id = r.movie_id);
+
+	INSERT INTO watchedmovie
+	SELECT r.* FROM restore_watchedmovie r WHERE EXISTS (SELECT 1 FROM movie m WHERE m.id = r.movie_id);
+
+	INSERT INTO movielistitem (list_id, movie_id, position, added_at)
+	SELECT r.list_id, r.movie_id, row_number() OVER (PARTITION BY r.list_id ORDER BY r.position), r.added_at
+	FROM restore_movielistitem r WHERE EXISTS (SELECT 1 FROM movie m WHERE m.id = r.movie_id);
+	`
+
+// RestoreArchive восстанавливает каталог из архива WriteArchive с исходными id одной транзакцией.
+// Если в каталоге уже есть записи, восстановление выполняется только при replace: тогда актёры,
+// фильмы, их связи, съёмочные группы и ревизии предварительно удаляются, а оценки, рецензии, списки
+// и история просмотров пользователей возвращаются к фильмам архива с теми же id. Жанры и теги всегда
+// заменяются жанрами и тегами из архива. Журнал аудита не меняется.
+func RestoreArchive(ctx context.Context, r io.Reader, replace bool) (model.ArchiveCounts, error) {
+	var counts model.ArchiveCounts
+
+	decoder := json.NewDecoder(r)
+
+	var header model.ArchiveHeader
+	if err := decoder.Decode(&header); err != nil {
+		return counts, fmt.Errorf("%w: header: %v", errInvalidArchive, err)
+	}
+
+	if header.Format != archiveFormat {
+		return counts, fmt.Errorf("%w: format must be %s", errInvalidArchive, archiveFormat)
+	}
+
+	if header.Version < archiveMinVersion || header.Version > archiveVersion {
+		return counts, fmt.Errorf("%w: unsupported version %d", errInvalidArchive, header.Version)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback()
+
+	var catalogExists bool
+
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM actor) OR EXISTS (SELECT 1 FROM movie);`).Scan(&catalogExists)
+	if err != nil {
+		return counts, err
+	}
+
+	if catalogExists && !replace {
+		return counts, errArchiveCatalogNotEmpty
+	}
+
+	if catalogExists {
+		for _, table := range archiveUserTables {
+			saveQuery := `CREATE TEMP TABLE restore_` + table + ` ON COMMIT DROP AS SELECT * FROM ` + table + `;`
+			if _, err := tx.ExecContext(ctx, saveQuery); err != nil {
+				return counts, err
+			}
+		}
+
+		clearCatalogQuery := `
+		DELETE FROM actormovie;
+		DELETE FROM crew;
+		DELETE FROM moviegenre;
+		DELETE FROM movietag;
+		DELETE FROM movie;
+		DELETE FROM actor;
+		DELETE FROM revision;
+		`
+
+		if _, err := tx.ExecContext(ctx, clearCatalogQuery); err != nil {
+			return counts, err
+		}
+	}
+
+	// Справочники жанров и тегов заменяются архивом и в пустом каталоге: список жанров
+	// после миграции заполнен, а в архиве жанры записаны со своими id
+	clearTaxonomyQuery := `
+	DELETE FROM genre;
+	DELETE FROM tag;
+	`
+
+	if _, err := tx.ExecContext(ctx, clearTaxonomyQuery); err != nil {
+		return counts, err
+	}
+
+	for {
+		var record model.ArchiveRecord
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return counts, fmt.Errorf("%w: archive is truncated, end record is missing", errInvalidArchive)
+			}
+			return counts, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+
+		if record.Type == archiveTypeEnd {
+			var expected model.ArchiveCounts
+			if err := json.Unmarshal(record.Data, &expected); err != nil {
+				return counts, fmt.Errorf("%w: end: %v", errInvalidArchive, err)
+			}
+			if expected != counts {
+				return counts, fmt.Errorf("%w: archive is incomplete, expected %+v, got %+v",
+					errInvalidArchive, expected, counts)
+			}
+			break
+		}
+
+		if err := restoreArchiveRecord(ctx, tx, record, &counts); err != nil {
+			return counts, err
+		}
+	}
+
+	if catalogExists {
+		if _, err := tx.ExecContext(ctx, relinkUserDataQuery); err != nil {
+			return counts, err
+		}
+	}
+
+	// Новые записи должны получать id после восстановленных
+	resetIdentityQuery := `
+	SELECT setval(pg_get_serial_sequence('actor', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM actor;
+	SELECT setval(pg_get_serial_sequence('movie', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM movie;
+	SELECT setval(pg_get_serial_sequence('genre', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM genre;
+	SELECT setval(pg_get_serial_sequence('tag', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM tag;
+	`
+
+	if _, err := tx.ExecContext(ctx, resetIdentityQuery); err != nil {
+		return counts, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return counts, err
+	}
+
+	invalidateSuggestions()
+
+	return counts, nil
+}
+
+// exportContentTypes - Content-Type ответа для каждого формата выгрузки
+var exportContentTypes = map[string]string{
+	importFormatCSV:    "text/csv",
+	importFormatNDJSON: "application/x-ndjson",
+}
+
+// Export выгружает набор данных потоком. Время выгрузки не ограничивается QUERY_TIME_LIMIT,
+// запрос к базе отменяется, когда клиент закрывает соединение.
+func Export(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "export catalog"); !ok {
+		return
+	}
+
+	name := r.PathValue("dataset")
+	if _, ok := exportDatasets[name]; !ok {
+		http.Error(w, "dataset must be movies, actors, actor-movies, crew, genres, tags, movie-genres or movie-tags", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormatNDJSON
+	}
+
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(w, "format must be csv or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+
+	count, err := WriteExport(r.Context(), w, name, format)
+	if err != nil {
+		// Заголовки уже отправлены, поэтому выгрузка просто обрывается
+		log.Println("Export error streaming ", name, ": ", err)
+		return
+	}
+
+	log.Printf("Export streamed %d %s\n", count, name)
+}
+
+func ExportArchive(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "export catalog"); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[importFormatNDJSON])
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="filmoteka-%s.ndjson"`, time.Now().UTC().Format("20060102-150405")))
+
+	counts, err := WriteArchive(r.Context(), w)
+	if err != nil {
+		// Без завершающей строки архив не будет принят при восстановлении
+		log.Println("ExportArchive error streaming archive: ", err)
+		return
+	}
+
+	log.Printf("ExportArchive streamed %+v\n", counts)
+}
+
+func ImportArchive(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "restore catalog"); !ok {
+		return
+	}
+
+	replace := false
+	if value := r.URL.Query().Get("replace"); value != "" {
+		var err error
+		if replace, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "replace must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	counts, err := RestoreArchive(r.Context(), r.Body, replace)
+	if err != nil {
+		if errors.Is(err, errInvalidArchive) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if errors.Is(err, errArchiveCatalogNotEmpty) {
+			http.Error(w, "Catalog is not empty, set replace=true to overwrite it", http.StatusConflict)
+			return
+		}
+
+		var errPQ *pq.Error
+		if errors.As(err, &errPQ) && (errPQ.Code == "23505" || errPQ.Code == "23503" || errPQ.Code == "23514") {
+			log.Println("ImportArchive archive violates constraints: ", errPQ)
+			http.Error(w, "Invalid archive: "+errPQ.Message, http.StatusBadRequest)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("ImportArchive restored %+v\n", counts)
+
+	resp, err := json.Marshal(counts)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+const facetTopActors = 10
+
+// queryer - общий интерфейс *sql.DB и *sql.Tx для запросов на чтение
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// facetsRequested проверяет query-параметр facets
+func facetsRequested(r *http.Request) bool {
+	facets := r.URL.Query().Get("facets")
+	return facets == "true" || facets == "1"
+}
+
+// beginSnapshot открывает транзакцию только для чтения с уровнем изоляции REPEATABLE READ,
+// чтобы результаты поиска и фасеты считались по одному снимку данных.
+func beginSnapshot(ctx context.Context) (*sql.Tx, error) {
+	return db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+// queryMovieFacets считает фасеты по множеству фильмов, которое возвращает matchedQuery.
+// matchedQuery должен возвращать одну колонку id фильма, args - его параметры.
+func queryMovieFacets(ctx context.Context, q queryer, matchedQuery string, args []interface{}) (model.MovieFacets, error) {
+	facets := model.MovieFacets{
+		Decades: []model.FacetCount{},
+		Ratings: []model.FacetCount{},
+		Actors:  []model.ActorFacet{},
+	}
+
+	decadesQuery := fmt.Sprintf(`
+	WITH matched AS (%s)
+	SELECT (EXTRACT(YEAR FROM m.date)::integer / 10) * 10 AS decade, COUNT(*)
+	FROM movie m
+	WHERE m.id IN (SELECT id FROM matched)
+	GROUP BY decade
+	ORDER BY decade;
+	`, matchedQuery)
+
+	rows, err := q.QueryContext(ctx, decadesQuery, args...)
+	if err != nil {
+		return facets, err
+	}
+	for rows.Next() {
+		var facet model.FacetCount
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			rows.Close()
+			return facets, err
+		}
+		facets.Decades = append(facets.Decades, facet)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return facets, err
+	}
+
+	ratingsQuery := fmt.Sprintf(`
+	WITH matched AS (%s)
+	SELECT m.rating, COUNT(*)
+	FROM movie m
+	WHERE m.id IN (SELECT id FROM matched)
+	GROUP BY m.rating
+	ORDER BY m.rating DESC;
+	`, matchedQuery)
+
+	rows, err = q.QueryContext(ctx, ratingsQuery, args...)
+	if err != nil {
+		return facets, err
+	}
+	for rows.Next() {
+		var facet model.FacetCount
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			rows.Close()
+			return facets, err
+		}
+		facets.Ratings = append(facets.Ratings, facet)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return facets, err
+	}
+
+	actorsQuery := fmt.Sprintf(`
+	WITH matched AS (%s)
+	SELECT a.id, a.firstName, a.lastName, COUNT(DISTINCT ma.movie_id) AS movies
+	FROM actor a
+	JOIN actormovie ma ON a.id = ma.actor_id
+	WHERE a.deleted_at IS NULL AND ma.movie_id IN (SELECT id FROM matched)
+	GROUP BY a.id
+	ORDER BY movies DESC, a.id
+	LIMIT %d;
+	`, matchedQuery, facetTopActors)
+
+	rows, err = q.QueryContext(ctx, actorsQuery, args...)
+	if err != nil {
+		return facets, err
+	}
+	for rows.Next() {
+		var facet model.ActorFacet
+		if err := rows.Scan(&facet.ID, &facet.FirstName, &facet.LastName, &facet.Count); err != nil {
+			rows.Close()
+			return facets, err
+		}
+		facets.Actors = append(facets.Actors, facet)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return facets, err
+	}
+
+	return facets, nil
+}
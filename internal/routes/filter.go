@@ -0,0 +1,345 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+const (
+	maxFilterDepth  = 8
+	maxFilterNodes  = 64
+	maxFilterValues = 100
+)
+
+type filterKind int
+
+const (
+	filterText filterKind = iota
+	filterDate
+	filterNumber
+	filterActor
+	filterCrew
+	filterCountry
+	filterExternalID
+)
+
+type filterField struct {
+	column string // для filterCrew - отдел съёмочной группы
+	kind   filterKind
+	ops    []string
+}
+
+// movieFilterFields - список полей, по которым разрешено фильтровать фильмы.
+// Имена колонок подставляются в SQL только отсюда, значения всегда передаются параметрами.
+var movieFilterFields = map[string]filterField{
+	"name":        {column: "m.name", kind: filterText, ops: []string{"eq", "ne", "contains"}},
+	"description": {column: "m.description", kind: filterText, ops: []string{"contains"}},
+	"date":        {column: "m.date", kind: filterDate, ops: []string{"eq", "ne", "lt", "lte", "gt", "gte", "between"}},
+	"rating":      {column: "m.rating", kind: filterNumber, ops: []string{"eq", "ne", "lt", "lte", "gt", "gte", "between"}},
+	"actorID":     {kind: filterActor, ops: []string{"eq", "in", "all"}},
+	"directorID":  {column: "directing", kind: filterCrew, ops: []string{"eq", "in", "all"}},
+	"writerID":    {column: "writing", kind: filterCrew, ops: []string{"eq", "in", "all"}},
+	"producerID":  {column: "production", kind: filterCrew, ops: []string{"eq", "in", "all"}},
+	"composerID":  {column: "music", kind: filterCrew, ops: []string{"eq", "in", "all"}},
+	"runtime":     {column: "m.runtime", kind: filterNumber, ops: []string{"eq", "ne", "lt", "lte", "gt", "gte", "between"}},
+	"country":     {kind: filterCountry, ops: []string{"eq", "in", "all"}},
+	// Фильмы без указанного языка, названия или возрастного рейтинга не подходят ни под eq, ни под ne
+	"originalLanguage": {column: "m.original_language", kind: filterText, ops: []string{"eq", "ne"}},
+	"originalTitle":    {column: "m.original_title", kind: filterText, ops: []string{"eq", "ne", "contains"}},
+	"ageCertification": {column: "m.age_certification", kind: filterText, ops: []string{"eq", "ne"}},
+	// externalID: eq - значение {"source": "imdb", "id": "tt0113277"}, exists - название каталога
+	"externalID": {kind: filterExternalID, ops: []string{"eq", "exists"}},
+}
+
+var comparisonOperators = map[string]string{
+	"eq":  "=",
+	"ne":  "<>",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+type filterCompiler struct {
+	args  []interface{}
+	nodes int
+}
+
+// compileMovieFilter переводит дерево фильтра в условие WHERE для таблицы movie с псевдонимом m.
+// Нумерация параметров начинается с $1, аргументы возвращаются в порядке нумерации.
+func compileMovieFilter(filter *model.MovieFilter) (string, []interface{}, error) {
+	if filter == nil {
+		return "TRUE", nil, nil
+	}
+
+	c := &filterCompiler{}
+
+	condition, err := c.compile(filter, 1)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return condition, c.args, nil
+}
+
+func (c *filterCompiler) param(value interface{}) string {
+	c.args = append(c.args, value)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+func (c *filterCompiler) compile(node *model.MovieFilter, depth int) (string, error) {
+	if depth > maxFilterDepth {
+		return "", fmt.Errorf("filter nesting is limited to %d levels", maxFilterDepth)
+	}
+
+	c.nodes++
+	if c.nodes > maxFilterNodes {
+		return "", fmt.Errorf("filter is limited to %d conditions", maxFilterNodes)
+	}
+
+	set := 0
+	if node.And != nil {
+		set++
+	}
+	if node.Or != nil {
+		set++
+	}
+	if node.Not != nil {
+		set++
+	}
+	if node.Field != "" {
+		set++
+	}
+	if set != 1 {
+		return "", errors.New("each filter node must have exactly one of and, or, not, field")
+	}
+
+	switch {
+	case node.And != nil:
+		return c.compileGroup(node.And, " AND ", depth)
+	case node.Or != nil:
+		return c.compileGroup(node.Or, " OR ", depth)
+	case node.Not != nil:
+		condition, err := c.compile(node.Not, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + condition + ")", nil
+	default:
+		return c.compileCondition(node)
+	}
+}
+
+func (c *filterCompiler) compileGroup(nodes []model.MovieFilter, operator string, depth int) (string, error) {
+	if len(nodes) == 0 {
+		return "", errors.New("and/or groups cannot be empty")
+	}
+
+	conditions := make([]string, 0, len(nodes))
+	for i := range nodes {
+		condition, err := c.compile(&nodes[i], depth+1)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return "(" + strings.Join(conditions, operator) + ")", nil
+}
+
+func (c *filterCompiler) compileCondition(node *model.MovieFilter) (string, error) {
+	field, ok := movieFilterFields[node.Field]
+	if !ok {
+		return "", fmt.Errorf("unknown filter field %q", node.Field)
+	}
+
+	allowed := false
+	for _, op := range field.ops {
+		if op == node.Op {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("operator %q is not supported for field %q", node.Op, node.Field)
+	}
+
+	if len(node.Value) == 0 {
+		return "", fmt.Errorf("value is required for field %q", node.Field)
+	}
+
+	switch field.kind {
+	case filterText:
+		var value string
+		if err := json.Unmarshal(node.Value, &value); err != nil {
+			return "", fmt.Errorf("value of %q must be a string", node.Field)
+		}
+		if node.Op == "contains" {
+			return fmt.Sprintf("%s ILIKE '%%' || %s || '%%'", field.column, c.param(escapeLike(value))), nil
+		}
+		return fmt.Sprintf("%s %s %s", field.column, comparisonOperators[node.Op], c.param(value)), nil
+
+	case filterDate, filterNumber:
+		if node.Op == "between" {
+			var bounds []json.RawMessage
+			if err := json.Unmarshal(node.Value, &bounds); err != nil || len(bounds) != 2 {
+				return "", fmt.Errorf("value of %q between must be an array of two elements", node.Field)
+			}
+			from, err := parseFilterScalar(field.kind, node.Field, bounds[0])
+			if err != nil {
+				return "", err
+			}
+			to, err := parseFilterScalar(field.kind, node.Field, bounds[1])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s BETWEEN %s AND %s", field.column, c.param(from), c.param(to)), nil
+		}
+
+		value, err := parseFilterScalar(field.kind, node.Field, node.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", field.column, comparisonOperators[node.Op], c.param(value)), nil
+
+	case filterActor, filterCrew:
+		var ids []int64
+		if node.Op == "eq" {
+			var id int64
+			if err := json.Unmarshal(node.Value, &id); err != nil {
+				return "", fmt.Errorf("value of %q must be an integer", node.Field)
+			}
+			ids = []int64{id}
+		} else if err := json.Unmarshal(node.Value, &ids); err != nil || len(ids) == 0 {
+			return "", fmt.Errorf("value of %q must be a non-empty array of integers", node.Field)
+		}
+		if len(ids) > maxFilterValues {
+			return "", fmt.Errorf("value of %q is limited to %d elements", node.Field, maxFilterValues)
+		}
+
+		ids = uniqueIDs(ids)
+		param := c.param(pq.Array(ids))
+
+		if field.kind == filterCrew {
+			if node.Op == "all" {
+				return fmt.Sprintf(`(SELECT COUNT(DISTINCT fc.person_id) FROM crew fc
+					JOIN actor fa ON fa.id = fc.person_id AND fa.deleted_at IS NULL
+					WHERE fc.movie_id = m.id AND fc.department = '%s' AND fc.person_id = ANY(%s::integer[])) = %d`,
+					field.column, param, len(ids)), nil
+			}
+			return fmt.Sprintf(`EXISTS (SELECT 1 FROM crew fc
+				JOIN actor fa ON fa.id = fc.person_id AND fa.deleted_at IS NULL
+				WHERE fc.movie_id = m.id AND fc.department = '%s' AND fc.person_id = ANY(%s::integer[]))`,
+				field.column, param), nil
+		}
+
+		if node.Op == "all" {
+			return fmt.Sprintf(`(SELECT COUNT(DISTINCT fam.actor_id) FROM actormovie fam
+				JOIN actor fa ON fa.id = fam.actor_id AND fa.deleted_at IS NULL
+				WHERE fam.movie_id = m.id AND fam.actor_id = ANY(%s::integer[])) = %d`, param, len(ids)), nil
+		}
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM actormovie fam
+			JOIN actor fa ON fa.id = fam.actor_id AND fa.deleted_at IS NULL
+			WHERE fam.movie_id = m.id AND fam.actor_id = ANY(%s::integer[]))`, param), nil
+
+	case filterCountry:
+		var countries []string
+		if node.Op == "eq" {
+			var country string
+			if err := json.Unmarshal(node.Value, &country); err != nil {
+				return "", fmt.Errorf("value of %q must be a string", node.Field)
+			}
+			countries = []string{country}
+		} else if err := json.Unmarshal(node.Value, &countries); err != nil || len(countries) == 0 {
+			return "", fmt.Errorf("value of %q must be a non-empty array of strings", node.Field)
+		}
+		if len(countries) > maxFilterValues {
+			return "", fmt.Errorf("value of %q is limited to %d elements", node.Field, maxFilterValues)
+		}
+		for _, country := range countries {
+			if !countryCodePattern.MatchString(country) {
+				return "", fmt.Errorf("value of %q must contain ISO 3166-1 alpha-2 codes in upper case", node.Field)
+			}
+		}
+
+		param := c.param(pq.Array(countries))
+		if node.Op == "all" {
+			return fmt.Sprintf("m.countries @> %s::varchar[]", param), nil
+		}
+		return fmt.Sprintf("m.countries && %s::varchar[]", param), nil
+
+	case filterExternalID:
+		if node.Op == "exists" {
+			var name string
+			if err := json.Unmarshal(node.Value, &name); err != nil {
+				return "", fmt.Errorf("value of %q exists must be a catalog name", node.Field)
+			}
+			source, ok := findExternalIDSource(name)
+			if !ok {
+				return "", fmt.Errorf("unknown external catalog %q", name)
+			}
+			return fmt.Sprintf("m.%s IS NOT NULL", source.column), nil
+		}
+
+		var value struct {
+			Source string `json:"source"`
+			ID     string `json:"id"`
+		}
+		if err := json.Unmarshal(node.Value, &value); err != nil {
+			return "", fmt.Errorf("value of %q must be an object with source and id", node.Field)
+		}
+		source, ok := findExternalIDSource(value.Source)
+		if !ok {
+			return "", fmt.Errorf("unknown external catalog %q", value.Source)
+		}
+		return fmt.Sprintf("m.%s = %s", source.column, c.param(value.ID)), nil
+	}
+
+	return "", fmt.Errorf("unknown filter field %q", node.Field)
+}
+
+func parseFilterScalar(kind filterKind, name string, raw json.RawMessage) (interface{}, error) {
+	if kind == filterDate {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("value of %q must be a date string", name)
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			date, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("value of %q must be in YYYY-MM-DD or RFC 3339 format", name)
+			}
+		}
+		return date, nil
+	}
+
+	var value int
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("value of %q must be an integer", name)
+	}
+	return value, nil
+}
+
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// escapeLike экранирует служебные символы шаблона LIKE
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,591 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+// taxonomy описывает справочник меток фильма и таблицу его связей с фильмами.
+// Имена таблиц подставляются в SQL только отсюда.
+type taxonomy struct {
+	table      string
+	linkTable  string
+	linkColumn string
+	noun       string
+}
+
+var (
+	genreTaxonomy = taxonomy{table: "genre", linkTable: "moviegenre", linkColumn: "genre_id", noun: "Genre"}
+	tagTaxonomy   = taxonomy{table: "tag", linkTable: "movietag", linkColumn: "tag_id", noun: "Tag"}
+)
+
+const maxTermNameLength = 50
+
+// termOperation меняет справочник в транзакции и возвращает тело успешного ответа
+type termOperation func(ctx context.Context, tx *sql.Tx) (interface{}, error)
+
+// validateTermName проверяет название жанра или тега, пробелы по краям уже убраны
+func validateTermName(t taxonomy, name string) error {
+	if name == "" {
+		return badRequest(t.noun + " name cannot be empty")
+	}
+
+	if len([]rune(name)) > maxTermNameLength {
+		return badRequest(fmt.Sprintf("Maximum %s name length is %d symbols", strings.ToLower(t.noun), maxTermNameLength))
+	}
+
+	return nil
+}
+
+// requireTerm проверяет, что жанр или тег существует, иначе возвращает ответ 404
+func requireTerm(ctx context.Context, tx *sql.Tx, t taxonomy, id int) error {
+	var exists bool
+
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+t.table+` WHERE id = $1);`, id).Scan(&exists)
+	if err == nil && !exists {
+		err = &catalogError{status: http.StatusNotFound, message: t.noun + " not found"}
+	}
+
+	return err
+}
+
+// bumpTermMovies меняет версии фильмов с этим жанром или тегом, чтобы их ETag отразил переименование или удаление
+func bumpTermMovies(ctx context.Context, tx *sql.Tx, t taxonomy, id int) error {
+	bumpTermMoviesQuery := `
+	UPDATE movie SET version = version + 1
+	WHERE id IN (SELECT movie_id FROM ` + t.linkTable + ` WHERE ` + t.linkColumn + ` = $1);
+	`
+
+	_, err := tx.ExecContext(ctx, bumpTermMoviesQuery, id)
+	return err
+}
+
+// attachTermTx добавляет фильму существующий жанр или тег termID
+func attachTermTx(ctx context.Context, tx *sql.Tx, issuer string, t taxonomy, movieID, termID int, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = requireTerm(ctx, tx, t, termID)
+	}
+
+	attachTermQuery := `
+	INSERT INTO ` + t.linkTable + ` (movie_id, ` + t.linkColumn + `)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING;
+	`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, attachTermQuery, movieID, termID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusConflict, message: "Movie already has this " + t.table}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "add_"+t.table, auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// detachTermTx убирает у фильма жанр или тег termID
+func detachTermTx(ctx context.Context, tx *sql.Tx, issuer string, t taxonomy, movieID, termID int, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	detachTermQuery := `DELETE FROM ` + t.linkTable + ` WHERE movie_id = $1 AND ` + t.linkColumn + ` = $2;`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, detachTermQuery, movieID, termID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusNotFound, message: "Movie doesn't have this " + t.table}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "delete_"+t.table, auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// findOrCreateTagTx возвращает тег с названием name без учёта регистра, создавая его при необходимости
+func findOrCreateTagTx(ctx context.Context, tx *sql.Tx, name string) (model.Tag, error) {
+	var tag model.Tag
+
+	createTagQuery := `
+	INSERT INTO tag (name) VALUES ($1)
+	ON CONFLICT DO NOTHING
+	RETURNING id, name;
+	`
+
+	err := tx.QueryRowContext(ctx, createTagQuery, name).Scan(&tag.ID, &tag.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, `SELECT id, name FROM tag WHERE lower(name) = lower($1);`, name).Scan(&tag.ID, &tag.Name)
+	}
+
+	return tag, err
+}
+
+// tagMovieTx добавляет фильму тег по названию. Тег создаётся, если его ещё нет.
+func tagMovieTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, name, ifMatch string) (model.Tag, error) {
+	tag, err := findOrCreateTagTx(ctx, tx, name)
+	if err != nil {
+		return tag, err
+	}
+
+	return tag, attachTermTx(ctx, tx, issuer, tagTaxonomy, movieID, tag.ID, ifMatch)
+}
+
+// taxonomyCondition возвращает условие для фильма m: жанр с названием из параметра genreParam
+// и тег с названием из параметра tagParam. Пустое название не ограничивает выборку.
+func taxonomyCondition(genreParam, tagParam int) string {
+	return fmt.Sprintf(`($%[1]d = '' OR EXISTS (SELECT 1 FROM moviegenre mg JOIN genre g ON g.id = mg.genre_id
+		WHERE mg.movie_id = m.id AND lower(g.name) = lower($%[1]d)))
+		AND ($%[2]d = '' OR EXISTS (SELECT 1 FROM movietag mt JOIN tag t ON t.id = mt.tag_id
+		WHERE mt.movie_id = m.id AND lower(t.name) = lower($%[2]d)))`, genreParam, tagParam)
+}
+
+// getMovieGenres возвращает жанры фильма по названию
+func getMovieGenres(ctx context.Context, q queryer, movieID int) ([]model.Genre, error) {
+	movieGenresQuery := `
+	SELECT g.id, g.name FROM moviegenre mg
+	JOIN genre g ON g.id = mg.genre_id
+	WHERE mg.movie_id = $1
+	ORDER BY lower(g.name), g.id;
+	`
+
+	genres := []model.Genre{}
+	err := queryTerms(ctx, q, movieGenresQuery, []interface{}{movieID}, func(id int, name string) {
+		genres = append(genres, model.Genre{ID: id, Name: name})
+	})
+
+	return genres, err
+}
+
+// getMovieTags возвращает теги фильма по названию
+func getMovieTags(ctx context.Context, q queryer, movieID int) ([]model.Tag, error) {
+	movieTagsQuery := `
+	SELECT t.id, t.name FROM movietag mt
+	JOIN tag t ON t.id = mt.tag_id
+	WHERE mt.movie_id = $1
+	ORDER BY lower(t.name), t.id;
+	`
+
+	tags := []model.Tag{}
+	err := queryTerms(ctx, q, movieTagsQuery, []interface{}{movieID}, func(id int, name string) {
+		tags = append(tags, model.Tag{ID: id, Name: name})
+	})
+
+	return tags, err
+}
+
+// queryTerms выполняет запрос, возвращающий id и название, и передаёт каждую строку в add
+func queryTerms(ctx context.Context, q queryer, query string, args []interface{}, add func(id int, name string)) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		add(id, name)
+	}
+
+	return rows.Err()
+}
+
+func GetGenres(w http.ResponseWriter, r *http.Request) {
+	listTerms(w, r, genreTaxonomy)
+}
+
+func GetTags(w http.ResponseWriter, r *http.Request) {
+	listTerms(w, r, tagTaxonomy)
+}
+
+// listTerms возвращает все жанры или теги по названию
+func listTerms(w http.ResponseWriter, r *http.Request, t taxonomy) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	terms := []model.Genre{}
+	err := queryTerms(ctx, db, `SELECT id, name FROM `+t.table+` ORDER BY lower(name), id;`, nil, func(id int, name string) {
+		terms = append(terms, model.Genre{ID: id, Name: name})
+	})
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Get%ss QueryContext deadline exceeded: %v\n", t.noun, err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Жанр и тег имеют одинаковый вид в JSON
+	resp, err := json.Marshal(terms)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+func AddGenre(w http.ResponseWriter, r *http.Request) {
+	addTerm(w, r, genreTaxonomy)
+}
+
+func AddTag(w http.ResponseWriter, r *http.Request) {
+	addTerm(w, r, tagTaxonomy)
+}
+
+// addTerm создаёт жанр или тег с названием из тела запроса
+func addTerm(w http.ResponseWriter, r *http.Request, t taxonomy) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "manage "+t.table+"s"); !ok {
+		return
+	}
+
+	name, ok := readTermName(w, r, t)
+	if !ok {
+		return
+	}
+
+	changeTerm(w, t, "Add"+t.noun, http.StatusCreated, func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+		term := model.Genre{Name: name}
+		err := tx.QueryRowContext(ctx, `INSERT INTO `+t.table+` (name) VALUES ($1) RETURNING id;`, name).Scan(&term.ID)
+		return term, err
+	})
+}
+
+func UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	updateTerm(w, r, genreTaxonomy)
+}
+
+func UpdateTag(w http.ResponseWriter, r *http.Request) {
+	updateTerm(w, r, tagTaxonomy)
+}
+
+// updateTerm переименовывает жанр или тег с id из пути
+func updateTerm(w http.ResponseWriter, r *http.Request, t taxonomy) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "manage "+t.table+"s"); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	name, ok := readTermName(w, r, t)
+	if !ok {
+		return
+	}
+
+	changeTerm(w, t, "Update"+t.noun, http.StatusOK, func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+		result, err := tx.ExecContext(ctx, `UPDATE `+t.table+` SET name = $2 WHERE id = $1;`, id, name)
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected == 0 {
+			err = &catalogError{status: http.StatusNotFound, message: t.noun + " not found"}
+		}
+
+		if err == nil {
+			err = bumpTermMovies(ctx, tx, t, id)
+		}
+
+		return model.Genre{ID: id, Name: name}, err
+	})
+}
+
+func DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	deleteTerm(w, r, genreTaxonomy)
+}
+
+func DeleteTag(w http.ResponseWriter, r *http.Request) {
+	deleteTerm(w, r, tagTaxonomy)
+}
+
+// deleteTerm удаляет жанр или тег с id из пути вместе с его связями с фильмами
+func deleteTerm(w http.ResponseWriter, r *http.Request, t taxonomy) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "manage "+t.table+"s"); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeTerm(w, t, "Delete"+t.noun, http.StatusOK, func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+		err := bumpTermMovies(ctx, tx, t, id)
+
+		var result sql.Result
+		if err == nil {
+			result, err = tx.ExecContext(ctx, `DELETE FROM `+t.table+` WHERE id = $1;`, id)
+		}
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected == 0 {
+			err = &catalogError{status: http.StatusNotFound, message: t.noun + " not found"}
+		}
+
+		return t.noun + " deleted successfully", err
+	})
+}
+
+// readTermName читает название жанра или тега из тела запроса. При ошибке ответ уже записан в w.
+func readTermName(w http.ResponseWriter, r *http.Request, t taxonomy) (string, bool) {
+	var term model.Genre
+
+	err := json.NewDecoder(r.Body).Decode(&term)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return "", false
+	}
+
+	name := strings.TrimSpace(term.Name)
+
+	if err := validateTermName(t, name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+
+	return name, true
+}
+
+// changeTerm выполняет operation над справочником в транзакции.
+// Права администратора проверяются в обработчике до чтения тела запроса.
+func changeTerm(w http.ResponseWriter, t taxonomy, name string, status int, operation termOperation) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := operation(ctx, tx)
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("%s Failed to rollback transaction: %v\n", name, rollbackErr)
+		} else {
+			log.Println(name, "transaction rollback")
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println(name, errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		var errPQ *pq.Error
+		if errors.As(err, &errPQ) && errPQ.Code == "23505" {
+			log.Println(name, "record already exists: ", errPQ)
+			http.Error(w, t.noun+" already exists", http.StatusConflict)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(name, "error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// AttachGenre добавляет фильму жанр по id из пути
+func AttachGenre(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "change movie genres")
+	if !ok {
+		return
+	}
+
+	genreID, err := strconv.Atoi(r.PathValue("genreID"))
+	if err != nil || genreID < 1 {
+		http.Error(w, "genreID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeCast(w, r, issuer, "AttachGenre", http.StatusCreated,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Genre added to movie successfully",
+				attachTermTx(ctx, tx, issuer, genreTaxonomy, movieID, genreID, r.Header.Get("If-Match"))
+		})
+}
+
+// DetachGenre убирает у фильма жанр по id из пути
+func DetachGenre(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "change movie genres")
+	if !ok {
+		return
+	}
+
+	genreID, err := strconv.Atoi(r.PathValue("genreID"))
+	if err != nil || genreID < 1 {
+		http.Error(w, "genreID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeCast(w, r, issuer, "DetachGenre", http.StatusOK,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Genre removed from movie successfully",
+				detachTermTx(ctx, tx, issuer, genreTaxonomy, movieID, genreID, r.Header.Get("If-Match"))
+		})
+}
+
+// AttachTag добавляет фильму тег по названию из тела запроса и возвращает тег.
+// Тег создаётся, если его ещё нет.
+func AttachTag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "change movie tags")
+	if !ok {
+		return
+	}
+
+	name, ok := readTermName(w, r, tagTaxonomy)
+	if !ok {
+		return
+	}
+
+	changeCast(w, r, issuer, "AttachTag", http.StatusCreated,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return tagMovieTx(ctx, tx, issuer, movieID, name, r.Header.Get("If-Match"))
+		})
+}
+
+// DetachTag убирает у фильма тег по id из пути
+func DetachTag(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "change movie tags")
+	if !ok {
+		return
+	}
+
+	tagID, err := strconv.Atoi(r.PathValue("tagID"))
+	if err != nil || tagID < 1 {
+		http.Error(w, "tagID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeCast(w, r, issuer, "DetachTag", http.StatusOK,
+		func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+			return "Tag removed from movie successfully",
+				detachTermTx(ctx, tx, issuer, tagTaxonomy, movieID, tagID, r.Header.Get("If-Match"))
+		})
+}
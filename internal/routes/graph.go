@@ -0,0 +1,348 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+const (
+	graphDefaultMaxDepth  = 6
+	graphDefaultTimeLimit = 10
+)
+
+var (
+	graphMaxDepth  = graphDefaultMaxDepth  // Наибольшая глубина поиска цепочки (GRAPH_MAX_DEPTH)
+	graphTimeLimit = graphDefaultTimeLimit // Ограничение времени поиска цепочки в секундах (GRAPH_TIME_LIMIT)
+)
+
+// errNoActorPath - актёры не связаны цепочкой допустимой длины
+var errNoActorPath = errors.New("no path between actors")
+
+// actorLink - ребро графа актёров: соседний актёр и общий с ним фильм
+type actorLink struct {
+	actorID int
+	movieID int
+}
+
+// parseMaxDepth читает глубину поиска из параметра maxDepth, по умолчанию - наибольшая допустимая
+func parseMaxDepth(value string) (int, error) {
+	if value == "" {
+		return graphMaxDepth, nil
+	}
+
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 1 || depth > graphMaxDepth {
+		return 0, fmt.Errorf("maxDepth must be between 1 and %d", graphMaxDepth)
+	}
+
+	return depth, nil
+}
+
+// coStarsQuery выбирает актёров, снимавшихся с актёром $1 в неудалённых фильмах, по убыванию количества общих фильмов
+const coStarsQuery = `
+	SELECT a.id, a.firstName, a.lastName, a.sex, a.birthDate, count(*) AS shared
+	FROM actormovie ma1
+	JOIN movie m ON m.id = ma1.movie_id AND m.deleted_at IS NULL
+	JOIN actormovie ma2 ON ma2.movie_id = ma1.movie_id AND ma2.actor_id <> ma1.actor_id
+	JOIN actor a ON a.id = ma2.actor_id AND a.deleted_at IS NULL
+	WHERE ma1.actor_id = $1
+	GROUP BY a.id
+	ORDER BY shared DESC, a.lastName, a.firstName, a.id
+	LIMIT $2 OFFSET $3;
+	`
+
+// neighboursQuery выбирает соседей актёров $1 в графе: для каждой пары актёров - общий фильм с наименьшим id
+const neighboursQuery = `
+	SELECT ma1.actor_id, ma2.actor_id, min(ma2.movie_id)
+	FROM actormovie ma1
+	JOIN movie m ON m.id = ma1.movie_id AND m.deleted_at IS NULL
+	JOIN actormovie ma2 ON ma2.movie_id = ma1.movie_id AND ma2.actor_id <> ma1.actor_id
+	JOIN actor a ON a.id = ma2.actor_id AND a.deleted_at IS NULL
+	WHERE ma1.actor_id = ANY($1)
+	GROUP BY ma1.actor_id, ma2.actor_id
+	ORDER BY ma1.actor_id, ma2.actor_id;
+	`
+
+// countActiveActors возвращает количество неудалённых актёров среди ids
+func countActiveActors(ctx context.Context, q queryer, ids ...int) (int, error) {
+	var count int
+	err := q.QueryRowContext(ctx, `SELECT count(*) FROM actor WHERE id = ANY($1) AND deleted_at IS NULL;`,
+		pq.Array(ids)).Scan(&count)
+
+	return count, err
+}
+
+// findActorPath ищет кратчайшую цепочку от актёра from до актёра to не длиннее maxDepth фильмов.
+// Поиск в ширину идёт одновременно с обоих концов, каждый раз расширяется меньший из фронтов.
+// Возвращает id актёров цепочки и id фильмов между соседними актёрами.
+func findActorPath(ctx context.Context, q queryer, from, to, maxDepth int) ([]int, []int, error) {
+	if from == to {
+		return []int{from}, []int{}, nil
+	}
+
+	// parents[0] ведут к from, parents[1] - к to
+	parents := [2]map[int]actorLink{{from: {}}, {to: {}}}
+	frontiers := [2][]int{{from}, {to}}
+
+	for depth := 1; depth <= maxDepth; depth++ {
+		side := 0
+		if len(frontiers[1]) < len(frontiers[0]) {
+			side = 1
+		}
+
+		rows, err := q.QueryContext(ctx, neighboursQuery, pq.Array(frontiers[side]))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		next := []int{}
+		meet := 0
+		for rows.Next() {
+			var actorID, neighbourID, movieID int
+			if err := rows.Scan(&actorID, &neighbourID, &movieID); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+
+			if _, seen := parents[side][neighbourID]; seen {
+				continue
+			}
+			parents[side][neighbourID] = actorLink{actorID: actorID, movieID: movieID}
+			next = append(next, neighbourID)
+
+			if _, reached := parents[1-side][neighbourID]; reached && (meet == 0 || neighbourID < meet) {
+				meet = neighbourID
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		if meet != 0 {
+			actorIDs, movieIDs := joinActorPath(parents, from, to, meet)
+			return actorIDs, movieIDs, nil
+		}
+		if len(next) == 0 {
+			break
+		}
+		frontiers[side] = next
+	}
+
+	return nil, nil, errNoActorPath
+}
+
+// joinActorPath собирает цепочку из половин, встретившихся на актёре meet
+func joinActorPath(parents [2]map[int]actorLink, from, to, meet int) ([]int, []int) {
+	actorIDs := []int{meet}
+	movieIDs := []int{}
+
+	for id := meet; id != from; id = parents[0][id].actorID {
+		actorIDs = append([]int{parents[0][id].actorID}, actorIDs...)
+		movieIDs = append([]int{parents[0][id].movieID}, movieIDs...)
+	}
+	for id := meet; id != to; id = parents[1][id].actorID {
+		actorIDs = append(actorIDs, parents[1][id].actorID)
+		movieIDs = append(movieIDs, parents[1][id].movieID)
+	}
+
+	return actorIDs, movieIDs
+}
+
+// loadActorPath заполняет шаги цепочки сведениями об актёрах и фильмах
+func loadActorPath(ctx context.Context, q queryer, actorIDs, movieIDs []int) (model.ActorPath, error) {
+	path := model.ActorPath{Degrees: len(movieIDs), Steps: make([]model.ActorPathStep, len(actorIDs))}
+
+	actors := make(map[int]model.Actor, len(actorIDs))
+	rows, err := q.QueryContext(ctx, `SELECT id, firstName, lastName, sex, birthDate FROM actor WHERE id = ANY($1);`,
+		pq.Array(actorIDs))
+	if err != nil {
+		return path, err
+	}
+	for rows.Next() {
+		var actor model.Actor
+		if err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName, &actor.Sex, &actor.BirthDate); err != nil {
+			rows.Close()
+			return path, err
+		}
+		actors[actor.ID] = actor
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return path, err
+	}
+
+	movies := make(map[int]model.Movie, len(movieIDs))
+	rows, err = q.QueryContext(ctx, `SELECT id, name, description, date, rating FROM movie WHERE id = ANY($1);`,
+		pq.Array(movieIDs))
+	if err != nil {
+		return path, err
+	}
+	for rows.Next() {
+		var movie model.Movie
+		if err := rows.Scan(&movie.ID, &movie.Name, &movie.Description, &movie.Date, &movie.Rating); err != nil {
+			rows.Close()
+			return path, err
+		}
+		movies[movie.ID] = movie
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return path, err
+	}
+
+	for i, id := range actorIDs {
+		path.Steps[i].Actor = actors[id]
+		if i > 0 {
+			movie := movies[movieIDs[i-1]]
+			path.Steps[i].Movie = &movie
+		}
+	}
+
+	return path, nil
+}
+
+// GetCoStars возвращает актёров, снимавшихся с актёром с id из пути, с количеством общих фильмов.
+// Поддерживает параметры limit и offset.
+func GetCoStars(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	coStars := []model.CoStar{}
+
+	count, err := countActiveActors(ctx, db, id)
+	if err == nil && count == 0 {
+		err = sql.ErrNoRows
+	}
+
+	var rows *sql.Rows
+	if err == nil {
+		rows, err = db.QueryContext(ctx, coStarsQuery, id, limit, offset)
+	}
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var coStar model.CoStar
+			if err = rows.Scan(&coStar.ID, &coStar.FirstName, &coStar.LastName, &coStar.Sex, &coStar.BirthDate,
+				&coStar.SharedMovies); err != nil {
+				break
+			}
+			coStars = append(coStars, coStar)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+
+	writeGraphResponse(ctx, w, "GetCoStars", coStars, err)
+}
+
+// GetActorPath возвращает кратчайшую цепочку общих фильмов от актёра id до актёра targetID.
+// Длина цепочки ограничена параметром maxDepth, время поиска - GRAPH_TIME_LIMIT.
+func GetActorPath(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	from, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || from < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	to, err := strconv.Atoi(r.PathValue("targetID"))
+	if err != nil || to < 1 {
+		http.Error(w, "targetID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	maxDepth, err := parseMaxDepth(r.URL.Query().Get("maxDepth"))
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(graphTimeLimit)*time.Second)
+	defer cancel()
+
+	var path model.ActorPath
+
+	count, err := countActiveActors(ctx, db, from, to)
+	if err == nil && count != len(sortedUniqueIDs([]int{from, to})) {
+		err = sql.ErrNoRows
+	}
+
+	var actorIDs, movieIDs []int
+	if err == nil {
+		actorIDs, movieIDs, err = findActorPath(ctx, db, from, to, maxDepth)
+	}
+	if errors.Is(err, errNoActorPath) {
+		http.Error(w, fmt.Sprintf("Actors are not connected within %d movies", maxDepth), http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		path, err = loadActorPath(ctx, db, actorIDs, movieIDs)
+	}
+
+	writeGraphResponse(ctx, w, "GetActorPath", path, err)
+}
+
+func writeGraphResponse(ctx context.Context, w http.ResponseWriter, name string, body interface{}, err error) {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Actor not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,754 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+const (
+	importFormatCSV    = "csv"
+	importFormatNDJSON = "ndjson"
+)
+
+const (
+	importDefaultBatchSize = 100
+	importMaxBatchSize     = 1000
+)
+
+// errInvalidImportFile - файл импорта отклонён до обработки строк: неверный формат, заголовок CSV или размер пачки
+var errInvalidImportFile = errors.New("invalid import file")
+
+const (
+	importStatusCreated = "created"
+	importStatusUpdated = "updated"
+	importStatusSkipped = "skipped"
+	importStatusFailed  = "failed"
+)
+
+// importCSVColumns - допустимые столбцы CSV, столбцы name и date обязательны. Актёры задаются в столбце
+// actors списком "firstName;lastName;sex;birthDate", элементы которого разделены символом |,
+// страны в столбце countries тоже разделены символом |. Названия столбцов совпадают с выгрузкой фильмов.
+var importCSVColumns = map[string]bool{
+	"name":             true,
+	"description":      true,
+	"date":             true,
+	"rating":           true,
+	"runtime":          true,
+	"countries":        true,
+	"originalLanguage": true,
+	"originalTitle":    true,
+	"ageCertification": true,
+	"imdbID":           true,
+	"tmdbID":           true,
+	"kinopoiskID":      true,
+	"actors":           true,
+}
+
+type ImportOptions struct {
+	Format    string // csv или ndjson
+	DryRun    bool   // только проверить строки, ничего не записывая
+	BatchSize int    // количество строк в одной транзакции
+}
+
+// importRow - прочитанная строка файла импорта. Fields - поля, заданные в строке (для CSV - столбцы заголовка),
+// Err содержит ошибку разбора строки.
+type importRow struct {
+	Row    int
+	Movie  model.Movie
+	Fields map[string]bool
+	Err    error
+}
+
+// importUpdateColumns - поля фильма, которые обновляются у существующего фильма, если они заданы в строке импорта.
+// Пустое значение сведений о фильме записывается как NULL, то есть стирает их.
+var importUpdateColumns = []struct {
+	field  string
+	column string
+	value  func(movie model.Movie) interface{}
+}{
+	{"description", "description", func(movie model.Movie) interface{} { return movie.Description }},
+	{"rating", "rating", func(movie model.Movie) interface{} { return movie.Rating }},
+	{"runtime", "runtime", func(movie model.Movie) interface{} {
+		return sql.NullInt64{Int64: int64(movie.Runtime), Valid: movie.Runtime > 0}
+	}},
+	{"countries", "countries", func(movie model.Movie) interface{} {
+		return pq.Array(append([]string{}, movie.Countries...))
+	}},
+	{"originalLanguage", "original_language", func(movie model.Movie) interface{} {
+		return importNullString(movie.OriginalLanguage)
+	}},
+	{"originalTitle", "original_title", func(movie model.Movie) interface{} {
+		return importNullString(movie.OriginalTitle)
+	}},
+	{"ageCertification", "age_certification", func(movie model.Movie) interface{} {
+		return importNullString(movie.AgeCertification)
+	}},
+	{"imdbID", "imdb_id", func(movie model.Movie) interface{} {
+		return importNullString(externalIDValues(movie.ExternalIDs)[0])
+	}},
+	{"tmdbID", "tmdb_id", func(movie model.Movie) interface{} {
+		return importNullString(externalIDValues(movie.ExternalIDs)[1])
+	}},
+	{"kinopoiskID", "kinopoisk_id", func(movie model.Movie) interface{} {
+		return importNullString(externalIDValues(movie.ExternalIDs)[2])
+	}},
+}
+
+func importNullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+type movieReader interface {
+	// next возвращает следующую строку или io.EOF, если строк больше нет
+	next() (importRow, error)
+}
+
+type ndjsonMovieReader struct {
+	reader *bufio.Reader
+	row    int
+}
+
+func (r *ndjsonMovieReader) next() (importRow, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return importRow{}, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return importRow{}, io.EOF
+			}
+			continue
+		}
+
+		r.row++
+		row := importRow{Row: r.row}
+
+		var fields map[string]json.RawMessage
+		decodeErr := json.Unmarshal(line, &fields)
+		if decodeErr == nil {
+			decodeErr = json.Unmarshal(line, &row.Movie)
+		}
+		if decodeErr != nil {
+			row.Err = fmt.Errorf("invalid JSON: %v", decodeErr)
+		}
+
+		row.Fields = make(map[string]bool, len(fields))
+		for field := range fields {
+			row.Fields[field] = true
+		}
+
+		// Внешние идентификаторы вложены в externalIDs, каждый из них считается отдельным полем,
+		// как столбцы imdbID, tmdbID и kinopoiskID в CSV
+		if externalIDs, ok := fields["externalIDs"]; ok {
+			var ids map[string]json.RawMessage
+			json.Unmarshal(externalIDs, &ids)
+			for _, source := range externalIDSources {
+				if _, ok := ids[source.name]; ok {
+					row.Fields[source.name+"ID"] = true
+				}
+			}
+		}
+
+		return row, nil
+	}
+}
+
+type csvMovieReader struct {
+	reader  *csv.Reader
+	columns []string
+	fields  map[string]bool
+	row     int
+}
+
+func newCSVMovieReader(r io.Reader) (*csvMovieReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: CSV header is missing", errInvalidImportFile)
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, fmt.Errorf("%w: %v", errInvalidImportFile, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool, len(header))
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if !importCSVColumns[column] {
+			return nil, fmt.Errorf("%w: unknown CSV column %q", errInvalidImportFile, column)
+		}
+		fields[column] = true
+		header[i] = column
+	}
+
+	if !fields["name"] || !fields["date"] {
+		return nil, fmt.Errorf("%w: CSV header must contain the name and date columns", errInvalidImportFile)
+	}
+
+	return &csvMovieReader{reader: reader, columns: header, fields: fields}, nil
+}
+
+func (r *csvMovieReader) next() (importRow, error) {
+	record, err := r.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return importRow{}, io.EOF
+	}
+
+	r.row++
+	row := importRow{Row: r.row, Fields: r.fields}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.Err = parseErr.Err
+		return row, nil
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+
+	row.Movie, row.Err = parseCSVMovie(r.columns, record)
+
+	return row, nil
+}
+
+// parseCSVMovie собирает фильм из значений строки CSV в порядке столбцов заголовка
+func parseCSVMovie(columns, record []string) (model.Movie, error) {
+	var movie model.Movie
+	var err error
+
+	for i, column := range columns {
+		value := strings.TrimSpace(record[i])
+
+		switch column {
+		case "name":
+			movie.Name = value
+		case "description":
+			movie.Description = value
+		case "date":
+			if value != "" {
+				if movie.Date, err = time.Parse("2006-01-02", value); err != nil {
+					return movie, errors.New("date must be in YYYY-MM-DD format")
+				}
+			}
+		case "rating":
+			if value != "" {
+				rating, err := strconv.ParseInt(value, 10, 16)
+				if err != nil {
+					return movie, errors.New("rating must be an integer")
+				}
+				movie.Rating = int16(rating)
+			}
+		case "runtime":
+			if value != "" {
+				if movie.Runtime, err = strconv.Atoi(value); err != nil {
+					return movie, errors.New("runtime must be an integer")
+				}
+			}
+		case "countries":
+			for _, country := range strings.Split(value, "|") {
+				if country = strings.TrimSpace(country); country != "" {
+					movie.Countries = append(movie.Countries, country)
+				}
+			}
+		case "originalLanguage":
+			movie.OriginalLanguage = value
+		case "originalTitle":
+			movie.OriginalTitle = value
+		case "ageCertification":
+			movie.AgeCertification = value
+		case "imdbID", "tmdbID", "kinopoiskID":
+			if movie.ExternalIDs == nil {
+				movie.ExternalIDs = &model.ExternalIDs{}
+			}
+			switch column {
+			case "imdbID":
+				movie.ExternalIDs.IMDb = value
+			case "tmdbID":
+				movie.ExternalIDs.TMDb = value
+			default:
+				movie.ExternalIDs.Kinopoisk = value
+			}
+		case "actors":
+			if movie.Actors, err = parseCSVActors(value); err != nil {
+				return movie, err
+			}
+		}
+	}
+
+	return movie, nil
+}
+
+func parseCSVActors(value string) ([]model.Actor, error) {
+	var actors []model.Actor
+
+	if value == "" {
+		return actors, nil
+	}
+
+	for _, item := range strings.Split(value, "|") {
+		fields := strings.Split(item, ";")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("actor %q must be in firstName;lastName;sex;birthDate format", item)
+		}
+
+		actor := model.Actor{
+			FirstName: strings.TrimSpace(fields[0]),
+			LastName:  strings.TrimSpace(fields[1]),
+			Sex:       strings.TrimSpace(fields[2]),
+		}
+
+		if birthDate := strings.TrimSpace(fields[3]); birthDate != "" {
+			var err error
+			if actor.BirthDate, err = time.Parse("2006-01-02", birthDate); err != nil {
+				return nil, errors.New("actor birth date must be in YYYY-MM-DD format")
+			}
+		}
+
+		actors = append(actors, actor)
+	}
+
+	return actors, nil
+}
+
+func newMovieReader(r io.Reader, format string) (movieReader, error) {
+	switch format {
+	case importFormatCSV:
+		return newCSVMovieReader(r)
+	case importFormatNDJSON:
+		return &ndjsonMovieReader{reader: bufio.NewReader(r)}, nil
+	default:
+		return nil, fmt.Errorf("%w: format must be csv or ndjson", errInvalidImportFile)
+	}
+}
+
+// validateImportMovie применяет к строке импорта те же ограничения, что AddMovie и AddActor.
+// Дата обязательна: по названию и дате ищется уже существующий фильм.
+func validateImportMovie(movie model.Movie) error {
+	if err := validateNewMovie(movie); err != nil {
+		return err
+	}
+
+	if movie.Date.IsZero() {
+		return badRequest("Movie date is required")
+	}
+
+	for _, actor := range movie.Actors {
+		if err := validateActor(actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importMovie добавляет фильм или обновляет уже существующий фильм с тем же названием и датой.
+// У существующего фильма меняются только поля из fields. Актёры добавляются запросом
+// addMissingActorsQuery, как в AddMovie.
+func importMovie(ctx context.Context, tx *sql.Tx, issuer string, movie model.Movie, fields map[string]bool) (int, string, error) {
+	var actorsID []int
+
+	for _, actor := range movie.Actors {
+		var actorID int
+		var inserted bool
+
+		err := tx.QueryRowContext(ctx, addMissingActorsQuery, actor.FirstName,
+			actor.LastName, actor.Sex, actor.BirthDate).Scan(&actorID, &inserted)
+
+		if err == nil && inserted {
+			err = auditChange(ctx, tx, issuer, "add_actor", auditEntityActor, actorID, nil)
+		}
+
+		if err != nil {
+			return 0, "", err
+		}
+
+		actorsID = append(actorsID, actorID)
+	}
+
+	var movieID int
+
+	findMovieQuery := `SELECT id FROM movie WHERE name = $1 AND date = $2 AND deleted_at IS NULL;`
+
+	err := tx.QueryRowContext(ctx, findMovieQuery, movie.Name, movie.Date).Scan(&movieID)
+
+	status := importStatusUpdated
+	var before json.RawMessage
+	fieldsChanged := false
+
+	if errors.Is(err, sql.ErrNoRows) {
+		status = importStatusCreated
+
+		addMovieQuery := `
+		INSERT INTO movie (name, description, date, rating, runtime, countries, original_language, original_title,
+			age_certification, imdb_id, tmdb_id, kinopoisk_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5::integer, 0), COALESCE($6::varchar[], '{}'), NULLIF($7, ''), NULLIF($8, ''),
+			NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''))
+		RETURNING id;
+		`
+
+		externalIDs := externalIDValues(movie.ExternalIDs)
+
+		err = requireUniqueExternalIDs(ctx, tx, 0, movie.ExternalIDs)
+		if err == nil {
+			err = tx.QueryRowContext(ctx, addMovieQuery, movie.Name, movie.Description, movie.Date, movie.Rating,
+				movie.Runtime, pq.Array(movie.Countries), movie.OriginalLanguage, movie.OriginalTitle,
+				movie.AgeCertification, externalIDs[0], externalIDs[1], externalIDs[2]).Scan(&movieID)
+		}
+	} else if err == nil {
+		before, err = snapshot(ctx, tx, auditEntityMovie, movieID)
+
+		if err == nil {
+			err = requireUniqueExternalIDs(ctx, tx, movieID, movie.ExternalIDs)
+		}
+
+		updateMovieQuery, args := buildImportUpdateQuery(movieID, movie, fields)
+
+		var result sql.Result
+		if err == nil && updateMovieQuery != "" {
+			result, err = tx.ExecContext(ctx, updateMovieQuery, args...)
+		}
+
+		var rowsAffected int64
+		if err == nil && result != nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+		fieldsChanged = rowsAffected > 0
+	}
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	addActorMovieRelQuery := `
+	INSERT INTO ActorMovie (actor_id, movie_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING;
+	`
+
+	var linked int64
+
+	for _, actorID := range actorsID {
+		result, err := tx.ExecContext(ctx, addActorMovieRelQuery, actorID, movieID)
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected > 0 {
+			err = bumpVersion(ctx, tx, auditEntityActor, actorID)
+		}
+
+		if err != nil {
+			return 0, "", err
+		}
+
+		linked += rowsAffected
+	}
+
+	if status == importStatusCreated {
+		err = auditChange(ctx, tx, issuer, "add_movie", auditEntityMovie, movieID, nil)
+		return movieID, status, err
+	}
+
+	if !fieldsChanged && linked == 0 {
+		return movieID, importStatusSkipped, nil
+	}
+
+	if !fieldsChanged {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "update_movie", auditEntityMovie, movieID, before)
+	}
+
+	if err == nil && fieldsChanged {
+		err = recordRevision(ctx, tx, issuer, auditEntityMovie, movieID, before)
+	}
+
+	return movieID, status, err
+}
+
+// buildImportUpdateQuery собирает изменение существующего фильма по полям из fields.
+// Если ни одно изменяемое поле не задано, возвращает пустой запрос.
+func buildImportUpdateQuery(movieID int, movie model.Movie, fields map[string]bool) (string, []interface{}) {
+	var columns, params []string
+	args := []interface{}{movieID}
+
+	for _, column := range importUpdateColumns {
+		if !fields[column.field] {
+			continue
+		}
+		args = append(args, column.value(movie))
+		columns = append(columns, column.column)
+		params = append(params, fmt.Sprintf("$%d", len(args)))
+	}
+
+	if len(columns) == 0 {
+		return "", nil
+	}
+
+	sets := make([]string, len(columns))
+	for i := range columns {
+		sets[i] = columns[i] + " = " + params[i]
+	}
+
+	query := fmt.Sprintf(`
+	UPDATE movie SET %s
+	WHERE id = $1 AND ROW(%s) IS DISTINCT FROM ROW(%s);
+	`, strings.Join(sets, ", "), strings.Join(columns, ", "), strings.Join(params, ", "))
+
+	return query, args
+}
+
+// importRowError переводит ошибку базы данных в сообщение для отчёта
+func importRowError(err error) string {
+	var errPQ *pq.Error
+	if errors.As(err, &errPQ) && errPQ.Code == "23505" {
+		return "Movie already exits"
+	}
+
+	return err.Error()
+}
+
+// importBatch выполняет строки одной транзакцией. Каждая строка выполняется в своей точке
+// сохранения, поэтому ошибка в строке не отменяет остальные строки пачки. В режиме dryRun
+// транзакция откатывается. Если откатывается вся пачка, все её строки помечаются как failed.
+func importBatch(ctx context.Context, issuer string, rows []importRow, dryRun bool) []model.ImportRowResult {
+	results := make([]model.ImportRowResult, len(rows))
+
+	failBatch := func(err error) []model.ImportRowResult {
+		for i := range rows {
+			if results[i].Status != importStatusFailed {
+				results[i] = model.ImportRowResult{Row: rows[i].Row, Name: rows[i].Movie.Name,
+					Status: importStatusFailed, Error: "batch rolled back: " + err.Error()}
+			}
+		}
+		return results
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return failBatch(err)
+	}
+
+	for i, row := range rows {
+		results[i] = model.ImportRowResult{Row: row.Row, Name: row.Movie.Name}
+
+		if row.Err == nil {
+			row.Err = validateImportMovie(row.Movie)
+		}
+
+		if row.Err != nil {
+			results[i].Status, results[i].Error = importStatusFailed, row.Err.Error()
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, "SAVEPOINT import_row;")
+
+		if err == nil {
+			results[i].MovieID, results[i].Status, err = importMovie(ctx, tx, issuer, row.Movie, row.Fields)
+		}
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row;")
+			if err == nil {
+				continue
+			}
+		}
+
+		if ctx.Err() == nil {
+			_, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row;")
+			if rollbackErr == nil {
+				results[i].MovieID, results[i].Status, results[i].Error = 0, importStatusFailed, importRowError(err)
+				continue
+			}
+		}
+
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("ImportMovies Failed to rollback transaction: %v\n", rollbackErr)
+		}
+
+		return failBatch(err)
+	}
+
+	if dryRun {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("ImportMovies Failed to rollback transaction: %v\n", err)
+		}
+		return results
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("ImportMovies error committing transaction: ", err)
+		return failBatch(err)
+	}
+
+	return results
+}
+
+// ImportCatalog загружает фильмы с актёрами из CSV или NDJSON пачками по opts.BatchSize строк.
+// Ошибки отдельных строк попадают в отчёт. Ошибка errInvalidImportFile означает, что файл отклонён
+// до обработки строк. Если файл не удалось дочитать, возвращается отчёт об уже обработанных пачках
+// с заполненным Error и сама ошибка. Каждая пачка ограничена QUERY_TIME_LIMIT.
+func ImportCatalog(ctx context.Context, r io.Reader, opts ImportOptions, issuer string) (model.ImportReport, error) {
+	report := model.ImportReport{DryRun: opts.DryRun, Rows: []model.ImportRowResult{}}
+
+	if opts.BatchSize < 1 || opts.BatchSize > importMaxBatchSize {
+		return report, fmt.Errorf("%w: batch size must be between 1 and %d", errInvalidImportFile, importMaxBatchSize)
+	}
+
+	reader, err := newMovieReader(r, opts.Format)
+	if err != nil {
+		return report, err
+	}
+
+	var readErr error
+
+	for done := false; !done; {
+		var rows []importRow
+
+		for len(rows) < opts.BatchSize {
+			row, err := reader.next()
+			if errors.Is(err, io.EOF) {
+				done = true
+				break
+			}
+			if err != nil {
+				readErr = err
+				break
+			}
+			rows = append(rows, row)
+		}
+
+		// Строки недочитанной пачки не выполняются: файл мог оборваться посреди строки
+		if readErr != nil {
+			report.Error = "import stopped after " + strconv.Itoa(len(report.Rows)) + " rows: " + readErr.Error()
+			break
+		}
+
+		if len(rows) == 0 {
+			break
+		}
+
+		batchCtx, cancel := context.WithTimeout(ctx, time.Duration(queryTimeLimit)*time.Second)
+		results := importBatch(batchCtx, issuer, rows, opts.DryRun)
+		cancel()
+
+		for _, result := range results {
+			switch result.Status {
+			case importStatusCreated:
+				report.Created++
+			case importStatusUpdated:
+				report.Updated++
+			case importStatusSkipped:
+				report.Skipped++
+			case importStatusFailed:
+				report.Failed++
+			}
+		}
+		report.Rows = append(report.Rows, results...)
+	}
+
+	if !opts.DryRun && report.Created+report.Updated > 0 {
+		invalidateSuggestions()
+	}
+
+	return report, readErr
+}
+
+// importFormat определяет формат по параметру format или по Content-Type запроса
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	switch strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]) {
+	case "text/csv":
+		return importFormatCSV
+	case "application/x-ndjson":
+		return importFormatNDJSON
+	}
+
+	return ""
+}
+
+func ImportMovies(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "import movies")
+	if !ok {
+		return
+	}
+
+	opts := ImportOptions{Format: importFormat(r), BatchSize: importDefaultBatchSize}
+	var err error
+
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		if opts.DryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "dryRun must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value := r.URL.Query().Get("batchSize"); value != "" {
+		if opts.BatchSize, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "batchSize must be an integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := ImportCatalog(r.Context(), r.Body, opts, issuer)
+	if errors.Is(err, errInvalidImportFile) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("ImportMovies error reading import file: ", err)
+		// Пачки до ошибки уже записаны, поэтому клиент получает отчёт о них
+		if len(report.Rows) == 0 {
+			http.Error(w, "Error reading import file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("ImportMovies created %d, updated %d, skipped %d, failed %d (dry run: %t)\n",
+		report.Created, report.Updated, report.Skipped, report.Failed, report.DryRun)
+
+	resp, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,671 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+// Списки фильмов принадлежат пользователю. Публичные списки видны всем вошедшим пользователям, приватные -
+// только владельцу, для остальных приватный список не существует. Фильмы, удалённые из каталога,
+// не показываются в списке, но сохраняют свою позицию до окончательной очистки.
+
+const (
+	maxListNameLength        = 150
+	maxListDescriptionLength = 1000
+)
+
+// listOperation меняет список с id из пути в транзакции от имени issuer и возвращает тело успешного ответа
+type listOperation func(ctx context.Context, tx *sql.Tx, issuer string, listID int) (interface{}, error)
+
+// validateMovieList проверяет название и описание списка, пробелы по краям уже убраны
+func validateMovieList(input model.MovieListInput) error {
+	if input.Name == "" {
+		return badRequest("List name cannot be empty")
+	}
+
+	if len([]rune(input.Name)) > maxListNameLength {
+		return badRequest(fmt.Sprintf("Maximum list name length is %d symbols", maxListNameLength))
+	}
+
+	if len([]rune(input.Description)) > maxListDescriptionLength {
+		return badRequest(fmt.Sprintf("Maximum list description length is %d symbols", maxListDescriptionLength))
+	}
+
+	return nil
+}
+
+// validateListOrder проверяет, что order перечисляет каждый фильм списка current ровно один раз
+func validateListOrder(order []int, current []int64) error {
+	if len(sortedUniqueIDs(order)) != len(order) || len(order) != len(current) {
+		return badRequest("movieIDs must list every movie in the list exactly once")
+	}
+
+	inList := make(map[int]bool, len(current))
+	for _, id := range current {
+		inList[int(id)] = true
+	}
+
+	for _, id := range order {
+		if !inList[id] {
+			return badRequest("movieIDs must list every movie in the list exactly once")
+		}
+	}
+
+	return nil
+}
+
+// movieListsQuery собирает выборку списков без фильмов, tail содержит WHERE и ORDER BY.
+// В movieCount учитываются только неудалённые фильмы.
+func movieListsQuery(tail string) string {
+	return `
+	SELECT l.id, l.person_id, p.username, l.name, l.description, l.is_public,
+	(SELECT count(*) FROM movielistitem i JOIN movie m ON m.id = i.movie_id AND m.deleted_at IS NULL
+		WHERE i.list_id = l.id),
+	l.created_at, l.updated_at
+	FROM movielist l
+	JOIN person p ON p.id = l.person_id
+	` + tail + `;
+	`
+}
+
+func queryMovieLists(ctx context.Context, q queryer, query string, args ...interface{}) ([]model.MovieList, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []model.MovieList{}
+	for rows.Next() {
+		var list model.MovieList
+		if err := rows.Scan(&list.ID, &list.PersonID, &list.Username, &list.Name, &list.Description, &list.Public,
+			&list.MovieCount, &list.CreatedAt, &list.UpdatedAt); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, rows.Err()
+}
+
+// getMovieListDetails возвращает список с фильмами в порядке позиций, если он виден пользователю issuer,
+// иначе ответ 404
+func getMovieListDetails(ctx context.Context, q queryer, issuer string, listID int) (model.MovieListDetails, error) {
+	var details model.MovieListDetails
+
+	lists, err := queryMovieLists(ctx, q, movieListsQuery(`WHERE l.id = $1 AND (l.is_public OR l.person_id = $2)`),
+		listID, issuer)
+	if err != nil {
+		return details, err
+	}
+
+	if len(lists) == 0 {
+		return details, &catalogError{status: http.StatusNotFound, message: "List not found"}
+	}
+
+	details.MovieList = lists[0]
+
+	listMoviesQuery := `
+	SELECT m.id, m.name, m.description, m.date, m.rating, ` + communityColumns + `,
+	i.position, i.added_at
+	FROM movielistitem i
+	JOIN movie m ON m.id = i.movie_id AND m.deleted_at IS NULL
+	` + ratingStatsJoin + `
+	WHERE i.list_id = $1
+	ORDER BY i.position;
+	`
+
+	rows, err := q.QueryContext(ctx, listMoviesQuery, listID)
+	if err != nil {
+		return details, err
+	}
+	defer rows.Close()
+
+	details.Movies = []model.MovieListItem{}
+	for rows.Next() {
+		var item model.MovieListItem
+		var community model.RatingSummary
+		if err := rows.Scan(&item.ID, &item.Name, &item.Description, &item.Date, &item.Rating,
+			&community.Average, &community.Count, &item.Position, &item.AddedAt); err != nil {
+			return details, err
+		}
+		item.CommunityRating = &community
+		details.Movies = append(details.Movies, item)
+	}
+
+	return details, rows.Err()
+}
+
+// requireListOwner блокирует список и проверяет, что issuer его владелец. Чужой приватный список
+// считается несуществующим. Если allowAdmin, администратор тоже проходит проверку.
+func requireListOwner(ctx context.Context, tx *sql.Tx, issuer string, listID int, allowAdmin bool) error {
+	var ownerID int
+	var public bool
+
+	err := tx.QueryRowContext(ctx, `SELECT person_id, is_public FROM movielist WHERE id = $1 FOR UPDATE;`,
+		listID).Scan(&ownerID, &public)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &catalogError{status: http.StatusNotFound, message: "List not found"}
+	}
+	if err != nil || strconv.Itoa(ownerID) == issuer {
+		return err
+	}
+
+	if allowAdmin {
+		admin, err := isAdmin(issuer)
+		if err != nil || admin {
+			return err
+		}
+	}
+
+	if !public {
+		return &catalogError{status: http.StatusNotFound, message: "List not found"}
+	}
+
+	return &catalogError{status: http.StatusForbidden, message: "You can only change your own lists"}
+}
+
+// touchList отмечает изменение состава или порядка фильмов списка
+func touchList(ctx context.Context, tx *sql.Tx, listID int) error {
+	_, err := tx.ExecContext(ctx, `UPDATE movielist SET updated_at = now() WHERE id = $1;`, listID)
+	return err
+}
+
+// readMovieList читает название, описание и видимость списка из тела запроса. При ошибке ответ уже записан в w.
+func readMovieList(w http.ResponseWriter, r *http.Request) (model.MovieListInput, bool) {
+	var input model.MovieListInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return input, false
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	input.Description = strings.TrimSpace(input.Description)
+
+	if err := validateMovieList(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return input, false
+	}
+
+	return input, true
+}
+
+// AddMovieList создаёт пустой список текущего пользователя
+func AddMovieList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	input, ok := readMovieList(w, r)
+	if !ok {
+		return
+	}
+
+	changeMovieList(w, r, issuer, "AddMovieList", http.StatusCreated, func(ctx context.Context, tx *sql.Tx, issuer string, _ int) (interface{}, error) {
+		addListQuery := `
+		INSERT INTO MovieList (person_id, name, description, is_public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+		`
+
+		var listID int
+		err := tx.QueryRowContext(ctx, addListQuery, issuer, input.Name, input.Description, input.Public).Scan(&listID)
+
+		var details model.MovieListDetails
+		if err == nil {
+			details, err = getMovieListDetails(ctx, tx, issuer, listID)
+		}
+
+		return details, err
+	})
+}
+
+// UpdateMovieList меняет название, описание и видимость списка владельцем
+func UpdateMovieList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	input, ok := readMovieList(w, r)
+	if !ok {
+		return
+	}
+
+	changeMovieList(w, r, issuer, "UpdateMovieList", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, listID int) (interface{}, error) {
+		err := requireListOwner(ctx, tx, issuer, listID, false)
+
+		updateListQuery := `
+		UPDATE movielist SET name = $2, description = $3, is_public = $4, updated_at = now()
+		WHERE id = $1;
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, updateListQuery, listID, input.Name, input.Description, input.Public)
+		}
+
+		var details model.MovieListDetails
+		if err == nil {
+			details, err = getMovieListDetails(ctx, tx, issuer, listID)
+		}
+
+		return details, err
+	})
+}
+
+// DeleteMovieList удаляет список. Удалить список может владелец или администратор.
+func DeleteMovieList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	changeMovieList(w, r, issuer, "DeleteMovieList", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, listID int) (interface{}, error) {
+		err := requireListOwner(ctx, tx, issuer, listID, true)
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM movielist WHERE id = $1;`, listID)
+		}
+
+		return "List deleted successfully", err
+	})
+}
+
+// AddMovieToList вставляет фильм в список на указанную позицию, сдвигая следующие фильмы, или в конец списка
+func AddMovieToList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var add model.MovieListAdd
+
+	err := json.NewDecoder(r.Body).Decode(&add)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if add.MovieID < 1 {
+		http.Error(w, "movieID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	if add.Position < 0 {
+		http.Error(w, "position must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeMovieList(w, r, issuer, "AddMovieToList", http.StatusCreated, func(ctx context.Context, tx *sql.Tx, issuer string, listID int) (interface{}, error) {
+		err := requireListOwner(ctx, tx, issuer, listID, false)
+
+		if err == nil {
+			err = requireActive(ctx, tx, auditEntityMovie, add.MovieID)
+		}
+
+		var inList bool
+		var lastPosition int
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM movielistitem WHERE list_id = $1 AND movie_id = $2),
+			COALESCE((SELECT max(position) FROM movielistitem WHERE list_id = $1), 0);
+			`, listID, add.MovieID).Scan(&inList, &lastPosition)
+		}
+
+		if err == nil && inList {
+			err = &catalogError{status: http.StatusConflict, message: "Movie is already in the list"}
+		}
+
+		position := add.Position
+		if position == 0 {
+			position = lastPosition + 1
+		}
+
+		if err == nil && position > lastPosition+1 {
+			err = badRequest(fmt.Sprintf("position must be between 1 and %d", lastPosition+1))
+		}
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `UPDATE movielistitem SET position = position + 1 WHERE list_id = $1 AND position >= $2;`,
+				listID, position)
+		}
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `INSERT INTO movielistitem (list_id, movie_id, position) VALUES ($1, $2, $3);`,
+				listID, add.MovieID, position)
+		}
+
+		if err == nil {
+			err = touchList(ctx, tx, listID)
+		}
+
+		var details model.MovieListDetails
+		if err == nil {
+			details, err = getMovieListDetails(ctx, tx, issuer, listID)
+		}
+
+		return details, err
+	})
+}
+
+// RemoveMovieFromList убирает фильм из списка, следующие фильмы сдвигаются на его место
+func RemoveMovieFromList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := strconv.Atoi(r.PathValue("movieID"))
+	if err != nil || movieID < 1 {
+		http.Error(w, "movieID must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	changeMovieList(w, r, issuer, "RemoveMovieFromList", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, listID int) (interface{}, error) {
+		err := requireListOwner(ctx, tx, issuer, listID, false)
+
+		var position int
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `DELETE FROM movielistitem WHERE list_id = $1 AND movie_id = $2 RETURNING position;`,
+				listID, movieID).Scan(&position)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = &catalogError{status: http.StatusNotFound, message: "Movie is not in the list"}
+			}
+		}
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `UPDATE movielistitem SET position = position - 1 WHERE list_id = $1 AND position > $2;`,
+				listID, position)
+		}
+
+		if err == nil {
+			err = touchList(ctx, tx, listID)
+		}
+
+		var details model.MovieListDetails
+		if err == nil {
+			details, err = getMovieListDetails(ctx, tx, issuer, listID)
+		}
+
+		return details, err
+	})
+}
+
+// ReorderMovieList расставляет фильмы списка в порядке movieIDs. Фильмы, удалённые из каталога,
+// переносятся в конец списка.
+func ReorderMovieList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var order model.MovieListOrder
+
+	err := json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	changeMovieList(w, r, issuer, "ReorderMovieList", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, listID int) (interface{}, error) {
+		err := requireListOwner(ctx, tx, issuer, listID, false)
+
+		var current []int64
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(array_agg(i.movie_id), '{}')
+			FROM movielistitem i
+			JOIN movie m ON m.id = i.movie_id AND m.deleted_at IS NULL
+			WHERE i.list_id = $1;
+			`, listID).Scan((*pq.Int64Array)(&current))
+		}
+
+		if err == nil {
+			err = validateListOrder(order.MovieIDs, current)
+		}
+
+		reorderQuery := `
+		UPDATE movielistitem i SET position = o.position
+		FROM unnest($2::int[]) WITH ORDINALITY AS o(movie_id, position)
+		WHERE i.list_id = $1 AND i.movie_id = o.movie_id;
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, reorderQuery, listID, pq.Array(order.MovieIDs))
+		}
+
+		moveDeletedQuery := `
+		UPDATE movielistitem i SET position = $3 + rest.n
+		FROM (
+			SELECT movie_id, row_number() OVER (ORDER BY position) AS n
+			FROM movielistitem
+			WHERE list_id = $1 AND movie_id <> ALL ($2::int[])
+		) rest
+		WHERE i.list_id = $1 AND i.movie_id = rest.movie_id;
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, moveDeletedQuery, listID, pq.Array(order.MovieIDs), len(order.MovieIDs))
+		}
+
+		if err == nil {
+			err = touchList(ctx, tx, listID)
+		}
+
+		var details model.MovieListDetails
+		if err == nil {
+			details, err = getMovieListDetails(ctx, tx, issuer, listID)
+		}
+
+		return details, err
+	})
+}
+
+// changeMovieList проверяет id списка из пути, если он есть, и выполняет operation в транзакции от имени issuer.
+// Пользователь проверяется в обработчике до чтения тела запроса.
+func changeMovieList(w http.ResponseWriter, r *http.Request, issuer, name string, status int, operation listOperation) {
+	var listID int
+	if value := r.PathValue("id"); value != "" {
+		var err error
+		listID, err = strconv.Atoi(value)
+		if err != nil || listID < 1 {
+			http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := operation(ctx, tx, issuer, listID)
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("%s Failed to rollback transaction: %v\n", name, rollbackErr)
+		} else {
+			log.Println(name, "transaction rollback")
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println(name, errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		var errPQ *pq.Error
+		if errors.As(err, &errPQ) && errPQ.Code == "23505" {
+			log.Println(name, "record already exists: ", errPQ)
+			http.Error(w, "You already have a list with this name", http.StatusConflict)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(name, "error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// GetMovieList возвращает список с фильмами. Чужой приватный список не найден.
+func GetMovieList(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || listID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	details, err := getMovieListDetails(ctx, db, issuer, listID)
+	if err != nil {
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetMovieList QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(details)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// GetUserProfile возвращает профиль пользователя с id из пути и его списки: владельцу все,
+// остальным только публичные. Недавно изменённые списки идут первыми.
+func GetUserProfile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	profile := model.UserProfile{ID: id}
+
+	err = db.QueryRowContext(ctx, `SELECT username FROM person WHERE id = $1;`, id).Scan(&profile.Username)
+	if err == nil {
+		profile.Lists, err = queryMovieLists(ctx, db, movieListsQuery(`
+		WHERE l.person_id = $1 AND (l.is_public OR l.person_id = $2)
+		ORDER BY l.updated_at DESC, l.id DESC`), id, issuer)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetUserProfile QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(profile)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -73,6 +74,62 @@ func checkUserExists(issuer string) (bool, error) {
 	return false, errors.New("user not found. Unauthorized access not allowed")
 }
 
+// authorizeUser проверяет JWT и существование пользователя и возвращает его id.
+// При ошибке ответ уже записан в w.
+func authorizeUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(jwtName)
+
+	if err != nil {
+		http.Error(w, "Unauthenticated", http.StatusUnauthorized)
+		return "", false
+	}
+
+	token, err := jwtCheck(cookie)
+
+	if err != nil {
+		http.Error(w, "Unauthenticated", http.StatusUnauthorized)
+		return "", false
+	}
+
+	claims := token.Claims.(*jwt.RegisteredClaims)
+
+	userExists, err := checkUserExists(claims.Issuer)
+	if err != nil {
+		http.Error(w, "Error while checking user authorization", http.StatusInternalServerError)
+		return "", false
+	}
+
+	if !userExists {
+		log.Println("User with id ", claims.Issuer, "does not exist: ", err)
+		http.Error(w, "You are not logged in", http.StatusUnauthorized)
+		return "", false
+	}
+
+	return claims.Issuer, true
+}
+
+// authorizeAdmin проверяет JWT и права администратора и возвращает id пользователя.
+// При ошибке ответ уже записан в w.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, privilege string) (string, bool) {
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return "", false
+	}
+
+	isAdmin, err := isAdmin(issuer)
+	if err != nil {
+		http.Error(w, "Error while checking administrator privileges", http.StatusInternalServerError)
+		return "", false
+	}
+
+	if !isAdmin {
+		http.Error(w, "You do not have administrator privileges to "+privilege, http.StatusUnauthorized)
+		return "", false
+	}
+
+	return issuer, true
+}
+
 // InitConnPool создаёт пул соединений с БД
 func InitConnPool() error {
 	var err error
@@ -92,6 +149,19 @@ func InitConnPool() error {
 	if jwtName == "" {
 		return errors.New("environment variable JWT_NAME is empty")
 	}
+	suggestCacheEnabled = os.Getenv("SUGGEST_CACHE") == "true"
+	graphMaxDepth, err = positiveIntEnv("GRAPH_MAX_DEPTH", graphDefaultMaxDepth)
+	if err != nil {
+		return err
+	}
+	graphTimeLimit, err = positiveIntEnv("GRAPH_TIME_LIMIT", graphDefaultTimeLimit)
+	if err != nil {
+		return err
+	}
+	streamTimeLimit, err = positiveIntEnv("STREAM_TIME_LIMIT", streamDefaultTimeLimit)
+	if err != nil {
+		return err
+	}
 
 	db, err = postgres.Dial()
 	if err != nil {
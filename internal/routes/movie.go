@@ -2,16 +2,30 @@ package routes
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/BukhryakovVladimir/vkTest/internal/model"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lib/pq"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// addMissingActorsQuery добавляет актёра, если его ещё нет, и возвращает его id.
+// inserted показывает, был ли актёр создан этим запросом.
+const addMissingActorsQuery = `
+	INSERT INTO actor (firstName, lastName, sex, birthDate)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (firstName, lastName, birthDate) WHERE deleted_at IS NULL DO UPDATE
+	SET firstName = EXCLUDED.firstName
+	RETURNING id, (xmax = 0) AS inserted;
+	`
+
 func AddMovie(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -62,18 +76,8 @@ func AddMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len([]rune(movie.Name)) < 1 || len([]rune(movie.Name)) > 150 {
-		http.Error(w, "Movie name must be between 1 and 150 characters long", http.StatusBadRequest)
-		return
-	}
-
-	if len([]rune(movie.Description)) > 1000 {
-		http.Error(w, "Movie description maximum length is 1000 characters", http.StatusBadRequest)
-		return
-	}
-
-	if movie.Rating < 0 || movie.Rating > 10 {
-		http.Error(w, "Movie rating must be between 0 and 10", http.StatusBadRequest)
+	if err := validateNewMovie(movie); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -87,52 +91,7 @@ func AddMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var actorsID []int
-	var actorID int
-
-	addMissingActorsQuery := `
-	INSERT INTO actor (firstName, lastName, sex, birthDate)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (firstName, lastName, birthDate) DO UPDATE
-	SET firstName = EXCLUDED.firstName
-	RETURNING id;
-	`
-
-	for _, actor := range movie.Actors {
-		err = tx.QueryRowContext(ctx, addMissingActorsQuery, actor.FirstName,
-			actor.LastName, actor.Sex, actor.BirthDate).Scan(&actorID)
-
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				log.Printf("AddMovie Failed to rollback transaction: %v\n", rollbackErr)
-			} else {
-				log.Println("AddMovie transaction rollback")
-			}
-
-			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-				log.Println("AddMovie ExecContext deadline exceeded while adding movie: ", err)
-				http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
-				return
-			}
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		if actorID != 0 {
-			actorsID = append(actorsID, actorID)
-		}
-	}
-
-	addMovieQuery := `
-	INSERT INTO movie (name, description, date, rating)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id;
-	`
-
-	var movieID int
-
-	err = tx.QueryRowContext(ctx, addMovieQuery, movie.Name, movie.Description, movie.Date, movie.Rating).Scan(&movieID)
+	_, err = addMovieTx(ctx, tx, claims.Issuer, movie)
 
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -141,8 +100,15 @@ func AddMovie(w http.ResponseWriter, r *http.Request) {
 			log.Println("AddMovie transaction rollback")
 		}
 
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("AddMovie ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			log.Println("AddMovie ExecContext deadline exceeded while adding movie: ", err)
+			log.Println("AddMovie ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
 			return
 		}
@@ -159,32 +125,6 @@ func AddMovie(w http.ResponseWriter, r *http.Request) {
 		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
-
-	}
-
-	addActorMovieRelQuery := `
-	INSERT INTO ActorMovie (actor_id, movie_id)
-	VALUES ($1, $2);
-	`
-
-	for _, actorID = range actorsID {
-		_, err := tx.ExecContext(ctx, addActorMovieRelQuery, actorID, movieID)
-		if err != nil {
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				log.Printf("AddMovie Failed to rollback transaction: %v\n", rollbackErr)
-			} else {
-				log.Println("AddMovie transaction rollback")
-			}
-
-			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-				log.Println("AddMovie ExecContext deadline exceeded while adding movie: ", err)
-				http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
-				return
-			}
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
 	}
 
 	err = tx.Commit()
@@ -200,6 +140,8 @@ func AddMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	invalidateSuggestions()
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode("Added a movie successfully")
 
@@ -247,7 +189,7 @@ func UpdateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var movie model.Movie
+	var movie model.MovieUpdate
 
 	err = json.NewDecoder(r.Body).Decode(&movie)
 	if err != nil {
@@ -256,38 +198,37 @@ func UpdateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len([]rune(movie.Name)) > 150 {
-		http.Error(w, "Movie name maximum length is 150 characters", http.StatusBadRequest)
+	if err := validateMovieUpdate(movie); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len([]rune(movie.Description)) > 1000 {
-		http.Error(w, "Movie description maximum length is 1000 characters", http.StatusBadRequest)
-		return
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
 
-	if movie.Rating > 10 {
-		http.Error(w, "Movie rating maximum value is 10", http.StatusBadRequest)
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
 		return
 	}
 
-	updateMovieQuery := `
-	UPDATE movie
-	SET 
-	name = COALESCE(NULLIF($1, ''), name),
-	description = COALESCE(NULLIF($2, ''), description),
-	rating = $3, 
-	date = CASE WHEN $4::date = '0001-01-01' THEN date ELSE $4::date END 
-	WHERE id = $5;
-`
+	err = updateMovieTx(ctx, tx, claims.Issuer, movie, r.Header.Get("If-Match"))
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
-	defer cancel()
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("UpdateMovie Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("UpdateMovie transaction rollback")
+		}
 
-	_, err = db.ExecContext(ctx, updateMovieQuery, movie.Name, movie.Description,
-		movie.Rating, movie.Date, movie.ID)
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("UpdateMovie ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
 
-	if err != nil {
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Println("UpdateMovie ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
@@ -306,9 +247,17 @@ func UpdateMovie(w http.ResponseWriter, r *http.Request) {
 		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
+	}
 
+	err = tx.Commit()
+	if err != nil {
+		log.Println("UpdateMovie error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
+	invalidateSuggestions()
+
 	resp, err := json.Marshal("Movie updated successfully")
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -392,9 +341,7 @@ func DeleteMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteActorMovieQuery := `DELETE FROM actormovie WHERE movie_id = $1;`
-
-	result, err := tx.ExecContext(ctx, deleteActorMovieQuery, movie.ID)
+	err = deleteMovieTx(ctx, tx, claims.Issuer, movie.ID, r.Header.Get("If-Match"))
 
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -403,78 +350,34 @@ func DeleteMovie(w http.ResponseWriter, r *http.Request) {
 			log.Println("DeleteMovie transaction rollback")
 		}
 
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			log.Println("DeleteMovie ExecContext deadline exceeded: ", err)
-			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
-			return
-		} else {
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("DeleteMovie ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
 			return
 		}
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteMovie Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteMovie transaction rollback")
-		}
-
-		log.Println("DeleteMovie error checking rows affected : ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if rowsAffected == 0 {
-		log.Println("DeleteMovie ActorMovie table. ActorMovie relations don't exist, no rows affected")
-	} else {
-		log.Println("DeleteMovie Deleted movie with id = ", movie.ID, " from ActorMovie table")
-	}
-
-	deleteMovieQuery := `DELETE FROM movie WHERE id = $1;`
-
-	result, err = tx.ExecContext(ctx, deleteMovieQuery, movie.ID)
-
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteMovie Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteMovie transaction rollback")
-		}
 
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Println("DeleteMovie ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
 			return
-		} else {
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	rowsAffected, err = result.RowsAffected()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteMovie Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteMovie transaction rollback")
 		}
 
-		log.Println("DeleteMovie error checking rows affected : ", err)
+		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	if rowsAffected == 0 {
-		log.Println("DeleteMovie movie table. Movie doesn't exist, no rows affected")
-		http.Error(w, "Movie doesn't exist. Nothing deleted", http.StatusBadRequest)
+	err = tx.Commit()
+	if err != nil {
+		log.Println("DeleteMovie error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("DeleteMovie Deleted movie with id = ", movie.ID, " from Movie table")
+	log.Println("DeleteMovie Soft deleted movie with id = ", movie.ID)
+
+	invalidateSuggestions()
 
 	resp, err := json.Marshal("Movie deleted successfully")
 	if err != nil {
@@ -482,19 +385,6 @@ func DeleteMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("DeleteMovie Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("DeleteMovie transaction rollback")
-		}
-
-		log.Println("DeleteMovie error committing transaction")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	_, err = w.Write(resp)
@@ -560,23 +450,13 @@ func AddActorToMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len([]rune(actorMovie.FirstName)) > 255 {
-		http.Error(w, "Maximum firstName string length is 255 symbols", http.StatusBadRequest)
-		return
-	}
-
-	if len([]rune(actorMovie.LastName)) > 255 {
-		http.Error(w, "Maximum lastName string length is 255 symbols", http.StatusBadRequest)
+	if err := validateActor(actorMovie.FirstName, actorMovie.LastName, actorMovie.Sex, actorMovie.BirthDate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len([]rune(actorMovie.Sex)) > 10 {
-		http.Error(w, "Maximum sex string length is 10 symbols", http.StatusBadRequest)
-		return
-	}
-
-	if actorMovie.BirthDate.After(time.Now()) {
-		http.Error(w, "Birth date cannot be in the future", http.StatusBadRequest)
+	if err := validateCredit(actorMovie.Character, actorMovie.BillingOrder, actorMovie.RoleType); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -590,18 +470,7 @@ func AddActorToMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var actorID int
-
-	addMissingActorQuery := `
-	INSERT INTO actor (firstName, lastName, sex, birthDate)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (firstName, lastName, birthDate) DO UPDATE
-	SET firstName = EXCLUDED.firstName
-	RETURNING id;
-	`
-
-	err = tx.QueryRowContext(ctx, addMissingActorQuery, actorMovie.FirstName,
-		actorMovie.LastName, actorMovie.Sex, actorMovie.BirthDate).Scan(&actorID)
+	_, err = addActorToMovieTx(ctx, tx, claims.Issuer, actorMovie)
 
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -610,34 +479,19 @@ func AddActorToMovie(w http.ResponseWriter, r *http.Request) {
 			log.Println("AddActorToMovie transaction rollback")
 		}
 
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			log.Println("AddActorToMovie ExecContext deadline exceeded while adding movie: ", err)
-			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("AddActorToMovie ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
 			return
 		}
-		log.Println("Database error: ", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	addActorMovieRelQuery := `
-	INSERT INTO ActorMovie (actor_id, movie_id)
-	VALUES ($1, $2);
-	`
-
-	_, err = tx.ExecContext(ctx, addActorMovieRelQuery, actorID, actorMovie.MovieID)
-	if err != nil {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			log.Printf("AddActorToMovieRel Failed to rollback transaction: %v\n", rollbackErr)
-		} else {
-			log.Println("AddActorToMovieRel transaction rollback")
-		}
 
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			log.Println("AddActorToMovieRel ExecContext deadline exceeded while adding movie: ", err)
+			log.Println("AddActorToMovie ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
 			return
 		}
+
 		log.Println("Database error: ", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -656,6 +510,8 @@ func AddActorToMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	invalidateSuggestions()
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode("Added an actor to movie successfully")
 }
@@ -720,27 +576,41 @@ func DeleteActorFromMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteActorFromMovieQuery := `
-	DELETE FROM ActorMovie
-	WHERE actor_id = $1
-	AND movie_id = $2;
-	`
-
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
 	defer cancel()
 
-	_, err = db.ExecContext(ctx, deleteActorFromMovieQuery, actorMovie.ActorID, actorMovie.MovieID)
+	tx, err := db.BeginTx(ctx, nil)
 
 	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	err = deleteActorFromMovieTx(ctx, tx, claims.Issuer, actorMovie)
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("DeleteActorFromMovie Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("DeleteActorFromMovie transaction rollback")
+		}
+
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Println("DeleteActorFromMovie ExecContext deadline exceeded: ", err)
 			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
 			return
-		} else {
-			log.Println("Database error: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
 		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("DeleteActorFromMovie error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	resp, err := json.Marshal("Actor deleted from movie successfully")
@@ -825,15 +695,16 @@ func GetMoviesWithID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	getMoviesQuery := `
-	SELECT DISTINCT m.* FROM movie m 
+	SELECT DISTINCT m.id, m.name, m.description, m.date, m.rating FROM movie m 
 	JOIN ActorMovie am on m.id = am.movie_id
-	JOIN actor a ON am.actor_id = a.id
-	WHERE ($1 <> '' AND m.name LIKE '%' || $1 || '%')
+	JOIN actor a ON am.actor_id = a.id AND a.deleted_at IS NULL
+	WHERE m.deleted_at IS NULL
+	AND (($1 <> '' AND m.name LIKE '%' || $1 || '%')
     OR ($2 <> '' AND m.description LIKE '%' || $2 || '%')
 	OR ($3 <> '' AND m.date = $3::date)
 	OR (m.rating = $4)
 	OR (($5 <> '' AND a.firstName LIKE '%' || $5 || '%')
-    OR ($6 <> '' AND a.lastName LIKE '%' || $6 || '%'));
+    OR ($6 <> '' AND a.lastName LIKE '%' || $6 || '%')));
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
@@ -887,6 +758,20 @@ func GetMoviesWithID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// movieSortColumns - допустимые значения параметра by и колонки, по которым сортируются списки фильмов
+var movieSortColumns = map[string]string{
+	"name":      "m.name",
+	"rating":    "m.rating",
+	"date":      "m.date",
+	"community": "rs.community_rating",
+}
+
+// movieOrderBy возвращает ORDER BY для списка фильмов. by и order должны быть проверены заранее.
+// Фильмы без оценок пользователей идут последними.
+func movieOrderBy(by, order string) string {
+	return fmt.Sprintf("%s %s NULLS LAST, m.id", movieSortColumns[by], order)
+}
+
 func GetMoviesOrdered(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -926,74 +811,47 @@ func GetMoviesOrdered(w http.ResponseWriter, r *http.Request) {
 
 	by := r.URL.Query().Get("by")
 
-	if by != "name" && by != "rating" && by != "date" {
+	if _, ok := movieSortColumns[by]; !ok {
 		by = "rating"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	// director оставляет только фильмы, где человек с этим id указан режиссёром,
+	// genre и tag - фильмы с жанром и тегом с таким названием без учёта регистра
+	var directorID int
+	if director := r.URL.Query().Get("director"); director != "" {
+		directorID, err = strconv.Atoi(director)
+		if err != nil || directorID < 1 {
+			http.Error(w, "director must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Список отправляется потоком, пока читается из БД, поэтому ограничен STREAM_TIME_LIMIT,
+	// а запрос отменяется, когда клиент закрывает соединение
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(streamTimeLimit)*time.Second)
 	defer cancel()
 
-	var getMoviesQuery string
+	genre := strings.TrimSpace(r.URL.Query().Get("genre"))
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
 
-	if order == "desc" {
-		switch by {
-		case "name":
-			getMoviesQuery = `
-		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
-		FROM movie m
-		JOIN actormovie ma ON m.id = ma.movie_id
-		JOIN actor a ON a.id = ma.actor_id
-		ORDER BY m.name DESC
-		`
-		case "rating":
-			getMoviesQuery = `
-		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
-		FROM movie m
-		JOIN actormovie ma ON m.id = ma.movie_id
-		JOIN actor a ON a.id = ma.actor_id
-		ORDER BY m.rating DESC
-		`
-		case "date":
-			getMoviesQuery = `
-		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
-		FROM movie m
-		JOIN actormovie ma ON m.id = ma.movie_id
-		JOIN actor a ON a.id = ma.actor_id
-		ORDER BY m.date DESC
-		`
-		default:
-		}
-	} else {
-		switch by {
-		case "name":
-			getMoviesQuery = `
-		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
-		FROM movie m
-		JOIN actormovie ma ON m.id = ma.movie_id
-		JOIN actor a ON a.id = ma.actor_id
-		ORDER BY m.name
-		`
-		case "rating":
-			getMoviesQuery = `
-		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
-		FROM movie m
-		JOIN actormovie ma ON m.id = ma.movie_id
-		JOIN actor a ON a.id = ma.actor_id
-		ORDER BY m.rating
-		`
-		case "date":
-			getMoviesQuery = `
-		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
+	// Общее условие списка: режиссёр, жанр и тег по названию
+	listCondition := `($1 = 0 OR EXISTS (SELECT 1 FROM crew c
+		WHERE c.movie_id = m.id AND c.person_id = $1 AND c.department = 'directing'))
+		AND ` + taxonomyCondition(2, 3)
+
+	// by и order проверены выше по списку допустимых значений
+	getMoviesQuery := `
+		SELECT m.id, m.name, m.description, m.date, m.rating, ` + communityColumns + `,
+		` + watchColumns(4) + `, a.firstName, a.lastName, a.sex, a.birthDate
 		FROM movie m
+		` + ratingStatsJoin + `
 		JOIN actormovie ma ON m.id = ma.movie_id
 		JOIN actor a ON a.id = ma.actor_id
-		ORDER BY m.date
-		`
-		default:
-		}
-	}
+		WHERE m.deleted_at IS NULL AND a.deleted_at IS NULL
+		AND ` + listCondition + `
+		ORDER BY ` + movieOrderBy(by, order)
 
-	rows, err := db.QueryContext(ctx, getMoviesQuery)
+	rows, err := db.QueryContext(ctx, getMoviesQuery, directorID, genre, tag, claims.Issuer)
 	defer rows.Close()
 	if err != nil {
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
@@ -1007,55 +865,56 @@ func GetMoviesOrdered(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var movies []model.Movie
+	// Строки одного фильма идут подряд (ORDER BY заканчивается на m.id), поэтому фильм
+	// отправляется клиенту, как только начинаются строки следующего
+	stream := newListStreamer(w, r)
 	var currentMovie *model.Movie
 
 	for rows.Next() {
 		var movie model.Movie
 		var actor model.Actor
+		var community model.RatingSummary
+		var onWatchlist bool
+		var watchedOn sql.NullTime
 
-		if err := rows.Scan(&movie.Name, &movie.Description, &movie.Date, &movie.Rating,
+		if err := rows.Scan(&movie.ID, &movie.Name, &movie.Description, &movie.Date, &movie.Rating,
+			&community.Average, &community.Count, &onWatchlist, &watchedOn,
 			&actor.FirstName, &actor.LastName, &actor.Sex, &actor.BirthDate); err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			stream.fail(ctx, "GetMoviesOrdered", err)
 			return
 		}
 
-		// Check if we're still processing the same movie
-		if currentMovie != nil && movie.Name == currentMovie.Name && movie.Date == currentMovie.Date {
-			// Add actor to the current movie's actor list
+		if currentMovie != nil && movie.ID == currentMovie.ID {
 			currentMovie.Actors = append(currentMovie.Actors, actor)
-		} else {
-			// We've encountered a new movie, so add the previous one to the movies slice
-			if currentMovie != nil {
-				movies = append(movies, *currentMovie)
+			continue
+		}
+
+		if currentMovie != nil {
+			if err := stream.write(currentMovie); err != nil {
+				log.Printf("Write failed: %v\n", err)
+				return
 			}
-			// Start aggregating actors for the new movie
-			movie.Actors = []model.Actor{actor}
-			currentMovie = &movie
 		}
+
+		movie.CommunityRating = &community
+		setWatchState(&movie, onWatchlist, watchedOn)
+		movie.Actors = []model.Actor{actor}
+		currentMovie = &movie
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		stream.fail(ctx, "GetMoviesOrdered", err)
 		return
 	}
 
 	if currentMovie != nil {
-		movies = append(movies, *currentMovie)
-	}
-
-	resp, err := json.Marshal(movies)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		if err := stream.write(currentMovie); err != nil {
+			log.Printf("Write failed: %v\n", err)
+			return
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(resp)
-	if err != nil {
+	if err := stream.close(); err != nil {
 		log.Printf("Write failed: %v\n", err)
 	}
 }
@@ -1114,20 +973,49 @@ func SearchMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	movie.Genre = strings.TrimSpace(movie.Genre)
+	movie.Tag = strings.TrimSpace(movie.Tag)
+
+	if len([]rune(movie.Genre)) > maxTermNameLength || len([]rune(movie.Tag)) > maxTermNameLength {
+		http.Error(w, fmt.Sprintf("Genre and tag maximum length is %d characters", maxTermNameLength), http.StatusBadRequest)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
 	defer cancel()
 
+	// Если заданы только жанр или тег, поиск по названию и актёру не ограничивает выборку
+	searchMovieCondition := `
+		(($1 <> '' AND m.name LIKE '%' || $1 || '%')
+		OR (($2 <> '' AND a.firstName LIKE '%' || $2 || '%')
+    	OR ($3 <> '' AND a.lastName LIKE '%' || $3 || '%'))
+		OR ($1 = '' AND $2 = '' AND $3 = '' AND ($4 <> '' OR $5 <> '')))
+		AND ` + taxonomyCondition(4, 5)
+
 	searchMovieQuery := `
 		SELECT m.name, m.description, m.date, m.rating, a.firstName, a.lastName, a.sex, a.birthDate
 		FROM movie m
 		JOIN actormovie ma ON m.id = ma.movie_id
 		JOIN actor a ON a.id = ma.actor_id
-		WHERE ($1 <> '' AND m.name LIKE '%' || $1 || '%')
-		OR (($2 <> '' AND a.firstName LIKE '%' || $2 || '%')
-    	OR ($3 <> '' AND a.lastName LIKE '%' || $3 || '%'));
+		WHERE m.deleted_at IS NULL AND a.deleted_at IS NULL AND (` + searchMovieCondition + `);
 	`
 
-	rows, err := db.QueryContext(ctx, searchMovieQuery, movie.Name, movie.ActorFirstName, movie.ActorLastName)
+	args := []interface{}{movie.Name, movie.ActorFirstName, movie.ActorLastName, movie.Genre, movie.Tag}
+
+	withFacets := facetsRequested(r)
+
+	var q queryer = db
+	if withFacets {
+		tx, err := beginSnapshot(ctx)
+		if err != nil {
+			http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	rows, err := q.QueryContext(ctx, searchMovieQuery, args...)
 	defer rows.Close()
 	if err != nil {
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
@@ -1180,7 +1068,35 @@ func SearchMovie(w http.ResponseWriter, r *http.Request) {
 		movies = append(movies, *currentMovie)
 	}
 
-	resp, err := json.Marshal(movies)
+	var resp []byte
+	if withFacets {
+		matchedMoviesQuery := `
+		SELECT DISTINCT m.id
+		FROM movie m
+		JOIN actormovie ma ON m.id = ma.movie_id
+		JOIN actor a ON a.id = ma.actor_id
+		WHERE m.deleted_at IS NULL AND a.deleted_at IS NULL AND (` + searchMovieCondition + `)`
+
+		var facets model.MovieFacets
+		facets, err = queryMovieFacets(ctx, q, matchedMoviesQuery, args)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Println("SearchMovie facets deadline exceeded: ", err)
+				http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+				return
+			}
+			log.Println("Database error: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if movies == nil {
+			movies = []model.Movie{}
+		}
+		resp, err = json.Marshal(model.MovieSearchResult{Movies: movies, Facets: &facets})
+	} else {
+		resp, err = json.Marshal(movies)
+	}
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -1193,3 +1109,376 @@ func SearchMovie(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Write failed: %v\n", err)
 	}
 }
+
+const (
+	filterMoviesDefaultLimit = 50
+	filterMoviesMaxLimit     = 500
+)
+
+// scanMoviesWithActors агрегирует строки вида (фильм, актёр) в список фильмов с актёрами.
+// Строки одного фильма должны идти подряд, колонки актёра могут быть NULL (LEFT JOIN).
+// Перед колонками актёра идут колонки отметок пользователя watchColumns.
+func scanMoviesWithActors(rows *sql.Rows) ([]model.Movie, error) {
+	movies := []model.Movie{}
+	var currentMovie *model.Movie
+
+	for rows.Next() {
+		var movie model.Movie
+		var community model.RatingSummary
+		var onWatchlist bool
+		var watchedOn sql.NullTime
+		var actorID sql.NullInt64
+		var firstName, lastName, sex sql.NullString
+		var birthDate sql.NullTime
+
+		if err := rows.Scan(&movie.ID, &movie.Name, &movie.Description, &movie.Date, &movie.Rating,
+			&community.Average, &community.Count, &onWatchlist, &watchedOn,
+			&actorID, &firstName, &lastName, &sex, &birthDate); err != nil {
+			return nil, err
+		}
+
+		movie.CommunityRating = &community
+		setWatchState(&movie, onWatchlist, watchedOn)
+
+		if currentMovie == nil || currentMovie.ID != movie.ID {
+			if currentMovie != nil {
+				movies = append(movies, *currentMovie)
+			}
+			currentMovie = &movie
+		}
+
+		if actorID.Valid {
+			currentMovie.Actors = append(currentMovie.Actors, model.Actor{
+				ID:        int(actorID.Int64),
+				FirstName: firstName.String,
+				LastName:  lastName.String,
+				Sex:       sex.String,
+				BirthDate: birthDate.Time,
+			})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if currentMovie != nil {
+		movies = append(movies, *currentMovie)
+	}
+
+	return movies, nil
+}
+
+func FilterMovies(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var filterMovies model.FilterMovies
+
+	err := json.NewDecoder(r.Body).Decode(&filterMovies)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if filterMovies.Order != "asc" && filterMovies.Order != "desc" {
+		filterMovies.Order = "desc"
+	}
+
+	if _, ok := movieSortColumns[filterMovies.By]; !ok {
+		filterMovies.By = "rating"
+	}
+
+	if filterMovies.Limit < 0 || filterMovies.Limit > filterMoviesMaxLimit {
+		http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", filterMoviesMaxLimit), http.StatusBadRequest)
+		return
+	}
+
+	if filterMovies.Limit == 0 {
+		filterMovies.Limit = filterMoviesDefaultLimit
+	}
+
+	if filterMovies.Offset < 0 {
+		http.Error(w, "Offset cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	condition, args, err := compileMovieFilter(filterMovies.Filter)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// by и order проверены выше по списку допустимых значений
+	orderBy := movieOrderBy(filterMovies.By, filterMovies.Order)
+
+	args = append(args, filterMovies.Limit, filterMovies.Offset, issuer)
+
+	filterMoviesQuery := fmt.Sprintf(`
+	SELECT m.id, m.name, m.description, m.date, m.rating, %[1]s,
+	%[2]s, a.id, a.firstName, a.lastName, a.sex, a.birthDate
+	FROM (
+		SELECT m.* FROM movie m
+		%[3]s
+		WHERE m.deleted_at IS NULL AND (%[4]s)
+		ORDER BY %[5]s
+		LIMIT $%[6]d OFFSET $%[7]d
+	) m
+	%[3]s
+	LEFT JOIN actormovie ma ON m.id = ma.movie_id
+	LEFT JOIN actor a ON a.id = ma.actor_id AND a.deleted_at IS NULL
+	ORDER BY %[5]s, a.id;
+	`, communityColumns, watchColumns(len(args)), ratingStatsJoin, condition, orderBy, len(args)-2, len(args)-1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	withFacets := facetsRequested(r)
+
+	var q queryer = db
+	if withFacets {
+		tx, err := beginSnapshot(ctx)
+		if err != nil {
+			http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	rows, err := q.QueryContext(ctx, filterMoviesQuery, args...)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("FilterMovies QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		} else {
+			log.Println("Database error: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer rows.Close()
+
+	movies, err := scanMoviesWithActors(rows)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var resp []byte
+	if withFacets {
+		// Фасеты считаются по всем подходящим фильмам, а не только по текущей странице
+		matchedMoviesQuery := `SELECT m.id FROM movie m WHERE m.deleted_at IS NULL AND (` + condition + `)`
+
+		var facets model.MovieFacets
+		facets, err = queryMovieFacets(ctx, q, matchedMoviesQuery, args[:len(args)-3])
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Println("FilterMovies facets deadline exceeded: ", err)
+				http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+				return
+			}
+			log.Println("Database error: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err = json.Marshal(model.MovieSearchResult{Movies: movies, Facets: &facets})
+	} else {
+		resp, err = json.Marshal(movies)
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// GetMovie возвращает фильм по id из пути вместе с составом в порядке титров, съёмочной группой, жанрами, тегами
+// и оценками пользователей. Ответ содержит ETag с версией записи, при совпадении If-None-Match возвращается 304 без тела.
+func GetMovie(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	// Версия и все сведения читаются в одном снимке, чтобы ETag соответствовал телу ответа
+	tx, err := beginSnapshot(ctx)
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	movie, version, err := getMovieDetails(ctx, tx, issuer, id)
+
+	writeMovieDetails(ctx, w, r, "GetMovie", movie, version, err)
+}
+
+// movieDetailsQuery выбирает неудалённый фильм $1 со сведениями, оценками пользователей и версией
+const movieDetailsQuery = `
+	SELECT m.id, m.name, m.description, m.date, m.rating, ` + communityColumns + `,
+	COALESCE(m.runtime, 0), m.countries, COALESCE(m.original_language, ''), COALESCE(m.original_title, ''),
+	COALESCE(m.age_certification, ''), COALESCE(m.imdb_id, ''), COALESCE(m.tmdb_id, ''), COALESCE(m.kinopoisk_id, ''),
+	m.version
+	FROM movie m
+	` + ratingStatsJoin + `
+	WHERE m.id = $1 AND m.deleted_at IS NULL;
+	`
+
+// getMovieDetails возвращает фильм со всеми сведениями, отметками пользователя issuer и версию записи.
+// Если фильма нет, возвращается sql.ErrNoRows. Чтобы версия соответствовала сведениям, q должен быть
+// транзакцией beginSnapshot.
+func getMovieDetails(ctx context.Context, q queryer, issuer string, id int) (model.MovieDetails, int, error) {
+	var movie model.MovieDetails
+	var community model.RatingSummary
+	var externalIDs model.ExternalIDs
+	var version int
+
+	err := q.QueryRowContext(ctx, movieDetailsQuery, id).Scan(&movie.ID, &movie.Name, &movie.Description, &movie.Date,
+		&movie.Rating, &community.Average, &community.Count, &movie.Runtime, pq.Array(&movie.Countries),
+		&movie.OriginalLanguage, &movie.OriginalTitle, &movie.AgeCertification,
+		&externalIDs.IMDb, &externalIDs.TMDb, &externalIDs.Kinopoisk, &version)
+	if err == nil {
+		movie.CommunityRating = &community
+		if externalIDs != (model.ExternalIDs{}) {
+			movie.ExternalIDs = &externalIDs
+		}
+		movie.MyRating, err = getUserRating(ctx, q, issuer, id)
+	}
+	if err == nil {
+		var state model.WatchState
+		state, err = getWatchState(ctx, q, issuer, id)
+		movie.OnWatchlist, movie.Watched, movie.WatchedOn = state.OnWatchlist, state.Watched, state.WatchedOn
+	}
+	if err == nil {
+		movie.Cast, err = getMovieCast(ctx, q, id)
+	}
+	if err == nil {
+		movie.Crew, err = getMovieCrew(ctx, q, id)
+	}
+	if err == nil {
+		movie.Genres, err = getMovieGenres(ctx, q, id)
+	}
+	if err == nil {
+		movie.Tags, err = getMovieTags(ctx, q, id)
+	}
+
+	return movie, version, err
+}
+
+// writeMovieDetails отправляет фильм с ETag по версии записи, оценкам и отметкам пользователя,
+// при совпадении If-None-Match - 304 без тела
+func writeMovieDetails(ctx context.Context, w http.ResponseWriter, r *http.Request, name string,
+	movie model.MovieDetails, version int, err error) {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Movie not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "QueryRowContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var watchedOn string
+	if movie.WatchedOn != nil {
+		watchedOn = movie.WatchedOn.Format(time.DateOnly)
+	}
+
+	etag := formatStateETag(version, movie.MyRating, *movie.CommunityRating, movie.OnWatchlist, movie.Watched, watchedOn)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Cookie")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp, err := json.Marshal(movie)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// GetMovieByExternalID возвращает фильм по идентификатору во внешнем каталоге из параметров source и externalID
+// так же, как GetMovie
+func GetMovieByExternalID(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	source, ok := findExternalIDSource(r.URL.Query().Get("source"))
+	if !ok {
+		http.Error(w, "source must be one of imdb, tmdb, kinopoisk", http.StatusBadRequest)
+		return
+	}
+
+	externalID := r.URL.Query().Get("externalID")
+	if !source.pattern.MatchString(externalID) {
+		http.Error(w, "Invalid "+source.name+" ID "+strconv.Quote(externalID), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	// Версия и все сведения читаются в одном снимке, чтобы ETag соответствовал телу ответа
+	tx, err := beginSnapshot(ctx)
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM movie WHERE `+source.column+` = $1 AND deleted_at IS NULL;`,
+		externalID).Scan(&id)
+
+	var movie model.MovieDetails
+	var version int
+	if err == nil {
+		movie, version, err = getMovieDetails(ctx, tx, issuer, id)
+	}
+
+	writeMovieDetails(ctx, w, r, "GetMovieByExternalID", movie, version, err)
+}
@@ -0,0 +1,951 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+// Операции над каталогом выполняются в транзакции вызывающего: обработчики отдельных запросов
+// и пакетный запрос /api/batch используют одни и те же функции.
+
+// catalogError - ожидаемая ошибка операции, которая возвращается клиенту с кодом status
+type catalogError struct {
+	status  int
+	message string
+}
+
+func (e *catalogError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &catalogError{status: http.StatusBadRequest, message: message}
+}
+
+// preconditionFailed переводит errPreconditionFailed из checkIfMatch в ответ 412 для записи noun
+func preconditionFailed(err error, noun string) error {
+	if errors.Is(err, errPreconditionFailed) {
+		return &catalogError{status: http.StatusPreconditionFailed,
+			message: noun + " has been modified, reload it and try again"}
+	}
+	return err
+}
+
+var notFoundMessages = map[string]string{
+	auditEntityActor: "Actor not found",
+	auditEntityMovie: "Movie not found",
+}
+
+// requireActive проверяет, что неудалённая запись существует, иначе возвращает ответ 404
+func requireActive(ctx context.Context, tx *sql.Tx, entity string, id int) error {
+	var version int
+
+	err := tx.QueryRowContext(ctx, versionQueries[entity], id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &catalogError{status: http.StatusNotFound, message: notFoundMessages[entity]}
+	}
+
+	return err
+}
+
+// sortedUniqueIDs возвращает id без повторов в порядке возрастания
+func sortedUniqueIDs(ids []int) []int {
+	unique := []int{}
+	seen := make(map[int]bool, len(ids))
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	sort.Ints(unique)
+
+	return unique
+}
+
+// requireActors проверяет, что все актёры из ids существуют и не удалены.
+// Иначе возвращает ответ 400 со списком ненайденных id.
+func requireActors(ctx context.Context, tx *sql.Tx, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	missingActorsQuery := `
+	SELECT requested.id FROM unnest($1::int[]) AS requested(id)
+	WHERE NOT EXISTS (SELECT 1 FROM actor a WHERE a.id = requested.id AND a.deleted_at IS NULL)
+	ORDER BY requested.id;
+	`
+
+	rows, err := tx.QueryContext(ctx, missingActorsQuery, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		missing = append(missing, strconv.Itoa(id))
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return badRequest("Actors not found: " + strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func validateActor(firstName, lastName, sex string, birthDate time.Time) error {
+	if len([]rune(firstName)) > 255 {
+		return badRequest("Maximum firstName string length is 255 symbols")
+	}
+
+	if len([]rune(lastName)) > 255 {
+		return badRequest("Maximum lastName string length is 255 symbols")
+	}
+
+	if len([]rune(sex)) > 10 {
+		return badRequest("Maximum sex string length is 10 symbols")
+	}
+
+	if birthDate.After(time.Now()) {
+		return badRequest("Birth date cannot be in the future")
+	}
+
+	return nil
+}
+
+// validateNewMovie проверяет фильм перед добавлением
+func validateNewMovie(movie model.Movie) error {
+	if len([]rune(movie.Name)) < 1 || len([]rune(movie.Name)) > 150 {
+		return badRequest("Movie name must be between 1 and 150 characters long")
+	}
+
+	if len([]rune(movie.Description)) > 1000 {
+		return badRequest("Movie description maximum length is 1000 characters")
+	}
+
+	if movie.Rating < 0 || movie.Rating > 10 {
+		return badRequest("Movie rating must be between 0 and 10")
+	}
+
+	return validateMovieMetadata(movie)
+}
+
+// validateMovieUpdate проверяет изменение фильма. Пустые название, описание и дата при изменении
+// не меняются, пустые сведения о фильме стираются.
+func validateMovieUpdate(movie model.MovieUpdate) error {
+	if len([]rune(movie.Name)) > 150 {
+		return badRequest("Movie name maximum length is 150 characters")
+	}
+
+	if len([]rune(movie.Description)) > 1000 {
+		return badRequest("Movie description maximum length is 1000 characters")
+	}
+
+	if movie.Rating > 10 {
+		return badRequest("Movie rating maximum value is 10")
+	}
+
+	return validateMovieMetadata(movieUpdateMetadata(movie))
+}
+
+// movieUpdateMetadata переносит сведения, заданные в изменении фильма, в model.Movie для проверок.
+// Незаданные и стираемые сведения остаются пустыми.
+func movieUpdateMetadata(movie model.MovieUpdate) model.Movie {
+	metadata := model.Movie{Countries: movie.Countries}
+
+	if movie.Runtime != nil {
+		metadata.Runtime = *movie.Runtime
+	}
+	if movie.OriginalLanguage != nil {
+		metadata.OriginalLanguage = *movie.OriginalLanguage
+	}
+	if movie.OriginalTitle != nil {
+		metadata.OriginalTitle = *movie.OriginalTitle
+	}
+	if movie.AgeCertification != nil {
+		metadata.AgeCertification = *movie.AgeCertification
+	}
+
+	if ids := movie.ExternalIDs; ids != nil {
+		metadata.ExternalIDs = &model.ExternalIDs{}
+		if ids.IMDb != nil {
+			metadata.ExternalIDs.IMDb = *ids.IMDb
+		}
+		if ids.TMDb != nil {
+			metadata.ExternalIDs.TMDb = *ids.TMDb
+		}
+		if ids.Kinopoisk != nil {
+			metadata.ExternalIDs.Kinopoisk = *ids.Kinopoisk
+		}
+	}
+
+	return metadata
+}
+
+const maxMovieCountries = 20
+
+var (
+	countryCodePattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+	languageCodePattern = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// movieAgeCertifications - допустимые возрастные рейтинги фильма
+var movieAgeCertifications = map[string]bool{"0+": true, "6+": true, "12+": true, "16+": true, "18+": true}
+
+// externalIDSource - внешний каталог: колонка movie с идентификатором, формат идентификатора и его значение в фильме
+type externalIDSource struct {
+	name    string
+	column  string
+	pattern *regexp.Regexp
+	value   func(ids model.ExternalIDs) string
+}
+
+// externalIDSources - внешние каталоги, идентификаторы которых хранятся у фильма.
+// Имена колонок подставляются в SQL только отсюда.
+var externalIDSources = []externalIDSource{
+	{name: "imdb", column: "imdb_id", pattern: regexp.MustCompile(`^tt[0-9]{7,10}$`),
+		value: func(ids model.ExternalIDs) string { return ids.IMDb }},
+	{name: "tmdb", column: "tmdb_id", pattern: regexp.MustCompile(`^[1-9][0-9]{0,9}$`),
+		value: func(ids model.ExternalIDs) string { return ids.TMDb }},
+	{name: "kinopoisk", column: "kinopoisk_id", pattern: regexp.MustCompile(`^[1-9][0-9]{0,9}$`),
+		value: func(ids model.ExternalIDs) string { return ids.Kinopoisk }},
+}
+
+// findExternalIDSource возвращает внешний каталог по имени
+func findExternalIDSource(name string) (externalIDSource, bool) {
+	for _, source := range externalIDSources {
+		if source.name == name {
+			return source, true
+		}
+	}
+
+	return externalIDSource{}, false
+}
+
+// externalIDValues возвращает идентификаторы фильма в порядке externalIDSources, пустые - если не указаны
+func externalIDValues(ids *model.ExternalIDs) []string {
+	values := make([]string, len(externalIDSources))
+	if ids != nil {
+		for i, source := range externalIDSources {
+			values[i] = source.value(*ids)
+		}
+	}
+
+	return values
+}
+
+// requireUniqueExternalIDs проверяет, что внешние идентификаторы не принадлежат другому неудалённому фильму.
+// movieID - id изменяемого фильма, 0 при добавлении.
+func requireUniqueExternalIDs(ctx context.Context, tx *sql.Tx, movieID int, ids *model.ExternalIDs) error {
+	for i, value := range externalIDValues(ids) {
+		if value == "" {
+			continue
+		}
+
+		source := externalIDSources[i]
+
+		var otherID int
+		err := tx.QueryRowContext(ctx, `SELECT id FROM movie WHERE `+source.column+` = $1 AND deleted_at IS NULL AND id <> $2;`,
+			value, movieID).Scan(&otherID)
+		if err == nil {
+			return &catalogError{status: http.StatusConflict,
+				message: "The " + source.name + " ID " + value + " is already assigned to movie " + strconv.Itoa(otherID)}
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateMovieMetadata проверяет длительность, страны, язык, название, возрастной рейтинг и внешние
+// идентификаторы фильма. Пустые значения означают, что сведения не указаны или не меняются.
+func validateMovieMetadata(movie model.Movie) error {
+	if movie.Runtime < 0 || movie.Runtime > 1000 {
+		return badRequest("Movie runtime must be between 1 and 1000 minutes")
+	}
+
+	if len(movie.Countries) > maxMovieCountries {
+		return badRequest("Movie can have at most " + strconv.Itoa(maxMovieCountries) + " countries")
+	}
+
+	seen := make(map[string]bool, len(movie.Countries))
+	for _, country := range movie.Countries {
+		if !countryCodePattern.MatchString(country) {
+			return badRequest("Countries must be ISO 3166-1 alpha-2 codes in upper case, e.g. RU or US")
+		}
+		if seen[country] {
+			return badRequest("Country " + country + " is listed more than once")
+		}
+		seen[country] = true
+	}
+
+	if movie.OriginalLanguage != "" && !languageCodePattern.MatchString(movie.OriginalLanguage) {
+		return badRequest("Original language must be an ISO 639-1 code in lower case, e.g. ru or en")
+	}
+
+	if len([]rune(movie.OriginalTitle)) > 150 {
+		return badRequest("Original title maximum length is 150 characters")
+	}
+
+	if movie.AgeCertification != "" && !movieAgeCertifications[movie.AgeCertification] {
+		return badRequest("Age certification must be one of 0+, 6+, 12+, 16+, 18+")
+	}
+
+	for i, value := range externalIDValues(movie.ExternalIDs) {
+		if value != "" && !externalIDSources[i].pattern.MatchString(value) {
+			return badRequest("Invalid " + externalIDSources[i].name + " ID " + strconv.Quote(value))
+		}
+	}
+
+	return nil
+}
+
+// creditRoleTypes - допустимые типы ролей в составе фильма
+var creditRoleTypes = map[string]bool{"lead": true, "supporting": true, "cameo": true, "voice": true}
+
+func validateCredit(character string, billingOrder int, roleType string) error {
+	if len([]rune(character)) > 255 {
+		return badRequest("Maximum character string length is 255 symbols")
+	}
+
+	if billingOrder < 0 {
+		return badRequest("Billing order cannot be negative")
+	}
+
+	if roleType != "" && !creditRoleTypes[roleType] {
+		return badRequest("Role type must be one of lead, supporting, cameo, voice")
+	}
+
+	return nil
+}
+
+func addActorTx(ctx context.Context, tx *sql.Tx, issuer string, actor model.Actor) (int, error) {
+	addActorQuery := `
+	INSERT INTO actor (firstName, lastName, sex, birthDate)
+	VALUES ($1::text, $2::text, $3::text, $4)
+	ON CONFLICT (firstName, lastName, birthDate) WHERE deleted_at IS NULL DO NOTHING
+	RETURNING id;`
+
+	var actorID int
+
+	err := tx.QueryRowContext(ctx, addActorQuery, actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate).Scan(&actorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, badRequest("Actor already exists")
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "add_actor", auditEntityActor, actorID, nil)
+	}
+
+	return actorID, err
+}
+
+// updateActorTx меняет непустые поля актёра. Если актёра нет, ничего не происходит.
+func updateActorTx(ctx context.Context, tx *sql.Tx, issuer string, actor model.Actor, ifMatch string) error {
+	updateActorQuery := `UPDATE actor
+							SET
+								firstName = COALESCE(NULLIF($1, ''), firstName),
+								lastName = COALESCE(NULLIF($2, ''), lastName),
+								sex = COALESCE(NULLIF($3, ''), sex),
+								birthDate = CASE WHEN $4::date = '0001-01-01' THEN birthDate ELSE $4::date END
+						WHERE id = $5 AND deleted_at IS NULL;
+						    `
+
+	before, err := snapshot(ctx, tx, auditEntityActor, actor.ID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityActor, actor.ID, ifMatch), "Actor")
+	}
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, updateActorQuery, actor.FirstName, actor.LastName, actor.Sex, actor.BirthDate, actor.ID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = auditChange(ctx, tx, issuer, "update_actor", auditEntityActor, actor.ID, before)
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = recordRevision(ctx, tx, issuer, auditEntityActor, actor.ID, before)
+	}
+
+	return err
+}
+
+func deleteActorTx(ctx context.Context, tx *sql.Tx, issuer string, actorID int, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityActor, actorID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityActor, actorID, ifMatch), "Actor")
+	}
+
+	// Связи с фильмами сохраняются, чтобы актёра можно было восстановить вместе с фильмографией.
+	// Окончательно они удаляются каскадно при очистке корзины.
+	deleteActorQuery := `UPDATE actor SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL;`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, deleteActorQuery, actorID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = badRequest("Actor doesn't exist. Nothing deleted")
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "delete_actor", auditEntityActor, actorID, before)
+	}
+
+	return err
+}
+
+// addMovieTx добавляет фильм, недостающих актёров из movie.Actors и связи с ними
+func addMovieTx(ctx context.Context, tx *sql.Tx, issuer string, movie model.Movie) (int, error) {
+	var actorsID []int
+	var existingIDs []int
+
+	// Актёры с id добавляются в состав как есть, остальные ищутся или создаются по имени и дате рождения
+	for _, actor := range movie.Actors {
+		if actor.ID != 0 {
+			existingIDs = append(existingIDs, actor.ID)
+		}
+	}
+
+	if err := requireActors(ctx, tx, existingIDs); err != nil {
+		return 0, err
+	}
+
+	actorsID = append(actorsID, existingIDs...)
+
+	for _, actor := range movie.Actors {
+		if actor.ID != 0 {
+			continue
+		}
+
+		var actorID int
+		var inserted bool
+
+		err := tx.QueryRowContext(ctx, addMissingActorsQuery, actor.FirstName,
+			actor.LastName, actor.Sex, actor.BirthDate).Scan(&actorID, &inserted)
+
+		if err == nil && inserted {
+			err = auditChange(ctx, tx, issuer, "add_actor", auditEntityActor, actorID, nil)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if actorID != 0 {
+			actorsID = append(actorsID, actorID)
+		}
+	}
+
+	if err := requireUniqueExternalIDs(ctx, tx, 0, movie.ExternalIDs); err != nil {
+		return 0, err
+	}
+
+	addMovieQuery := `
+	INSERT INTO movie (name, description, date, rating, runtime, countries, original_language, original_title,
+		age_certification, imdb_id, tmdb_id, kinopoisk_id)
+	VALUES ($1, $2, $3, $4, NULLIF($5::integer, 0), COALESCE($6::varchar[], '{}'), NULLIF($7, ''), NULLIF($8, ''),
+		NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''))
+	RETURNING id;
+	`
+
+	externalIDs := externalIDValues(movie.ExternalIDs)
+
+	var movieID int
+
+	err := tx.QueryRowContext(ctx, addMovieQuery, movie.Name, movie.Description, movie.Date, movie.Rating,
+		movie.Runtime, pq.Array(movie.Countries), movie.OriginalLanguage, movie.OriginalTitle, movie.AgeCertification,
+		externalIDs[0], externalIDs[1], externalIDs[2]).Scan(&movieID)
+	if err != nil {
+		return 0, err
+	}
+
+	addActorMovieRelQuery := `
+	INSERT INTO ActorMovie (actor_id, movie_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING;
+	`
+
+	// Новый фильм появляется в фильмографии актёра, поэтому меняется и версия актёра,
+	// в том числе переданного по id, которого не затрагивает добавление недостающих актёров
+	for _, actorID := range actorsID {
+		result, err := tx.ExecContext(ctx, addActorMovieRelQuery, actorID, movieID)
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected > 0 {
+			err = bumpVersion(ctx, tx, auditEntityActor, actorID)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return movieID, auditChange(ctx, tx, issuer, "add_movie", auditEntityMovie, movieID, nil)
+}
+
+// updateMovieTx меняет непустые поля фильма и заданные сведения о фильме: NULL в параметре сведений
+// оставляет их без изменений, пустое значение стирает. Если фильма нет, ничего не происходит.
+func updateMovieTx(ctx context.Context, tx *sql.Tx, issuer string, movie model.MovieUpdate, ifMatch string) error {
+	updateMovieQuery := `
+	UPDATE movie
+	SET
+	name = COALESCE(NULLIF($1, ''), name),
+	description = COALESCE(NULLIF($2, ''), description),
+	rating = $3,
+	date = CASE WHEN $4::date = '0001-01-01' THEN date ELSE $4::date END,
+	runtime = CASE WHEN $6::integer IS NULL THEN runtime ELSE NULLIF($6::integer, 0) END,
+	countries = COALESCE($7::varchar[], countries),
+	original_language = CASE WHEN $8::varchar IS NULL THEN original_language ELSE NULLIF($8::varchar, '') END,
+	original_title = CASE WHEN $9::varchar IS NULL THEN original_title ELSE NULLIF($9::varchar, '') END,
+	age_certification = CASE WHEN $10::varchar IS NULL THEN age_certification ELSE NULLIF($10::varchar, '') END,
+	imdb_id = CASE WHEN $11::varchar IS NULL THEN imdb_id ELSE NULLIF($11::varchar, '') END,
+	tmdb_id = CASE WHEN $12::varchar IS NULL THEN tmdb_id ELSE NULLIF($12::varchar, '') END,
+	kinopoisk_id = CASE WHEN $13::varchar IS NULL THEN kinopoisk_id ELSE NULLIF($13::varchar, '') END
+	WHERE id = $5 AND deleted_at IS NULL;
+`
+
+	before, err := snapshot(ctx, tx, auditEntityMovie, movie.ID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movie.ID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireUniqueExternalIDs(ctx, tx, movie.ID, movieUpdateMetadata(movie).ExternalIDs)
+	}
+
+	var imdbID, tmdbID, kinopoiskID *string
+	if movie.ExternalIDs != nil {
+		imdbID, tmdbID, kinopoiskID = movie.ExternalIDs.IMDb, movie.ExternalIDs.TMDb, movie.ExternalIDs.Kinopoisk
+	}
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, updateMovieQuery, movie.Name, movie.Description,
+			movie.Rating, movie.Date, movie.ID, movie.Runtime, pq.Array(movie.Countries), movie.OriginalLanguage,
+			movie.OriginalTitle, movie.AgeCertification, imdbID, tmdbID, kinopoiskID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = auditChange(ctx, tx, issuer, "update_movie", auditEntityMovie, movie.ID, before)
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = recordRevision(ctx, tx, issuer, auditEntityMovie, movie.ID, before)
+	}
+
+	return err
+}
+
+func deleteMovieTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	// Связи с актёрами сохраняются, чтобы фильм можно было восстановить вместе с составом.
+	// Окончательно они удаляются каскадно при очистке корзины.
+	deleteMovieQuery := `UPDATE movie SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL;`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, deleteMovieQuery, movieID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = badRequest("Movie doesn't exist. Nothing deleted")
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "delete_movie", auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// addActorToMovieTx добавляет актёра, если его ещё нет, в состав фильма и возвращает id актёра
+func addActorToMovieTx(ctx context.Context, tx *sql.Tx, issuer string, actorMovie model.ActorMovie) (int, error) {
+	credit := model.Credit{ActorID: actorMovie.ActorID, Character: actorMovie.Character,
+		BillingOrder: actorMovie.BillingOrder, RoleType: actorMovie.RoleType}
+
+	if actorMovie.ActorID != 0 {
+		return actorMovie.ActorID, linkActorTx(ctx, tx, issuer, actorMovie.MovieID, credit, "")
+	}
+
+	var actorID int
+	var inserted bool
+
+	before, err := snapshot(ctx, tx, auditEntityMovie, actorMovie.MovieID)
+
+	if err == nil {
+		err = tx.QueryRowContext(ctx, addMissingActorsQuery, actorMovie.FirstName,
+			actorMovie.LastName, actorMovie.Sex, actorMovie.BirthDate).Scan(&actorID, &inserted)
+	}
+
+	if err == nil && inserted {
+		err = auditChange(ctx, tx, issuer, "add_actor", auditEntityActor, actorID, nil)
+	}
+
+	addActorMovieRelQuery := `
+	INSERT INTO ActorMovie (actor_id, movie_id, character_name, billing_order, role_type)
+	SELECT $1, id, NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, '') FROM movie
+	WHERE id = $2 AND deleted_at IS NULL;
+	`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, addActorMovieRelQuery, actorID, actorMovie.MovieID,
+			credit.Character, credit.BillingOrder, credit.RoleType)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = badRequest("Movie doesn't exist")
+	}
+
+	// Состав входит в представление фильма и фильмографию актёра, поэтому меняет версии обоих
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, actorMovie.MovieID)
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityActor, actorID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "add_actor_to_movie", auditEntityMovie, actorMovie.MovieID, before)
+	}
+
+	return actorID, err
+}
+
+// deleteActorFromMovieTx убирает актёра из состава фильма. Если связи нет, ничего не происходит.
+func deleteActorFromMovieTx(ctx context.Context, tx *sql.Tx, issuer string, actorMovie model.ID) error {
+	deleteActorFromMovieQuery := `
+	DELETE FROM ActorMovie
+	WHERE actor_id = $1
+	AND movie_id = $2;
+	`
+
+	before, err := snapshot(ctx, tx, auditEntityMovie, actorMovie.MovieID)
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, deleteActorFromMovieQuery, actorMovie.ActorID, actorMovie.MovieID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = bumpVersion(ctx, tx, auditEntityMovie, actorMovie.MovieID)
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = bumpVersion(ctx, tx, auditEntityActor, actorMovie.ActorID)
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = auditChange(ctx, tx, issuer, "delete_actor_from_movie", auditEntityMovie, actorMovie.MovieID, before)
+	}
+
+	return err
+}
+
+// linkActorTx добавляет существующего актёра credit.ActorID в состав фильма вместе со сведениями о роли
+func linkActorTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, credit model.Credit, ifMatch string) error {
+	actorID := credit.ActorID
+
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityActor, actorID)
+	}
+
+	linkActorQuery := `
+	INSERT INTO ActorMovie (actor_id, movie_id, character_name, billing_order, role_type)
+	VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, ''))
+	ON CONFLICT DO NOTHING;
+	`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, linkActorQuery, actorID, movieID,
+			credit.Character, credit.BillingOrder, credit.RoleType)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusConflict, message: "Actor is already in the movie cast"}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityActor, actorID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "add_actor_to_movie", auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// updateCreditTx заменяет сведения о роли актёра credit.ActorID в фильме
+func updateCreditTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, credit model.Credit, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	updateCreditQuery := `
+	UPDATE ActorMovie
+	SET character_name = NULLIF($3, ''), billing_order = NULLIF($4, 0), role_type = NULLIF($5, '')
+	WHERE actor_id = $1 AND movie_id = $2;
+	`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, updateCreditQuery, credit.ActorID, movieID,
+			credit.Character, credit.BillingOrder, credit.RoleType)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusNotFound, message: "Actor is not in the movie cast"}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityActor, credit.ActorID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "update_credit", auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// unlinkActorTx убирает актёра из состава фильма, отсутствие связи возвращает ответ 404
+func unlinkActorTx(ctx context.Context, tx *sql.Tx, issuer string, movieID, actorID int, ifMatch string) error {
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	unlinkActorQuery := `DELETE FROM ActorMovie WHERE actor_id = $1 AND movie_id = $2;`
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, unlinkActorQuery, actorID, movieID)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusNotFound, message: "Actor is not in the movie cast"}
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = bumpVersion(ctx, tx, auditEntityActor, actorID)
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "delete_actor_from_movie", auditEntityMovie, movieID, before)
+	}
+
+	return err
+}
+
+// setCastTx заменяет состав фильма списком actorIDs. Связи с удалёнными актёрами сохраняются,
+// чтобы их можно было восстановить вместе с фильмографией.
+func setCastTx(ctx context.Context, tx *sql.Tx, issuer string, movieID int, actorIDs []int,
+	ifMatch string) (model.CastChange, error) {
+	change := model.CastChange{ActorIDs: sortedUniqueIDs(actorIDs), Added: []int{}, Removed: []int{}}
+
+	before, err := snapshot(ctx, tx, auditEntityMovie, movieID)
+
+	if err == nil {
+		err = preconditionFailed(checkIfMatch(ctx, tx, auditEntityMovie, movieID, ifMatch), "Movie")
+	}
+
+	if err == nil {
+		err = requireActive(ctx, tx, auditEntityMovie, movieID)
+	}
+
+	if err == nil {
+		err = requireActors(ctx, tx, change.ActorIDs)
+	}
+
+	removeActorsQuery := `
+	DELETE FROM ActorMovie ma
+	USING actor a
+	WHERE a.id = ma.actor_id AND a.deleted_at IS NULL
+	AND ma.movie_id = $1 AND NOT (ma.actor_id = ANY($2::int[]))
+	RETURNING ma.actor_id;
+	`
+
+	addActorsQuery := `
+	INSERT INTO ActorMovie (actor_id, movie_id)
+	SELECT requested.id, $1 FROM unnest($2::int[]) AS requested(id)
+	ON CONFLICT DO NOTHING
+	RETURNING actor_id;
+	`
+
+	if err == nil {
+		change.Removed, err = collectIDs(ctx, tx, removeActorsQuery, movieID, pq.Array(change.ActorIDs))
+	}
+
+	if err == nil {
+		change.Added, err = collectIDs(ctx, tx, addActorsQuery, movieID, pq.Array(change.ActorIDs))
+	}
+
+	if err != nil {
+		return change, err
+	}
+
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return change, nil
+	}
+
+	// Состав входит в представление фильма и фильмографию актёра, поэтому меняет версии всех затронутых записей
+	err = bumpVersion(ctx, tx, auditEntityMovie, movieID)
+
+	for _, actorID := range append(append([]int{}, change.Added...), change.Removed...) {
+		if err == nil {
+			err = bumpVersion(ctx, tx, auditEntityActor, actorID)
+		}
+	}
+
+	if err == nil {
+		err = auditChange(ctx, tx, issuer, "set_movie_cast", auditEntityMovie, movieID, before)
+	}
+
+	return change, err
+}
+
+// collectIDs выполняет запрос, возвращающий id, и собирает их в порядке возрастания
+func collectIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Ints(ids)
+
+	return ids, nil
+}
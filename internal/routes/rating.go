@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+// ratingOperation меняет оценку пользователя issuer в транзакции и возвращает оценку после изменения
+type ratingOperation func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (int16, error)
+
+func validateScore(score int16) error {
+	if score < 1 || score > 10 {
+		return badRequest("Score must be between 1 and 10")
+	}
+
+	return nil
+}
+
+// Оценки пользователей собираются в таблице movie_rating_stats, а не в строке фильма, поэтому
+// выборки фильмов m присоединяют её как rs через ratingStatsJoin. У фильма без оценок строки в ней нет.
+const (
+	ratingStatsJoin  = `LEFT JOIN movie_rating_stats rs ON rs.movie_id = m.id`
+	communityColumns = `COALESCE(rs.community_rating, 0), COALESCE(rs.rating_count, 0)`
+)
+
+// getCommunityRating возвращает среднюю оценку пользователей и количество оценок фильма
+func getCommunityRating(ctx context.Context, q queryer, movieID int) (model.RatingSummary, error) {
+	var summary model.RatingSummary
+
+	communityRatingQuery := `SELECT COALESCE(community_rating, 0), rating_count FROM movie_rating_stats WHERE movie_id = $1;`
+
+	err := q.QueryRowContext(ctx, communityRatingQuery, movieID).Scan(&summary.Average, &summary.Count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return summary, nil
+	}
+
+	return summary, err
+}
+
+// getUserRating возвращает оценку фильма пользователем или 0, если он его не оценивал
+func getUserRating(ctx context.Context, q queryer, issuer string, movieID int) (int16, error) {
+	var score int16
+
+	err := q.QueryRowContext(ctx, `SELECT score FROM UserRating WHERE person_id = $1 AND movie_id = $2;`,
+		issuer, movieID).Scan(&score)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return score, err
+}
+
+// RateMovie ставит или меняет оценку фильма текущим пользователем
+func RateMovie(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var rating model.UserRating
+
+	err := json.NewDecoder(r.Body).Decode(&rating)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if err := validateScore(rating.Score); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changeRating(w, r, issuer, "RateMovie", func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (int16, error) {
+		err := requireActive(ctx, tx, auditEntityMovie, movieID)
+
+		rateMovieQuery := `
+		INSERT INTO UserRating (person_id, movie_id, score)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (person_id, movie_id) DO UPDATE SET score = EXCLUDED.score, rated_at = now();
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, rateMovieQuery, issuer, movieID, rating.Score)
+		}
+
+		return rating.Score, err
+	})
+}
+
+// DeleteRating удаляет оценку фильма текущим пользователем
+func DeleteRating(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	changeRating(w, r, issuer, "DeleteRating", func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (int16, error) {
+		err := requireActive(ctx, tx, auditEntityMovie, movieID)
+
+		var result sql.Result
+		if err == nil {
+			result, err = tx.ExecContext(ctx, `DELETE FROM UserRating WHERE person_id = $1 AND movie_id = $2;`,
+				issuer, movieID)
+		}
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected == 0 {
+			err = &catalogError{status: http.StatusNotFound, message: "You have not rated this movie"}
+		}
+
+		return 0, err
+	})
+}
+
+// changeRating проверяет id фильма из пути, выполняет operation в транзакции от имени issuer
+// и возвращает оценку пользователя вместе с пересчитанной средней оценкой
+func changeRating(w http.ResponseWriter, r *http.Request, issuer, name string, operation ratingOperation) {
+	movieID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || movieID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	result := model.UserRatingResult{MovieID: movieID}
+
+	result.Score, err = operation(ctx, tx, issuer, movieID)
+
+	if err == nil {
+		result.CommunityRating, err = getCommunityRating(ctx, tx, movieID)
+	}
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("%s Failed to rollback transaction: %v\n", name, rollbackErr)
+		} else {
+			log.Println(name, "transaction rollback")
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println(name, errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(name, "error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
@@ -0,0 +1,274 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+// Рекомендации считаются запросом к базе при каждом обращении. Похожесть двух фильмов - сумма
+// общих актёров с весом recommendCastWeight и общих жанров с весом recommendGenreWeight.
+
+const (
+	recommendCastWeight  = 2
+	recommendGenreWeight = 1
+
+	// recommendMinScore - оценка, начиная с которой фильм считается понравившимся пользователю
+	recommendMinScore = 7
+
+	recommendationsDefaultLimit = 10
+	recommendationsMaxLimit     = 50
+)
+
+// Колонки фильма m в выборках рекомендаций, оценки пользователей присоединяются через ratingStatsJoin
+const recommendMovieColumns = `m.id, m.name, m.description, m.date, m.rating, ` + communityColumns
+
+// movieRecommendationsQuery выбирает фильмы с общими актёрами или жанрами с фильмом $1
+var movieRecommendationsQuery = fmt.Sprintf(`
+	WITH cast_overlap AS (
+		SELECT ma2.movie_id, count(*) AS shared,
+		array_agg(a.firstName || ' ' || a.lastName ORDER BY a.lastName, a.firstName) AS names
+		FROM actormovie ma1
+		JOIN actor a ON a.id = ma1.actor_id AND a.deleted_at IS NULL
+		JOIN actormovie ma2 ON ma2.actor_id = ma1.actor_id AND ma2.movie_id <> ma1.movie_id
+		WHERE ma1.movie_id = $1
+		GROUP BY ma2.movie_id
+	), genre_overlap AS (
+		SELECT mg2.movie_id, count(*) AS shared, array_agg(g.name ORDER BY g.name) AS names
+		FROM moviegenre mg1
+		JOIN genre g ON g.id = mg1.genre_id
+		JOIN moviegenre mg2 ON mg2.genre_id = mg1.genre_id AND mg2.movie_id <> mg1.movie_id
+		WHERE mg1.movie_id = $1
+		GROUP BY mg2.movie_id
+	)
+	SELECT %s,
+	COALESCE(c.shared, 0) * %d + COALESCE(g.shared, 0) * %d AS score,
+	COALESCE(c.names, '{}'), COALESCE(g.names, '{}')
+	FROM cast_overlap c
+	FULL JOIN genre_overlap g ON g.movie_id = c.movie_id
+	JOIN movie m ON m.id = COALESCE(c.movie_id, g.movie_id) AND m.deleted_at IS NULL
+	%s
+	ORDER BY score DESC, rs.community_rating DESC NULLS LAST, m.rating DESC, m.id
+	LIMIT $2;
+	`, recommendMovieColumns, recommendCastWeight, recommendGenreWeight, ratingStatsJoin)
+
+// userRecommendationsQuery выбирает фильмы, похожие на высоко оценённые и просмотренные пользователем $1.
+// Вклад оценённого фильма растёт с оценкой, просмотренный без оценки фильм имеет вес 1.
+// Оценённые и просмотренные фильмы не рекомендуются.
+var userRecommendationsQuery = fmt.Sprintf(`
+	WITH seeds AS (
+		SELECT movie_id, 'rated' AS kind, score - %[4]d + 2 AS weight
+		FROM userrating
+		WHERE person_id = $1 AND score >= %[4]d
+		UNION ALL
+		SELECT movie_id, 'watched', 1
+		FROM watchedmovie w
+		WHERE person_id = $1
+		AND NOT EXISTS (SELECT 1 FROM userrating ur WHERE ur.person_id = $1 AND ur.movie_id = w.movie_id)
+	), links AS (
+		SELECT s.movie_id AS seed_id, s.kind, s.weight * %[2]d AS points, ma2.movie_id
+		FROM seeds s
+		JOIN actormovie ma1 ON ma1.movie_id = s.movie_id
+		JOIN actor a ON a.id = ma1.actor_id AND a.deleted_at IS NULL
+		JOIN actormovie ma2 ON ma2.actor_id = ma1.actor_id AND ma2.movie_id <> s.movie_id
+		UNION ALL
+		SELECT s.movie_id, s.kind, s.weight * %[3]d, mg2.movie_id
+		FROM seeds s
+		JOIN moviegenre mg1 ON mg1.movie_id = s.movie_id
+		JOIN moviegenre mg2 ON mg2.genre_id = mg1.genre_id AND mg2.movie_id <> s.movie_id
+	)
+	SELECT %[1]s,
+	sum(l.points) AS score,
+	COALESCE(array_agg(DISTINCT sm.name ORDER BY sm.name) FILTER (WHERE l.kind = 'rated'), '{}'),
+	COALESCE(array_agg(DISTINCT sm.name ORDER BY sm.name) FILTER (WHERE l.kind = 'watched'), '{}')
+	FROM links l
+	JOIN movie m ON m.id = l.movie_id AND m.deleted_at IS NULL
+	JOIN movie sm ON sm.id = l.seed_id AND sm.deleted_at IS NULL
+	%[5]s
+	WHERE NOT EXISTS (SELECT 1 FROM userrating ur WHERE ur.person_id = $1 AND ur.movie_id = m.id)
+	AND NOT EXISTS (SELECT 1 FROM watchedmovie wm WHERE wm.person_id = $1 AND wm.movie_id = m.id)
+	GROUP BY m.id, rs.movie_id
+	ORDER BY score DESC, rs.community_rating DESC NULLS LAST, m.rating DESC, m.id
+	LIMIT $2;
+	`, recommendMovieColumns, recommendCastWeight, recommendGenreWeight, recommendMinScore, ratingStatsJoin)
+
+// popularRecommendationsQuery выбирает фильмы с лучшей средней оценкой пользователей, которые пользователь $1
+// ещё не оценил и не посмотрел. Используется, когда рекомендовать по его оценкам и просмотрам нечего.
+var popularRecommendationsQuery = fmt.Sprintf(`
+	SELECT %s, 0, '{}'::text[], '{}'::text[]
+	FROM movie m
+	%s
+	WHERE m.deleted_at IS NULL AND rs.rating_count > 0
+	AND NOT EXISTS (SELECT 1 FROM userrating ur WHERE ur.person_id = $1 AND ur.movie_id = m.id)
+	AND NOT EXISTS (SELECT 1 FROM watchedmovie wm WHERE wm.person_id = $1 AND wm.movie_id = m.id)
+	ORDER BY rs.community_rating DESC, rs.rating_count DESC, m.id
+	LIMIT $2;
+	`, recommendMovieColumns, ratingStatsJoin)
+
+// parseRecommendationsLimit читает количество рекомендаций из параметра limit
+func parseRecommendationsLimit(value string) (int, error) {
+	if value == "" {
+		return recommendationsDefaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > recommendationsMaxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", recommendationsMaxLimit)
+	}
+
+	return limit, nil
+}
+
+// recommendationReasons объясняет рекомендацию: для каждого вида причины с непустым списком имён
+// добавляется причина этого вида
+func recommendationReasons(kinds [2]string, names [2][]string) []model.RecommendationReason {
+	reasons := []model.RecommendationReason{}
+
+	for i, kind := range kinds {
+		if len(names[i]) > 0 {
+			reasons = append(reasons, model.RecommendationReason{Kind: kind, Names: names[i]})
+		}
+	}
+
+	return reasons
+}
+
+// queryRecommendations выполняет запрос рекомендаций. Две последние колонки запроса - имена для причин kinds.
+// Если причин нет, рекомендации объясняются причиной fallback.
+func queryRecommendations(ctx context.Context, q queryer, query string, kinds [2]string, fallback string,
+	args ...interface{}) ([]model.Recommendation, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recommendations := []model.Recommendation{}
+	for rows.Next() {
+		var recommendation model.Recommendation
+		var community model.RatingSummary
+		var names [2][]string
+
+		if err := rows.Scan(&recommendation.ID, &recommendation.Name, &recommendation.Description,
+			&recommendation.Date, &recommendation.Rating, &community.Average, &community.Count, &recommendation.Score,
+			pq.Array(&names[0]), pq.Array(&names[1])); err != nil {
+			return nil, err
+		}
+
+		recommendation.CommunityRating = &community
+		recommendation.Reasons = recommendationReasons(kinds, names)
+		if len(recommendation.Reasons) == 0 {
+			recommendation.Reasons = []model.RecommendationReason{{Kind: fallback}}
+		}
+
+		recommendations = append(recommendations, recommendation)
+	}
+
+	return recommendations, rows.Err()
+}
+
+// GetMovieRecommendations возвращает фильмы, похожие на фильм с id из пути по актёрам и жанрам
+func GetMovieRecommendations(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseRecommendationsLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	var exists bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie WHERE id = $1 AND deleted_at IS NULL);`, id).Scan(&exists)
+	if err == nil && !exists {
+		err = sql.ErrNoRows
+	}
+
+	var recommendations []model.Recommendation
+	if err == nil {
+		recommendations, err = queryRecommendations(ctx, db, movieRecommendationsQuery, [2]string{"cast", "genre"}, "",
+			id, limit)
+	}
+
+	writeRecommendations(ctx, w, "GetMovieRecommendations", recommendations, err)
+}
+
+// GetRecommendations возвращает рекомендации текущему пользователю по его оценкам и истории просмотров.
+// Если рекомендовать по ним нечего, возвращаются фильмы с лучшей средней оценкой пользователей.
+func GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := parseRecommendationsLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	recommendations, err := queryRecommendations(ctx, db, userRecommendationsQuery, [2]string{"rated", "watched"}, "",
+		issuer, limit)
+	if err == nil && len(recommendations) == 0 {
+		recommendations, err = queryRecommendations(ctx, db, popularRecommendationsQuery, [2]string{}, "popular",
+			issuer, limit)
+	}
+
+	writeRecommendations(ctx, w, "GetRecommendations", recommendations, err)
+}
+
+func writeRecommendations(ctx context.Context, w http.ResponseWriter, name string, recommendations []model.Recommendation, err error) {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Movie not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(recommendations)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
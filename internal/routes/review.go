@@ -0,0 +1,609 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+// Рецензия видна пользователям сразу после публикации и попадает в очередь модерации в статусе pending.
+// Модератор одобряет рецензию или скрывает её, решение закрывает жалобы на рецензию.
+// Правка автором возвращает рецензию в очередь, скрытую рецензию видят только автор и администраторы.
+
+const (
+	reviewStatusPending  = "pending"
+	reviewStatusApproved = "approved"
+	reviewStatusHidden   = "hidden"
+
+	maxReviewTitleLength  = 150
+	maxReviewBodyLength   = 5000
+	maxReportReasonLength = 500
+)
+
+// reviewOperation меняет рецензии в транзакции от имени issuer и возвращает тело успешного ответа.
+// id - id из пути: фильма при создании рецензии, иначе самой рецензии.
+type reviewOperation func(ctx context.Context, tx *sql.Tx, issuer string, id int) (interface{}, error)
+
+// validateReview проверяет текст рецензии, пробелы по краям уже убраны
+func validateReview(input model.ReviewInput) error {
+	if input.Body == "" {
+		return badRequest("Review body cannot be empty")
+	}
+
+	if len([]rune(input.Title)) > maxReviewTitleLength {
+		return badRequest(fmt.Sprintf("Maximum review title length is %d symbols", maxReviewTitleLength))
+	}
+
+	if len([]rune(input.Body)) > maxReviewBodyLength {
+		return badRequest(fmt.Sprintf("Maximum review body length is %d symbols", maxReviewBodyLength))
+	}
+
+	return nil
+}
+
+func validateReportReason(reason string) error {
+	if len([]rune(reason)) > maxReportReasonLength {
+		return badRequest(fmt.Sprintf("Maximum report reason length is %d symbols", maxReportReasonLength))
+	}
+
+	return nil
+}
+
+func validateModeration(moderation model.ReviewModeration) error {
+	if moderation.Status != reviewStatusApproved && moderation.Status != reviewStatusHidden {
+		return badRequest("Status must be approved or hidden")
+	}
+
+	return nil
+}
+
+// Размер страницы постраничных списков пользователя: рецензий, списка «посмотреть позже», истории просмотров
+const (
+	pageDefaultLimit = 20
+	pageMaxLimit     = 100
+)
+
+// parsePage читает limit и offset постраничного списка из параметров запроса
+func parsePage(query url.Values) (int, int, error) {
+	limit, offset := pageDefaultLimit, 0
+
+	var err error
+
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > pageMaxLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", pageMaxLimit)
+		}
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// reviewsQuery собирает выборку рецензий на неудалённые фильмы, tail содержит WHERE, ORDER BY и LIMIT.
+// Количество жалоб выбирается только для модерации.
+func reviewsQuery(withReports bool, tail string) string {
+	reports := "0"
+	if withReports {
+		reports = "(SELECT count(*) FROM ReviewReport rr WHERE rr.review_id = r.id)"
+	}
+
+	return fmt.Sprintf(`
+	SELECT r.id, r.movie_id, m.name, r.person_id, p.username, r.title, r.body, r.status,
+	%s AS reports, r.created_at, r.updated_at
+	FROM Review r
+	JOIN movie m ON m.id = r.movie_id AND m.deleted_at IS NULL
+	JOIN person p ON p.id = r.person_id
+	%s;
+	`, reports, tail)
+}
+
+func queryReviews(ctx context.Context, q queryer, query string, args ...interface{}) ([]model.Review, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []model.Review{}
+	for rows.Next() {
+		var review model.Review
+		if err := rows.Scan(&review.ID, &review.MovieID, &review.MovieName, &review.PersonID, &review.Username,
+			&review.Title, &review.Body, &review.Status, &review.Reports, &review.CreatedAt, &review.UpdatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+// getReview возвращает рецензию по id или ответ 404, если её нет или фильм удалён
+func getReview(ctx context.Context, q queryer, id int) (model.Review, error) {
+	reviews, err := queryReviews(ctx, q, reviewsQuery(false, "WHERE r.id = $1"), id)
+	if err != nil {
+		return model.Review{}, err
+	}
+
+	if len(reviews) == 0 {
+		return model.Review{}, &catalogError{status: http.StatusNotFound, message: "Review not found"}
+	}
+
+	return reviews[0], nil
+}
+
+// requireReviewAuthor блокирует рецензию и проверяет, что issuer её автор.
+// Если allowAdmin, администратор тоже проходит проверку.
+func requireReviewAuthor(ctx context.Context, tx *sql.Tx, issuer string, id int, allowAdmin bool) error {
+	var authorID int
+
+	err := tx.QueryRowContext(ctx, `SELECT person_id FROM Review WHERE id = $1 FOR UPDATE;`, id).Scan(&authorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &catalogError{status: http.StatusNotFound, message: "Review not found"}
+	}
+	if err != nil || strconv.Itoa(authorID) == issuer {
+		return err
+	}
+
+	if allowAdmin {
+		admin, err := isAdmin(issuer)
+		if err != nil || admin {
+			return err
+		}
+	}
+
+	return &catalogError{status: http.StatusForbidden, message: "You can only change your own reviews"}
+}
+
+// readReview читает текст рецензии из тела запроса. При ошибке ответ уже записан в w.
+func readReview(w http.ResponseWriter, r *http.Request) (model.ReviewInput, bool) {
+	var input model.ReviewInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return input, false
+	}
+
+	input.Title = strings.TrimSpace(input.Title)
+	input.Body = strings.TrimSpace(input.Body)
+
+	if err := validateReview(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return input, false
+	}
+
+	return input, true
+}
+
+// AddReview публикует рецензию текущего пользователя на фильм с id из пути
+func AddReview(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	input, ok := readReview(w, r)
+	if !ok {
+		return
+	}
+
+	changeReview(w, r, issuer, "AddReview", http.StatusCreated, func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) (interface{}, error) {
+		err := requireActive(ctx, tx, auditEntityMovie, movieID)
+
+		addReviewQuery := `
+		INSERT INTO Review (person_id, movie_id, title, body)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (person_id, movie_id) DO NOTHING
+		RETURNING id;
+		`
+
+		var reviewID int
+		if err == nil {
+			err = tx.QueryRowContext(ctx, addReviewQuery, issuer, movieID, input.Title, input.Body).Scan(&reviewID)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = &catalogError{status: http.StatusConflict, message: "You have already reviewed this movie"}
+			}
+		}
+
+		var review model.Review
+		if err == nil {
+			review, err = getReview(ctx, tx, reviewID)
+		}
+
+		return review, err
+	})
+}
+
+// UpdateReview меняет текст рецензии автором и возвращает её в очередь модерации
+func UpdateReview(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	input, ok := readReview(w, r)
+	if !ok {
+		return
+	}
+
+	changeReview(w, r, issuer, "UpdateReview", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, id int) (interface{}, error) {
+		err := requireReviewAuthor(ctx, tx, issuer, id, false)
+
+		updateReviewQuery := `
+		UPDATE Review SET title = $2, body = $3, status = 'pending', updated_at = now()
+		WHERE id = $1;
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, updateReviewQuery, id, input.Title, input.Body)
+		}
+
+		var review model.Review
+		if err == nil {
+			review, err = getReview(ctx, tx, id)
+		}
+
+		return review, err
+	})
+}
+
+// DeleteReview удаляет рецензию. Удалить рецензию может автор или администратор.
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	changeReview(w, r, issuer, "DeleteReview", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, id int) (interface{}, error) {
+		err := requireReviewAuthor(ctx, tx, issuer, id, true)
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM Review WHERE id = $1;`, id)
+		}
+
+		return "Review deleted successfully", err
+	})
+}
+
+// ReportReview отправляет жалобу текущего пользователя на чужую рецензию
+func ReportReview(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var report model.ReviewReport
+
+	err := json.NewDecoder(r.Body).Decode(&report)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	report.Reason = strings.TrimSpace(report.Reason)
+
+	if err := validateReportReason(report.Reason); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changeReview(w, r, issuer, "ReportReview", http.StatusCreated, func(ctx context.Context, tx *sql.Tx, issuer string, id int) (interface{}, error) {
+		review, err := getReview(ctx, tx, id)
+
+		// Скрытые рецензии не видны другим пользователям, жаловаться на них нельзя
+		if err == nil && review.Status == reviewStatusHidden {
+			err = &catalogError{status: http.StatusNotFound, message: "Review not found"}
+		}
+
+		if err == nil && strconv.Itoa(review.PersonID) == issuer {
+			err = badRequest("You cannot report your own review")
+		}
+
+		reportReviewQuery := `
+		INSERT INTO ReviewReport (review_id, person_id, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING;
+		`
+
+		var result sql.Result
+		if err == nil {
+			result, err = tx.ExecContext(ctx, reportReviewQuery, id, issuer, report.Reason)
+		}
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected == 0 {
+			err = &catalogError{status: http.StatusConflict, message: "You have already reported this review"}
+		}
+
+		return "Review reported successfully", err
+	})
+}
+
+// ModerateReview одобряет или скрывает рецензию и закрывает жалобы на неё
+func ModerateReview(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "moderate reviews")
+	if !ok {
+		return
+	}
+
+	var moderation model.ReviewModeration
+
+	err := json.NewDecoder(r.Body).Decode(&moderation)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if err := validateModeration(moderation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changeReview(w, r, issuer, "ModerateReview", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, id int) (interface{}, error) {
+		review, err := getReview(ctx, tx, id)
+
+		moderateReviewQuery := `
+		UPDATE Review SET status = $2, moderated_by = $3, moderated_at = now()
+		WHERE id = $1;
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, moderateReviewQuery, id, moderation.Status, issuer)
+		}
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM ReviewReport WHERE review_id = $1;`, id)
+		}
+
+		review.Status = moderation.Status
+
+		return review, err
+	})
+}
+
+// changeReview проверяет id из пути и выполняет operation в транзакции от имени issuer.
+// Пользователь проверяется в обработчике до чтения тела запроса.
+func changeReview(w http.ResponseWriter, r *http.Request, issuer, name string, status int, operation reviewOperation) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := operation(ctx, tx, issuer, id)
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("%s Failed to rollback transaction: %v\n", name, rollbackErr)
+		} else {
+			log.Println(name, "transaction rollback")
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println(name, errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(name, "error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeReviewResponse(w, status, body)
+}
+
+// GetReview возвращает рецензию по id из пути. Скрытую рецензию видят только автор и администраторы.
+func GetReview(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	review, err := getReview(ctx, db, id)
+
+	if err == nil && review.Status == reviewStatusHidden && strconv.Itoa(review.PersonID) != issuer {
+		var admin bool
+		admin, err = isAdmin(issuer)
+		if err == nil && !admin {
+			err = &catalogError{status: http.StatusNotFound, message: "Review not found"}
+		}
+	}
+
+	respondReviews(ctx, w, "GetReview", review, err)
+}
+
+// GetMovieReviews возвращает рецензии на фильм с id из пути, новые первыми
+func GetMovieReviews(w http.ResponseWriter, r *http.Request) {
+	listReviews(w, r, "GetMovieReviews", `SELECT EXISTS (SELECT 1 FROM movie WHERE id = $1 AND deleted_at IS NULL);`,
+		"Movie not found", "r.movie_id = $1")
+}
+
+// GetUserReviews возвращает рецензии пользователя с id из пути, новые первыми
+func GetUserReviews(w http.ResponseWriter, r *http.Request) {
+	listReviews(w, r, "GetUserReviews", `SELECT EXISTS (SELECT 1 FROM person WHERE id = $1);`,
+		"User not found", "r.person_id = $1")
+}
+
+// listReviews проверяет запросом existsQuery, что владелец списка с id из пути существует,
+// и возвращает страницу рецензий по условию condition. Скрытые рецензии видны только их автору.
+func listReviews(w http.ResponseWriter, r *http.Request, name, existsQuery, notFound, condition string) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	var exists bool
+	err = db.QueryRowContext(ctx, existsQuery, id).Scan(&exists)
+	if err == nil && !exists {
+		err = &catalogError{status: http.StatusNotFound, message: notFound}
+	}
+
+	listReviewsQuery := reviewsQuery(false, `
+	WHERE `+condition+` AND (r.status <> 'hidden' OR r.person_id = $2)
+	ORDER BY r.created_at DESC, r.id DESC
+	LIMIT $3 OFFSET $4`)
+
+	var reviews []model.Review
+	if err == nil {
+		reviews, err = queryReviews(ctx, db, listReviewsQuery, id, issuer, limit, offset)
+	}
+
+	respondReviews(ctx, w, name, reviews, err)
+}
+
+// GetModerationQueue возвращает рецензии, ожидающие проверки, и рецензии с жалобами.
+// Больше всего жалоб - первыми, затем по времени публикации. Параметр status выбирает
+// вместо очереди все рецензии с этим статусом.
+func GetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "moderate reviews"); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != reviewStatusPending && status != reviewStatusApproved && status != reviewStatusHidden {
+		http.Error(w, "Invalid query parameters: status must be pending, approved or hidden", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	moderationQueueQuery := reviewsQuery(true, `
+	WHERE CASE WHEN $1::text = ''
+		THEN r.status = 'pending' OR EXISTS (SELECT 1 FROM ReviewReport rr WHERE rr.review_id = r.id)
+		ELSE r.status = $1 END
+	ORDER BY reports DESC, r.created_at, r.id
+	LIMIT $2 OFFSET $3`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	reviews, err := queryReviews(ctx, db, moderationQueueQuery, status, limit, offset)
+
+	respondReviews(ctx, w, "GetModerationQueue", reviews, err)
+}
+
+// respondReviews записывает в w рецензии или ошибку их выборки
+func respondReviews(ctx context.Context, w http.ResponseWriter, name string, body interface{}, err error) {
+	if err != nil {
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeReviewResponse(w, http.StatusOK, body)
+}
+
+func writeReviewResponse(w http.ResponseWriter, status int, body interface{}) {
+	resp, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
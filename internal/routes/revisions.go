@@ -0,0 +1,425 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/lib/pq"
+)
+
+// restoreRevisionQueries записывают в запись значения полей из ревизии $2.
+// Восстанавливаются только поля, которые меняются через UpdateMovie и UpdateActor, кроме внешних
+// идентификаторов: они уникальны и в ревизиях не хранятся. Ревизии фильмов, записанные до появления
+// метаданных, не содержат их ключей, поэтому такие поля меняются только при наличии ключа.
+var restoreRevisionQueries = map[string]string{
+	auditEntityActor: `
+	UPDATE actor
+	SET firstName = r.data->>'firstName',
+		lastName = r.data->>'lastName',
+		sex = r.data->>'sex',
+		birthDate = (r.data->>'birthDate')::date
+	FROM revision r
+	WHERE actor.id = $1 AND actor.deleted_at IS NULL
+	AND r.entity = 'actor' AND r.entity_id = actor.id AND r.revision = $2;
+	`,
+	auditEntityMovie: `
+	UPDATE movie
+	SET name = r.data->>'name',
+		description = r.data->>'description',
+		date = (r.data->>'date')::date,
+		rating = (r.data->>'rating')::smallint,
+		runtime = CASE WHEN r.data ? 'runtime' THEN (r.data->>'runtime')::smallint ELSE movie.runtime END,
+		countries = CASE WHEN r.data ? 'countries'
+			THEN ARRAY(SELECT jsonb_array_elements_text(COALESCE(r.data->'countries', '[]'::jsonb)))
+			ELSE movie.countries END,
+		original_language = CASE WHEN r.data ? 'originalLanguage' THEN r.data->>'originalLanguage' ELSE movie.original_language END,
+		original_title = CASE WHEN r.data ? 'originalTitle' THEN r.data->>'originalTitle' ELSE movie.original_title END,
+		age_certification = CASE WHEN r.data ? 'ageCertification' THEN r.data->>'ageCertification' ELSE movie.age_certification END
+	FROM revision r
+	WHERE movie.id = $1 AND movie.deleted_at IS NULL
+	AND r.entity = 'movie' AND r.entity_id = movie.id AND r.revision = $2;
+	`,
+}
+
+// recordRevision сохраняет текущее состояние записи как новую ревизию.
+// Если ревизий ещё нет, перед ней сохраняется исходное состояние before под номером 1.
+func recordRevision(ctx context.Context, tx *sql.Tx, issuer, entity string, entityID int,
+	before json.RawMessage) error {
+	after, err := snapshot(ctx, tx, entity, entityID)
+	if err != nil {
+		return err
+	}
+
+	var last int
+
+	lastRevisionQuery := `SELECT COALESCE(MAX(revision), 0) FROM revision WHERE entity = $1 AND entity_id = $2;`
+
+	err = tx.QueryRowContext(ctx, lastRevisionQuery, entity, entityID).Scan(&last)
+	if err != nil {
+		return err
+	}
+
+	// В ревизии хранятся только редактируемые поля
+	addRevisionQuery := `
+	INSERT INTO revision (entity, entity_id, revision, data, person_id)
+	VALUES ($1, $2, $3, $4::jsonb - 'id' - 'deletedAt' - 'credits' - 'crew' - 'genres' - 'tags' - 'externalIDs', $5);
+	`
+
+	if last == 0 && before != nil {
+		last++
+		_, err = tx.ExecContext(ctx, addRevisionQuery, entity, entityID, last, string(before), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, addRevisionQuery, entity, entityID, last+1, nullableJSON(after), issuer)
+
+	return err
+}
+
+// diffRevisions возвращает поля верхнего уровня, значения которых различаются в двух ревизиях
+func diffRevisions(from, to json.RawMessage) ([]model.RevisionChange, error) {
+	var fromFields, toFields map[string]json.RawMessage
+
+	if err := json.Unmarshal(from, &fromFields); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &toFields); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for field := range fromFields {
+		fields[field] = true
+	}
+	for field := range toFields {
+		fields[field] = true
+	}
+
+	changes := []model.RevisionChange{}
+	for field := range fields {
+		fromValue, toValue := fromFields[field], toFields[field]
+		if fromValue == nil {
+			fromValue = json.RawMessage("null")
+		}
+		if toValue == nil {
+			toValue = json.RawMessage("null")
+		}
+
+		if !bytes.Equal(fromValue, toValue) {
+			changes = append(changes, model.RevisionChange{Field: field, From: fromValue, To: toValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes, nil
+}
+
+func GetActorRevisions(w http.ResponseWriter, r *http.Request) {
+	getRevisions(w, r, auditEntityActor)
+}
+
+func GetMovieRevisions(w http.ResponseWriter, r *http.Request) {
+	getRevisions(w, r, auditEntityMovie)
+}
+
+func DiffActorRevisions(w http.ResponseWriter, r *http.Request) {
+	diffRevisionsHandler(w, r, auditEntityActor)
+}
+
+func DiffMovieRevisions(w http.ResponseWriter, r *http.Request) {
+	diffRevisionsHandler(w, r, auditEntityMovie)
+}
+
+func RestoreActorRevision(w http.ResponseWriter, r *http.Request) {
+	restoreRevision(w, r, auditEntityActor)
+}
+
+func RestoreMovieRevision(w http.ResponseWriter, r *http.Request) {
+	restoreRevision(w, r, auditEntityMovie)
+}
+
+func getRevisions(w http.ResponseWriter, r *http.Request, entity string) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "get "+entity+" revisions"); !ok {
+		return
+	}
+
+	entityID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || entityID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	getRevisionsQuery := `
+	SELECT revision, person_id, data, created_at FROM revision
+	WHERE entity = $1 AND entity_id = $2
+	ORDER BY revision;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, getRevisionsQuery, entity, entityID)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("GetRevisions QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		} else {
+			log.Println("Database error: ", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer rows.Close()
+
+	revisions := []model.Revision{}
+	for rows.Next() {
+		var revision model.Revision
+		var personID sql.NullInt64
+		var data []byte
+		if err := rows.Scan(&revision.Revision, &personID, &data, &revision.CreatedAt); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if personID.Valid {
+			id := int(personID.Int64)
+			revision.PersonID = &id
+		}
+		revision.Data = data
+
+		revisions = append(revisions, revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(revisions)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+func diffRevisionsHandler(w http.ResponseWriter, r *http.Request, entity string) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeAdmin(w, r, "get "+entity+" revisions"); !ok {
+		return
+	}
+
+	entityID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || entityID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil || from < 1 {
+		http.Error(w, "from must be a positive revision number", http.StatusBadRequest)
+		return
+	}
+
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil || to < 1 {
+		http.Error(w, "to must be a positive revision number", http.StatusBadRequest)
+		return
+	}
+
+	getRevisionQuery := `SELECT data FROM revision WHERE entity = $1 AND entity_id = $2 AND revision = $3;`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	var fromData, toData []byte
+
+	err = db.QueryRowContext(ctx, getRevisionQuery, entity, entityID, from).Scan(&fromData)
+	if err == nil {
+		err = db.QueryRowContext(ctx, getRevisionQuery, entity, entityID, to).Scan(&toData)
+	}
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("DiffRevisions QueryRowContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	changes, err := diffRevisions(fromData, toData)
+	if err != nil {
+		log.Println("DiffRevisions error decoding revision: ", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(model.RevisionDiff{From: from, To: to, Changes: changes})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// restoreRevision записывает в запись значения из выбранной ревизии и сохраняет результат как новую ревизию
+func restoreRevision(w http.ResponseWriter, r *http.Request, entity string) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeAdmin(w, r, "restore "+entity+" revisions")
+	if !ok {
+		return
+	}
+
+	entityID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || entityID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := strconv.Atoi(r.PathValue("revision"))
+	if err != nil || revision < 1 {
+		http.Error(w, "revision must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	before, err := snapshot(ctx, tx, entity, entityID)
+
+	// Восстановление ревизии переписывает запись, как изменение, поэтому тоже сверяется с If-Match
+	if err == nil {
+		noun := "Actor"
+		if entity == auditEntityMovie {
+			noun = "Movie"
+		}
+		err = preconditionFailed(checkIfMatch(ctx, tx, entity, entityID, r.Header.Get("If-Match")), noun)
+	}
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.ExecContext(ctx, restoreRevisionQueries[entity], entityID, revision)
+	}
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = recordRevision(ctx, tx, issuer, entity, entityID, before)
+	}
+
+	if err == nil && rowsAffected > 0 {
+		err = auditChange(ctx, tx, issuer, "restore_"+entity+"_revision", entity, entityID, before)
+	}
+
+	if err != nil || rowsAffected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("RestoreRevision Failed to rollback transaction: %v\n", rollbackErr)
+		} else {
+			log.Println("RestoreRevision transaction rollback")
+		}
+
+		if err == nil {
+			log.Println("RestoreRevision ", entity, " or revision doesn't exist, no rows affected")
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println("RestoreRevision ", errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("RestoreRevision ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		var errPQ *pq.Error
+		if errors.As(err, &errPQ) {
+			if errPQ.Code == "23505" {
+				log.Println("RestoreRevision ", entity, " already exists: ", errPQ)
+				http.Error(w, "Record with the same data already exists", http.StatusBadRequest)
+				return
+			}
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("RestoreRevision error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	invalidateSuggestions()
+
+	resp, err := json.Marshal("Revision restored successfully")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
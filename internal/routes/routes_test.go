@@ -2,18 +2,27 @@ package routes
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/BukhryakovVladimir/vkTest/internal/model"
+	"github.com/BukhryakovVladimir/vkTest/internal/postgres"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/lib/pq"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -38,6 +47,10 @@ func setupTestDB() *sql.DB {
 		log.Fatalf("Error starting server: %v", err)
 	}
 
+	if _, err = postgres.MigrateUp(db); err != nil {
+		log.Fatalf("Error applying migrations: %v", err)
+	}
+
 	//queryTimeLimit = 5
 	//secretKey = "filmoteka_test"
 	//jwtName = "filmoteka_test_jwt"
@@ -1701,6 +1714,158 @@ func TestGetActorsWithID_MultipleFieldsInputData(t *testing.T) {
 	}
 }
 
+// Builds an AND query by default and an OR query when matchAny is set
+func TestBuildSearchActorQuery_CombinesConditions(t *testing.T) {
+	limit := 10
+	search := model.SearchActor{
+		Name:  "Al Pacno",
+		Sex:   "Male",
+		Limit: &limit,
+	}
+
+	query, args := buildSearchActorQuery(search)
+
+	if !strings.Contains(query, "% f_unaccent(lower($1)) OR f_unaccent(lower($1)) <%") {
+		t.Errorf("Expected trigram condition on full name, got %s", query)
+	}
+	if !strings.Contains(query, ") AND lower(sex) = lower($2)") {
+		t.Errorf("Expected conditions combined with AND, got %s", query)
+	}
+	if !strings.Contains(query, "LIMIT $3") {
+		t.Errorf("Expected limit as third parameter, got %s", query)
+	}
+	if len(args) != 3 {
+		t.Fatalf("Expected 3 arguments, got %d", len(args))
+	}
+
+	search.MatchAny = true
+	query, _ = buildSearchActorQuery(search)
+
+	if !strings.Contains(query, ") OR lower(sex) = lower($2)") {
+		t.Errorf("Expected conditions combined with OR, got %s", query)
+	}
+}
+
+// Finds an actor by full name with a typo and different case
+func TestSearchActor_FuzzyName(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	_, err := db.Exec(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+		VALUES ('Zoë', 'Kravitzová', 'Female', '1988-12-01') ON CONFLICT DO NOTHING`)
+	if err != nil {
+		t.Fatalf("Failed to set up test data: %v", err)
+	}
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	requestBody, _ := json.Marshal(model.SearchActor{Name: "zoe kravitzova"})
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(requestBody))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Call the SearchActor function directly
+	SearchActor(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var actors []model.ActorSearchResult
+	err = json.NewDecoder(w.Body).Decode(&actors)
+	if err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(actors) == 0 || actors[0].LastName != "Kravitzová" {
+		t.Errorf("Expected Kravitzová to be the best match, got %v", actors)
+	}
+}
+
+// Returns bad request when no search field is set
+func TestSearchActor_EmptySearch(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Call the SearchActor function directly
+	SearchActor(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Returns bad request when limit is explicitly set to zero
+func TestSearchActor_ZeroLimit(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "al pacino", "limit": 0}`))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Call the SearchActor function directly
+	SearchActor(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
 // Delete an actor successfully with valid JWT and non-admin privileges
 func TestDeleteActor_ValidJWT_NonAdminPrivileges(t *testing.T) {
 	db = setupTestDB()
@@ -2838,6 +3003,51 @@ func TestGetMoviesOrdered_ReturnsUnauthorizedIfJWTCookieMissing(t *testing.T) {
 	}
 }
 
+// Handlers with a request body check the JWT cookie before reading and validating the body
+func TestBodyHandlers_ReturnUnauthorizedBeforeReadingBody(t *testing.T) {
+	// Set up test environment
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	handlers := map[string]http.HandlerFunc{
+		"LinkActor":        LinkActor,
+		"UpdateCredit":     UpdateCredit,
+		"SetMovieCast":     SetMovieCast,
+		"AddCrew":          AddCrew,
+		"RemoveCrew":       RemoveCrew,
+		"AddGenre":         AddGenre,
+		"UpdateTag":        UpdateTag,
+		"AttachTag":        AttachTag,
+		"RateMovie":        RateMovie,
+		"AddReview":        AddReview,
+		"UpdateReview":     UpdateReview,
+		"ReportReview":     ReportReview,
+		"ModerateReview":   ModerateReview,
+		"MarkWatched":      MarkWatched,
+		"AddMovieList":     AddMovieList,
+		"UpdateMovieList":  UpdateMovieList,
+		"AddMovieToList":   AddMovieToList,
+		"ReorderMovieList": ReorderMovieList,
+	}
+
+	for name, handler := range handlers {
+		// A malformed body must not be reported before the missing cookie
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{"))
+		r.SetPathValue("id", "1")
+
+		handler(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status code %d, got %d", name, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
+
 // Function returns expected search results when valid search parameters are provided
 func TestSearchMovie_ValidSearchParameters(t *testing.T) {
 	// Set up test environment
@@ -2918,9 +3128,72 @@ func TestSearchMovie_InvalidJWTToken(t *testing.T) {
 	// ...
 }
 
-// Function is called with valid admin JWT and valid movie ID, movie is deleted successfully
-func TestDeleteMovie_ValidAdminJWT_ValidMovieID_MovieDeletedSuccessfully(t *testing.T) {
-	// Set up test environment
+// Compiles nested and/or/not groups into parameterized SQL
+func TestCompileMovieFilter_NestedGroups(t *testing.T) {
+	filter := model.MovieFilter{
+		And: []model.MovieFilter{
+			{Field: "rating", Op: "gte", Value: json.RawMessage(`7`)},
+			{Field: "date", Op: "between", Value: json.RawMessage(`["1990-01-01", "2000-12-31"]`)},
+			{Or: []model.MovieFilter{
+				{Field: "actorID", Op: "in", Value: json.RawMessage(`[1, 2, 2]`)},
+				{Not: &model.MovieFilter{Field: "name", Op: "contains", Value: json.RawMessage(`"100%"`)}},
+			}},
+		},
+	}
+
+	condition, args, err := compileMovieFilter(&filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(condition, "(m.rating >= $1 AND m.date BETWEEN $2 AND $3 AND (EXISTS") {
+		t.Errorf("Unexpected condition: %s", condition)
+	}
+	if !strings.Contains(condition, "ANY($4::integer[])) OR NOT (m.name ILIKE '%' || $5 || '%')))") {
+		t.Errorf("Unexpected condition: %s", condition)
+	}
+	if len(args) != 5 {
+		t.Fatalf("Expected 5 arguments, got %d", len(args))
+	}
+	if args[4] != `100\%` {
+		t.Errorf("Expected escaped LIKE pattern, got %v", args[4])
+	}
+}
+
+// Rejects fields and operators that are not in the allowlist
+func TestCompileMovieFilter_RejectsUnknownFieldsAndOperators(t *testing.T) {
+	filters := []model.MovieFilter{
+		{Field: "id; DROP TABLE movie", Op: "eq", Value: json.RawMessage(`1`)},
+		{Field: "rating", Op: "contains", Value: json.RawMessage(`"7"`)},
+		{Field: "rating", Op: "eq", Value: json.RawMessage(`"seven"`)},
+		{Field: "date", Op: "between", Value: json.RawMessage(`["1990-01-01"]`)},
+		{Field: "actorID", Op: "in", Value: json.RawMessage(`[]`)},
+		{And: []model.MovieFilter{}},
+		{Field: "name", Op: "eq", Value: json.RawMessage(`"x"`), Or: []model.MovieFilter{}},
+	}
+
+	for _, filter := range filters {
+		if _, _, err := compileMovieFilter(&filter); err == nil {
+			t.Errorf("Expected error for filter %+v, got nil", filter)
+		}
+	}
+}
+
+// Rejects filters nested deeper than the limit
+func TestCompileMovieFilter_DepthLimit(t *testing.T) {
+	filter := model.MovieFilter{Field: "rating", Op: "eq", Value: json.RawMessage(`5`)}
+	for i := 0; i < maxFilterDepth; i++ {
+		inner := filter
+		filter = model.MovieFilter{Not: &inner}
+	}
+
+	if _, _, err := compileMovieFilter(&filter); err == nil {
+		t.Error("Expected error for too deeply nested filter, got nil")
+	}
+}
+
+// Returns movies matching a rating range filter
+func TestFilterMovies_RatingRange(t *testing.T) {
 	db = setupTestDB()
 	defer db.Close()
 
@@ -2935,49 +3208,40 @@ func TestDeleteMovie_ValidAdminJWT_ValidMovieID_MovieDeletedSuccessfully(t *test
 	claims["exp"] = time.Now().Add(time.Hour).Unix()
 	signedToken, _ := token.SignedString([]byte(secretKey))
 
+	requestBody := `{"filter": {"field": "rating", "op": "between", "value": [7, 10]}, "by": "date", "order": "asc"}`
+
 	// Create a new request with the JWT token in the cookie
 	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 	cookie := &http.Cookie{
 		Name:  jwtName,
 		Value: signedToken,
 	}
 	r.AddCookie(cookie)
 
-	// Create a new movie with valid ID
-	movie := model.Movie{
-		ID:          1,
-		Name:        "Test Movie",
-		Description: "Test Description",
-		Date:        time.Now(),
-		Rating:      5,
-		Actors:      []model.Actor{},
-	}
-	requestBody, _ := json.Marshal(movie)
-	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
-
-	// Call the DeleteMovie function directly
-	DeleteMovie(w, r)
+	// Call the FilterMovies function directly
+	FilterMovies(w, r)
 
 	// Check response status code
-	if w.Code != http.StatusCreated {
-		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
 	}
 
-	// Check response body
-	var response string
-	err := json.NewDecoder(w.Body).Decode(&response)
+	var movies []model.Movie
+	err := json.NewDecoder(w.Body).Decode(&movies)
 	if err != nil {
 		t.Fatalf("Failed to decode response body: %v", err)
 	}
 
-	// Perform assertions on the response
-	// ...
+	for _, movie := range movies {
+		if movie.Rating < 7 {
+			t.Errorf("Expected rating >= 7, got %d for movie %q", movie.Rating, movie.Name)
+		}
+	}
 }
 
-// Function is called with valid admin JWT and invalid movie ID, function returns http.StatusBadRequest
-func TestDeleteMovie_ValidAdminJWT_InvalidMovieID_ReturnsBadRequest(t *testing.T) {
-	// Set up test environment
+// Returns bad request for a field outside of the allowlist
+func TestFilterMovies_UnknownField(t *testing.T) {
 	db = setupTestDB()
 	defer db.Close()
 
@@ -2992,41 +3256,28 @@ func TestDeleteMovie_ValidAdminJWT_InvalidMovieID_ReturnsBadRequest(t *testing.T
 	claims["exp"] = time.Now().Add(time.Hour).Unix()
 	signedToken, _ := token.SignedString([]byte(secretKey))
 
+	requestBody := `{"filter": {"field": "password", "op": "eq", "value": "x"}}`
+
 	// Create a new request with the JWT token in the cookie
 	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 	cookie := &http.Cookie{
 		Name:  jwtName,
 		Value: signedToken,
 	}
 	r.AddCookie(cookie)
 
-	// Create a new movie with invalid ID
-	movie := model.Movie{
-		ID:          0,
-		Name:        "Test Movie",
-		Description: "Test Description",
-		Date:        time.Now(),
-		Rating:      5,
-		Actors:      []model.Actor{},
-	}
-	requestBody, _ := json.Marshal(movie)
-	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
-
-	// Call the DeleteMovie function directly
-	DeleteMovie(w, r)
+	// Call the FilterMovies function directly
+	FilterMovies(w, r)
 
 	// Check response status code
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
 	}
-
-	// Perform assertions on the response
-	// ...
 }
 
-// Function is called with valid admin JWT and movie ID that doesn't exist, function returns http.StatusBadRequest
-func TestDeleteMovie_ValidAdminJWT_NonexistentMovieID_ReturnsBadRequest(t *testing.T) {
+// Returns results together with facets when facets=true is set
+func TestSearchMovie_WithFacets(t *testing.T) {
 	// Set up test environment
 	db = setupTestDB()
 	defer db.Close()
@@ -3042,37 +3293,3620 @@ func TestDeleteMovie_ValidAdminJWT_NonexistentMovieID_ReturnsBadRequest(t *testi
 	claims["exp"] = time.Now().Add(time.Hour).Unix()
 	signedToken, _ := token.SignedString([]byte(secretKey))
 
+	requestBody, _ := json.Marshal(model.SearchMovie{ActorFirstName: "Robert"})
+
 	// Create a new request with the JWT token in the cookie
 	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	r := httptest.NewRequest(http.MethodPost, "/?facets=true", bytes.NewReader(requestBody))
 	cookie := &http.Cookie{
 		Name:  jwtName,
 		Value: signedToken,
 	}
 	r.AddCookie(cookie)
 
-	// Create a new movie with nonexistent ID
-	movie := model.Movie{
-		ID:          999,
-		Name:        "Test Movie",
-		Description: "Test Description",
-		Date:        time.Now(),
-		Rating:      5,
-		Actors:      []model.Actor{},
+	// Call the SearchMovie function directly
+	SearchMovie(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
 	}
-	requestBody, _ := json.Marshal(movie)
-	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
 
-	// Call the DeleteMovie function directly
-	DeleteMovie(w, r)
+	var result model.MovieSearchResult
+	err := json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
 
-	// Check response status code
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	if result.Facets == nil {
+		t.Fatal("Expected facets in response, got nil")
 	}
 
-	// Perform assertions on the response
-	// ...
+	// Every matching movie is counted exactly once per facet
+	decades := 0
+	for _, facet := range result.Facets.Decades {
+		decades += facet.Count
+	}
+	ratings := 0
+	for _, facet := range result.Facets.Ratings {
+		ratings += facet.Count
+	}
+	if decades != ratings {
+		t.Errorf("Expected decade and rating facets to cover the same movies, got %d and %d", decades, ratings)
+	}
+}
+
+// Facets of a filter cover all matching movies, not only the requested page
+func TestFilterMovies_FacetsIgnorePagination(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM movie`).Scan(&total)
+	if err != nil {
+		t.Fatalf("Failed to count movies: %v", err)
+	}
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/?facets=true", strings.NewReader(`{"limit": 1}`))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Call the FilterMovies function directly
+	FilterMovies(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var result model.MovieSearchResult
+	err = json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(result.Movies) > 1 {
+		t.Errorf("Expected at most 1 movie, got %d", len(result.Movies))
+	}
+
+	counted := 0
+	for _, facet := range result.Facets.Ratings {
+		counted += facet.Count
+	}
+	if counted != total {
+		t.Errorf("Expected rating facets to count %d movies, got %d", total, counted)
+	}
+}
+
+// Function is called with valid admin JWT and valid movie ID, movie is deleted successfully
+func TestDeleteMovie_ValidAdminJWT_ValidMovieID_MovieDeletedSuccessfully(t *testing.T) {
+	// Set up test environment
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Create a new movie with valid ID
+	movie := model.Movie{
+		ID:          1,
+		Name:        "Test Movie",
+		Description: "Test Description",
+		Date:        time.Now(),
+		Rating:      5,
+		Actors:      []model.Actor{},
+	}
+	requestBody, _ := json.Marshal(movie)
+	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+
+	// Call the DeleteMovie function directly
+	DeleteMovie(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	// Check response body
+	var response string
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	// Perform assertions on the response
+	// ...
+}
+
+// Function is called with valid admin JWT and invalid movie ID, function returns http.StatusBadRequest
+func TestDeleteMovie_ValidAdminJWT_InvalidMovieID_ReturnsBadRequest(t *testing.T) {
+	// Set up test environment
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Create a new movie with invalid ID
+	movie := model.Movie{
+		ID:          0,
+		Name:        "Test Movie",
+		Description: "Test Description",
+		Date:        time.Now(),
+		Rating:      5,
+		Actors:      []model.Actor{},
+	}
+	requestBody, _ := json.Marshal(movie)
+	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+
+	// Call the DeleteMovie function directly
+	DeleteMovie(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Perform assertions on the response
+	// ...
+}
+
+// Function is called with valid admin JWT and movie ID that doesn't exist, function returns http.StatusBadRequest
+func TestDeleteMovie_ValidAdminJWT_NonexistentMovieID_ReturnsBadRequest(t *testing.T) {
+	// Set up test environment
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Create a new movie with nonexistent ID
+	movie := model.Movie{
+		ID:          999,
+		Name:        "Test Movie",
+		Description: "Test Description",
+		Date:        time.Now(),
+		Rating:      5,
+		Actors:      []model.Actor{},
+	}
+	requestBody, _ := json.Marshal(movie)
+	r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+
+	// Call the DeleteMovie function directly
+	DeleteMovie(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Perform assertions on the response
+	// ...
+}
+
+// Deleted movie disappears from reads and comes back after restore
+func TestDeleteMovie_SoftDeleteAndRestore(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Soft Delete Test', 'Test Description', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	// Delete the movie
+	w := httptest.NewRecorder()
+	requestBody, _ := json.Marshal(model.Movie{ID: movieID})
+	r := httptest.NewRequest(http.MethodDelete, "/", bytes.NewReader(requestBody))
+	r.AddCookie(cookie)
+
+	DeleteMovie(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	var deletedAt sql.NullTime
+	err = db.QueryRow("SELECT deleted_at FROM movie WHERE id = $1", movieID).Scan(&deletedAt)
+	if err != nil {
+		t.Fatalf("Movie row should be kept after soft delete: %v", err)
+	}
+	if !deletedAt.Valid {
+		t.Errorf("Expected deleted_at to be set")
+	}
+
+	// Deleting the same movie again reports that it doesn't exist
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodDelete, "/", bytes.NewReader(requestBody))
+	r.AddCookie(cookie)
+
+	DeleteMovie(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Deleted movie is listed for administrators
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+
+	GetDeletedItems(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var items model.DeletedItems
+	err = json.NewDecoder(w.Body).Decode(&items)
+	if err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	found := false
+	for _, movie := range items.Movies {
+		if movie.ID == movieID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected movie %d in deleted items", movieID)
+	}
+
+	// Restore the movie
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+	r.AddCookie(cookie)
+
+	RestoreMovie(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	err = db.QueryRow("SELECT deleted_at FROM movie WHERE id = $1", movieID).Scan(&deletedAt)
+	if err != nil {
+		t.Fatalf("Failed to query movie: %v", err)
+	}
+	if deletedAt.Valid {
+		t.Errorf("Expected deleted_at to be cleared after restore")
+	}
+}
+
+// Purge removes only items deleted before the retention period together with their relations
+func TestPurgeDeleted_RemovesExpiredItems(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+
+	var expiredID, recentID, actorID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating, deleted_at)
+		VALUES ('Purge Expired', '', '2001-02-03', 5, now() - interval '10 days') RETURNING id`).Scan(&expiredID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating, deleted_at)
+		VALUES ('Purge Recent', '', '2001-02-03', 5, now()) RETURNING id`).Scan(&recentID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE id = $1", recentID)
+	err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+		VALUES ('Purge', 'Actor', 'Male', '1970-01-01') RETURNING id`).Scan(&actorID)
+	if err != nil {
+		t.Fatalf("Failed to insert actor: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	_, err = db.Exec(`INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2)`, actorID, expiredID)
+	if err != nil {
+		t.Fatalf("Failed to link actor to movie: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	movies, _, err := purgeDeleted(ctx, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if movies < 1 {
+		t.Errorf("Expected at least one purged movie, got %d", movies)
+	}
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM movie WHERE id IN ($1, $2)`, expiredID, recentID).Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected only the recently deleted movie to remain, got %d movies", count)
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM actormovie WHERE movie_id = $1`, expiredID).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected relations of purged movie to be removed, got %d", count)
+	}
+}
+
+// Builds a filtered audit log query with limit and offset as the last parameters
+func TestBuildAuditLogQuery_AppliesFilters(t *testing.T) {
+	filter, err := parseAuditFilter(url.Values{
+		"entity":   {"movie"},
+		"entityID": {"7"},
+		"from":     {"2024-01-01T00:00:00Z"},
+		"limit":    {"10"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	query, args := buildAuditLogQuery(filter)
+
+	if !strings.Contains(query, "WHERE TRUE AND entity = $1 AND entity_id = $2 AND created_at >= $3") {
+		t.Errorf("Unexpected query: %s", query)
+	}
+	if !strings.Contains(query, "LIMIT $4 OFFSET $5") {
+		t.Errorf("Expected limit and offset as last parameters, got %s", query)
+	}
+	if len(args) != 5 || args[3] != 10 || args[4] != 0 {
+		t.Errorf("Unexpected arguments: %v", args)
+	}
+
+	for _, values := range []url.Values{
+		{"entity": {"person"}},
+		{"entityID": {"abc"}},
+		{"from": {"yesterday"}},
+		{"limit": {"0"}},
+	} {
+		if _, err := parseAuditFilter(values); err == nil {
+			t.Errorf("Expected error for %v, got nil", values)
+		}
+	}
+}
+
+// Updating a movie writes an audit entry with before and after snapshots in the same transaction
+func TestUpdateMovie_WritesAuditLog(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Audit Test', 'Before', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	w := httptest.NewRecorder()
+	requestBody, _ := json.Marshal(model.Movie{ID: movieID, Description: "After", Rating: 8})
+	r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+	r.AddCookie(cookie)
+
+	UpdateMovie(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	// Query the audit log for the movie
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?entity=movie&entityID=%d&action=update_movie", movieID), nil)
+	r.AddCookie(cookie)
+
+	GetAuditLog(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var entries []model.AuditEntry
+	err = json.NewDecoder(w.Body).Decode(&entries)
+	if err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %d", len(entries))
+	}
+	if entries[0].PersonID != 1 {
+		t.Errorf("Expected personID 1, got %d", entries[0].PersonID)
+	}
+
+	// Snapshots store dates as YYYY-MM-DD, so only the changed fields are decoded
+	var before, after struct {
+		Description string `json:"description"`
+		Rating      int    `json:"rating"`
+	}
+	if err := json.Unmarshal(entries[0].Before, &before); err != nil {
+		t.Fatalf("Failed to decode before snapshot: %v", err)
+	}
+	if err := json.Unmarshal(entries[0].After, &after); err != nil {
+		t.Fatalf("Failed to decode after snapshot: %v", err)
+	}
+	if before.Description != "Before" || after.Description != "After" || after.Rating != 8 {
+		t.Errorf("Unexpected snapshots: before %+v, after %+v", before, after)
+	}
+
+	// The cast is stored once, in credits
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entries[0].After, &fields); err != nil {
+		t.Fatalf("Failed to decode after snapshot: %v", err)
+	}
+	if _, ok := fields["actorIDs"]; ok {
+		t.Error("Expected snapshot without actorIDs")
+	}
+	if _, ok := fields["credits"]; !ok {
+		t.Error("Expected snapshot with credits")
+	}
+
+	// Audit entries cannot be changed
+	_, err = db.Exec("DELETE FROM audit_log WHERE id = $1", entries[0].ID)
+	if err == nil {
+		t.Error("Expected error when deleting from audit_log, got nil")
+	}
+}
+
+func TestDiffRevisions_ReportsChangedFields(t *testing.T) {
+	from := json.RawMessage(`{"name": "Old", "rating": 5, "date": "2001-02-03"}`)
+	to := json.RawMessage(`{"name": "New", "rating": 5, "description": "Added"}`)
+
+	changes, err := diffRevisions(from, to)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []model.RevisionChange{
+		{Field: "date", From: json.RawMessage(`"2001-02-03"`), To: json.RawMessage("null")},
+		{Field: "description", From: json.RawMessage("null"), To: json.RawMessage(`"Added"`)},
+		{Field: "name", From: json.RawMessage(`"Old"`), To: json.RawMessage(`"New"`)},
+	}
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %+v, got %+v", expected, changes)
+	}
+}
+
+func TestUpdateMovie_RecordsRevisionsAndRestores(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Revision Test', 'First', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM revision WHERE entity = 'movie' AND entity_id = $1", movieID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	w := httptest.NewRecorder()
+	requestBody, _ := json.Marshal(model.Movie{ID: movieID, Description: "Second", Rating: 8})
+	r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+	r.AddCookie(cookie)
+
+	UpdateMovie(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	// The first update stores the original state as revision 1 and the new state as revision 2
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.AddCookie(cookie)
+
+	GetMovieRevisions(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var revisions []model.Revision
+	if err := json.NewDecoder(w.Body).Decode(&revisions); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].PersonID != nil || revisions[1].PersonID == nil {
+		t.Fatalf("Unexpected revisions: %+v", revisions)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/?from=1&to=2", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.AddCookie(cookie)
+
+	DiffMovieRevisions(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var diff model.RevisionDiff
+	if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(diff.Changes) != 2 || diff.Changes[0].Field != "description" || diff.Changes[1].Field != "rating" {
+		t.Errorf("Unexpected diff: %+v", diff)
+	}
+
+	// Restoring revision 1 brings back the original values as revision 3
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.SetPathValue("revision", "1")
+	r.AddCookie(cookie)
+
+	RestoreMovieRevision(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	var description string
+	var rating, lastRevision int
+	err = db.QueryRow("SELECT description, rating FROM movie WHERE id = $1", movieID).Scan(&description, &rating)
+	if err != nil {
+		t.Fatalf("Failed to query movie: %v", err)
+	}
+	if description != "First" || rating != 5 {
+		t.Errorf("Expected restored values First/5, got %s/%d", description, rating)
+	}
+
+	err = db.QueryRow("SELECT MAX(revision) FROM revision WHERE entity = 'movie' AND entity_id = $1", movieID).Scan(&lastRevision)
+	if err != nil {
+		t.Fatalf("Failed to query revisions: %v", err)
+	}
+	if lastRevision != 3 {
+		t.Errorf("Expected last revision 3, got %d", lastRevision)
+	}
+
+	// Restoring with a stale ETag does not overwrite the record
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.SetPathValue("revision", "2")
+	r.Header.Set("If-Match", formatETag(1))
+	r.AddCookie(cookie)
+
+	RestoreMovieRevision(w, r)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d, got %d", http.StatusPreconditionFailed, w.Code)
+	}
+
+	// Unknown revision
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.SetPathValue("revision", "42")
+	r.AddCookie(cookie)
+
+	RestoreMovieRevision(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// Restoring a revision recorded before movie metadata existed keeps the current metadata
+func TestRestoreMovieRevision_WithoutMetadataKeys(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating, runtime, countries,
+		original_language, original_title, age_certification)
+		VALUES ('Metadata Revision Test', 'Current', '2001-02-03', 7, 136, '{US}', 'en', 'The Matrix', '16+')
+		RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM revision WHERE entity = 'movie' AND entity_id = $1", movieID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	// An old revision only has the fields that existed before the metadata migration
+	_, err = db.Exec(`INSERT INTO revision (entity, entity_id, revision, data)
+		VALUES ('movie', $1, 1, '{"name": "Metadata Revision Test", "description": "Old", "date": "2001-02-03", "rating": 5}')`,
+		movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert revision: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.SetPathValue("revision", "1")
+	r.AddCookie(cookie)
+
+	RestoreMovieRevision(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var description, countries, language, title, certification string
+	var rating, runtime int
+	err = db.QueryRow(`SELECT description, rating, runtime, array_to_string(countries, ','),
+		original_language, original_title, age_certification FROM movie WHERE id = $1`, movieID).
+		Scan(&description, &rating, &runtime, &countries, &language, &title, &certification)
+	if err != nil {
+		t.Fatalf("Failed to query movie: %v", err)
+	}
+	if description != "Old" || rating != 5 {
+		t.Errorf("Expected restored values Old/5, got %s/%d", description, rating)
+	}
+	if runtime != 136 || countries != "US" || language != "en" || title != "The Matrix" || certification != "16+" {
+		t.Errorf("Expected metadata to be kept, got %d/%s/%s/%s/%s", runtime, countries, language, title, certification)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{header: "", weak: false, want: false},
+		{header: `"3"`, weak: false, want: true},
+		{header: `"1", "3"`, weak: false, want: true},
+		{header: `"4"`, weak: false, want: false},
+		{header: "*", weak: false, want: true},
+		{header: `W/"3"`, weak: false, want: false},
+		{header: `W/"3"`, weak: true, want: true},
+	}
+
+	for _, test := range tests {
+		if got := etagMatches(test.header, formatETag(3), test.weak); got != test.want {
+			t.Errorf("etagMatches(%q, weak=%t) = %t, want %t", test.header, test.weak, got, test.want)
+		}
+	}
+}
+
+// State ETags differ by state but match If-Match by version only
+func TestFormatStateETag(t *testing.T) {
+	etag := formatStateETag(3, int16(8), model.RatingSummary{Average: 7.5, Count: 2})
+	if !strings.HasPrefix(etag, `"3-`) || etag == formatStateETag(3, int16(9), model.RatingSummary{Average: 7.5, Count: 2}) {
+		t.Errorf("Unexpected state ETag %s", etag)
+	}
+
+	if !etagMatches(versionETags(etag), formatETag(3), false) {
+		t.Errorf("Expected %s to match version 3", etag)
+	}
+	if etagMatches(versionETags(`"2-00000000", W/"3-00000000"`), formatETag(3), false) {
+		t.Error("Expected other versions and weak ETags not to match")
+	}
+}
+
+func TestUpdateMovie_IfMatchAndConditionalGet(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('ETag Test', 'First', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM revision WHERE entity = 'movie' AND entity_id = $1", movieID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	getMovie := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		r.AddCookie(cookie)
+
+		GetMovie(w, r)
+
+		return w
+	}
+
+	updateMovie := func(ifMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(model.Movie{ID: movieID, Description: "Second", Rating: 8})
+		r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+		r.Header.Set("If-Match", ifMatch)
+		r.AddCookie(cookie)
+
+		UpdateMovie(w, r)
+
+		return w
+	}
+
+	w := getMovie("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"1-`) {
+		t.Fatalf(`Expected ETag of version 1, got %s`, etag)
+	}
+
+	if w = getMovie(etag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotModified, w.Code)
+	}
+
+	if w = updateMovie(etag); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	// The second update with the same ETag is based on a stale version
+	if w = updateMovie(etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d, got %d", http.StatusPreconditionFailed, w.Code)
+	}
+
+	w = getMovie(etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("ETag"), `"2-`) {
+		t.Errorf(`Expected ETag of version 2, got %s`, w.Header().Get("ETag"))
+	}
+}
+
+func TestNewMovieReader_ParsesCSVRows(t *testing.T) {
+	input := "name,date,rating,actors\n" +
+		"Ronin,1998-09-25,7,Robert;De Niro;Male;1943-08-17|Jean;Reno;Male;1948-07-30\n" +
+		"Heat,1995-12-15,eight,\n" +
+		"Leon,1994-09-14\n"
+
+	reader, err := newMovieReader(strings.NewReader(input), importFormatCSV)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var rows []importRow
+	for {
+		row, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+
+	if rows[0].Err != nil || rows[0].Movie.Name != "Ronin" || rows[0].Movie.Rating != 7 || len(rows[0].Movie.Actors) != 2 {
+		t.Errorf("Unexpected first row: %+v", rows[0])
+	}
+	if rows[0].Movie.Actors[1].LastName != "Reno" || rows[0].Movie.Actors[1].BirthDate.Year() != 1948 {
+		t.Errorf("Unexpected actor: %+v", rows[0].Movie.Actors[1])
+	}
+
+	// Invalid rating and wrong number of fields fail only their own rows
+	if rows[1].Err == nil || rows[1].Row != 2 {
+		t.Errorf("Expected error for row 2, got %+v", rows[1])
+	}
+	if rows[2].Err == nil || rows[2].Row != 3 {
+		t.Errorf("Expected error for row 3, got %+v", rows[2])
+	}
+}
+
+// Metadata columns use the movies export names in CSV and nested externalIDs in NDJSON
+func TestNewMovieReader_ParsesMetadata(t *testing.T) {
+	input := "name,date,runtime,countries,originalLanguage,ageCertification,imdbID,tmdbID\n" +
+		"Heat,1995-12-15,170,US|GB,en,16+,tt0113277,\n"
+
+	reader, err := newMovieReader(strings.NewReader(input), importFormatCSV)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	row, err := reader.next()
+	if err != nil || row.Err != nil {
+		t.Fatalf("Unexpected error: %v %v", err, row.Err)
+	}
+
+	movie := row.Movie
+	if movie.Runtime != 170 || !reflect.DeepEqual(movie.Countries, []string{"US", "GB"}) || movie.OriginalLanguage != "en" ||
+		movie.AgeCertification != "16+" || movie.ExternalIDs == nil || movie.ExternalIDs.IMDb != "tt0113277" {
+		t.Errorf("Unexpected movie: %+v", movie)
+	}
+	if !row.Fields["tmdbID"] || row.Fields["kinopoiskID"] {
+		t.Errorf("Unexpected fields: %v", row.Fields)
+	}
+
+	reader, _ = newMovieReader(strings.NewReader(`{"name": "Heat", "externalIDs": {"imdb": ""}}`), importFormatNDJSON)
+	row, err = reader.next()
+	if err != nil || row.Err != nil {
+		t.Fatalf("Unexpected error: %v %v", err, row.Err)
+	}
+	if !row.Fields["imdbID"] || row.Fields["tmdbID"] || row.Fields["runtime"] {
+		t.Errorf("Unexpected fields: %v", row.Fields)
+	}
+}
+
+func TestNewMovieReader_RejectsUnknownCSVColumn(t *testing.T) {
+	_, err := newMovieReader(strings.NewReader("name,director\nRonin,Frankenheimer\n"), importFormatCSV)
+	if err == nil {
+		t.Error("Expected error for unknown column, got nil")
+	}
+}
+
+// Only problems found before any row is processed reject the file as invalid
+func TestImportCatalog_InvalidFileAndReadErrors(t *testing.T) {
+	invalid := []struct {
+		input string
+		opts  ImportOptions
+	}{
+		{input: "name,date\n", opts: ImportOptions{Format: importFormatCSV, BatchSize: 0}},
+		{input: "name,director\n", opts: ImportOptions{Format: importFormatCSV, BatchSize: 10}},
+		{input: "", opts: ImportOptions{Format: "xml", BatchSize: 10}},
+	}
+	for _, test := range invalid {
+		_, err := ImportCatalog(context.Background(), strings.NewReader(test.input), test.opts, "1")
+		if !errors.Is(err, errInvalidImportFile) {
+			t.Errorf("Expected invalid import file error for %q, got %v", test.input, err)
+		}
+	}
+
+	readErr := errors.New("connection reset")
+	input := io.MultiReader(strings.NewReader("name,date\n"), iotest.ErrReader(readErr))
+	report, err := ImportCatalog(context.Background(), input, ImportOptions{Format: importFormatCSV, BatchSize: 10}, "1")
+	if !errors.Is(err, readErr) || errors.Is(err, errInvalidImportFile) {
+		t.Errorf("Expected read error, got %v", err)
+	}
+	if !strings.Contains(report.Error, "connection reset") || len(report.Rows) != 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestNewMovieReader_RequiresDateColumn(t *testing.T) {
+	_, err := newMovieReader(strings.NewReader("name,rating\nRonin,7\n"), importFormatCSV)
+	if err == nil {
+		t.Error("Expected error for CSV without date column, got nil")
+	}
+}
+
+// Only the fields present in the row are updated
+func TestBuildImportUpdateQuery_UsesPresentFields(t *testing.T) {
+	movie := model.Movie{Description: "Heist", Rating: 8}
+
+	query, args := buildImportUpdateQuery(12, movie, map[string]bool{"name": true, "date": true})
+	if query != "" || args != nil {
+		t.Errorf("Expected no update without changeable fields, got %q %v", query, args)
+	}
+
+	query, args = buildImportUpdateQuery(12, movie, map[string]bool{"name": true, "rating": true})
+	if !strings.Contains(query, "SET rating = $2") || strings.Contains(query, "description") {
+		t.Errorf("Expected update of rating only, got %s", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{12, int16(8)}) {
+		t.Errorf("Unexpected arguments: %v", args)
+	}
+
+	// An empty metadata value clears the column
+	query, args = buildImportUpdateQuery(12, model.Movie{Runtime: 120}, map[string]bool{"runtime": true, "imdbID": true})
+	if !strings.Contains(query, "SET runtime = $2, imdb_id = $3") {
+		t.Errorf("Expected update of runtime and imdb_id, got %s", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{12, sql.NullInt64{Int64: 120, Valid: true}, sql.NullString{}}) {
+		t.Errorf("Unexpected arguments: %v", args)
+	}
+}
+
+// Re-importing a file without description and rating keeps them, rows without a date fail
+func TestImportCatalog_KeepsMissingFields(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+
+	var movieID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Import Keep Test', 'Kept', '2001-02-03', 6) RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+	defer db.Exec("DELETE FROM revision WHERE entity = 'movie' AND entity_id = $1", movieID)
+
+	input := "name,date\nImport Keep Test,2001-02-03\nImport Keep Test,\n"
+	report, err := ImportCatalog(context.Background(), strings.NewReader(input),
+		ImportOptions{Format: importFormatCSV, BatchSize: 10}, "1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Skipped != 1 || report.Failed != 1 || report.Created != 0 {
+		t.Errorf("Unexpected import report: %+v", report)
+	}
+
+	var description string
+	var rating int
+	err = db.QueryRow("SELECT description, rating FROM movie WHERE id = $1", movieID).Scan(&description, &rating)
+	if err != nil {
+		t.Fatalf("Failed to query movie: %v", err)
+	}
+	if description != "Kept" || rating != 6 {
+		t.Errorf("Expected description and rating to be kept, got %q and %d", description, rating)
+	}
+
+	report, err = ImportCatalog(context.Background(), strings.NewReader(`{"name": "Import Keep Test", "date": "2001-02-03T00:00:00Z", "rating": 9}`),
+		ImportOptions{Format: importFormatNDJSON, BatchSize: 10}, "1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Updated != 1 {
+		t.Errorf("Unexpected update import report: %+v", report)
+	}
+
+	err = db.QueryRow("SELECT description, rating FROM movie WHERE id = $1", movieID).Scan(&description, &rating)
+	if err != nil {
+		t.Fatalf("Failed to query movie: %v", err)
+	}
+	if description != "Kept" || rating != 9 {
+		t.Errorf("Expected only rating to change, got %q and %d", description, rating)
+	}
+}
+
+func TestImportCatalog_ReportsRowStatuses(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+
+	input := `{"name": "Import Test", "description": "First", "date": "2001-02-03T00:00:00Z", "rating": 5, "actors": [{"firstName": "Import", "lastName": "Actor", "sex": "Male", "birthDate": "1970-01-01T00:00:00Z"}]}
+{"name": "", "rating": 5}
+`
+	defer db.Exec("DELETE FROM actor WHERE firstName = 'Import' AND lastName = 'Actor'")
+	defer db.Exec("DELETE FROM movie WHERE name = 'Import Test'")
+	defer db.Exec(`DELETE FROM revision WHERE entity = 'movie'
+		AND entity_id IN (SELECT id FROM movie WHERE name = 'Import Test')`)
+
+	// Dry run validates rows without writing them
+	report, err := ImportCatalog(context.Background(), strings.NewReader(input),
+		ImportOptions{Format: importFormatNDJSON, DryRun: true, BatchSize: 10}, "1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Created != 1 || report.Failed != 1 {
+		t.Errorf("Unexpected dry run report: %+v", report)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM movie WHERE name = 'Import Test'").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query movies: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected dry run to write nothing, found %d movies", count)
+	}
+
+	report, err = ImportCatalog(context.Background(), strings.NewReader(input),
+		ImportOptions{Format: importFormatNDJSON, BatchSize: 10}, "1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Created != 1 || report.Failed != 1 || report.Rows[1].Row != 2 {
+		t.Errorf("Unexpected import report: %+v", report)
+	}
+
+	// Importing the same file again changes nothing
+	report, err = ImportCatalog(context.Background(), strings.NewReader(input),
+		ImportOptions{Format: importFormatNDJSON, BatchSize: 1}, "1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Skipped != 1 || report.Failed != 1 {
+		t.Errorf("Unexpected repeated import report: %+v", report)
+	}
+
+	// Changed fields update the existing movie
+	updated := strings.Replace(input, `"rating": 5, "actors"`, `"rating": 9, "actors"`, 1)
+	report, err = ImportCatalog(context.Background(), strings.NewReader(updated),
+		ImportOptions{Format: importFormatNDJSON, BatchSize: 10}, "1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Updated != 1 {
+		t.Errorf("Unexpected update import report: %+v", report)
+	}
+}
+
+func TestRestoreArchive_RejectsInvalidHeader(t *testing.T) {
+	tests := []string{
+		"",
+		`{"format": "other", "version": 1}`,
+		`{"format": "filmoteka-archive", "version": 99}`,
+	}
+
+	for _, input := range tests {
+		_, err := RestoreArchive(context.Background(), strings.NewReader(input), false)
+		if !errors.Is(err, errInvalidArchive) {
+			t.Errorf("Expected invalid archive error for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestWriteArchive_RestoresCatalogWithIDs(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	var movieID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating, runtime, countries, imdb_id)
+		VALUES ('Archive Test', 'Archived', '2001-02-03', 5, 95, '{FR}', 'tt0000042') RETURNING id`).Scan(&movieID)
+	if err != nil {
+		t.Fatalf("Failed to insert movie: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	var archive bytes.Buffer
+	exported, err := WriteArchive(context.Background(), &archive)
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	// A catalog that is not empty is only overwritten with replace
+	_, err = RestoreArchive(context.Background(), bytes.NewReader(archive.Bytes()), false)
+	if !errors.Is(err, errArchiveCatalogNotEmpty) {
+		t.Fatalf("Expected catalog not empty error, got %v", err)
+	}
+
+	restored, err := RestoreArchive(context.Background(), bytes.NewReader(archive.Bytes()), true)
+	if err != nil {
+		t.Fatalf("Failed to restore archive: %v", err)
+	}
+	if restored != exported {
+		t.Errorf("Expected restored counts %+v, got %+v", exported, restored)
+	}
+
+	var description, imdbID string
+	var runtime int
+	err = db.QueryRow("SELECT description, runtime, imdb_id FROM movie WHERE id = $1", movieID).Scan(&description, &runtime, &imdbID)
+	if err != nil {
+		t.Fatalf("Failed to query restored movie: %v", err)
+	}
+	if description != "Archived" || runtime != 95 || imdbID != "tt0000042" {
+		t.Errorf("Unexpected restored movie: %s, %d, %s", description, runtime, imdbID)
+	}
+
+	// Version 1 archives are still accepted
+	legacy := bytes.Replace(archive.Bytes(), []byte(`"version":2`), []byte(`"version":1`), 1)
+	if _, err := RestoreArchive(context.Background(), bytes.NewReader(legacy), true); err != nil {
+		t.Errorf("Failed to restore version 1 archive: %v", err)
+	}
+
+	// A truncated archive is rejected
+	truncated := archive.Bytes()[:bytes.LastIndex(archive.Bytes()[:archive.Len()-1], []byte("\n"))+1]
+	_, err = RestoreArchive(context.Background(), bytes.NewReader(truncated), true)
+	if !errors.Is(err, errInvalidArchive) {
+		t.Errorf("Expected invalid archive error, got %v", err)
+	}
+}
+
+// A stream that fails after the first item ends with an error record in NDJSON and stays unterminated in JSON
+func TestListStreamer_FailAfterStart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	tests := []struct {
+		accept string
+		body   string
+	}{
+		{accept: "application/json", body: `[{"id":1}` + "\n"},
+		{accept: "application/x-ndjson", body: `{"id":1}` + "\n" + `{"error":"Database query time limit exceeded"}` + "\n"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", test.accept)
+
+		stream := newListStreamer(w, r)
+		if err := stream.write(map[string]int{"id": 1}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		stream.fail(ctx, "TestListStreamer", ctx.Err())
+
+		if w.Code != http.StatusOK || w.Body.String() != test.body {
+			t.Errorf("Accept %q: unexpected response %d %q", test.accept, w.Code, w.Body.String())
+		}
+	}
+
+	// Before the first item the client gets an error status
+	w := httptest.NewRecorder()
+	newListStreamer(w, httptest.NewRequest(http.MethodGet, "/", nil)).fail(ctx, "TestListStreamer", ctx.Err())
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
+
+// Replacing the catalog keeps ratings and list items of movies that are in the archive
+func TestRestoreArchive_ReplaceKeepsUserData(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	var movieID, extraID, userID, listID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Archive User Data', '', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('archive_user', 'x', false) RETURNING id`).Scan(&userID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	var archive bytes.Buffer
+	if _, err := WriteArchive(context.Background(), &archive); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	// The extra movie is not in the archive, so its list item is dropped on restore
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Archive User Data Extra', '', '2001-02-04', 5) RETURNING id`).Scan(&extraID)
+	if err == nil {
+		_, err = db.Exec("INSERT INTO userrating (person_id, movie_id, score) VALUES ($1, $2, 8)", userID, movieID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movielist (person_id, name) VALUES ($1, 'Archive List') RETURNING id`,
+			userID).Scan(&listID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO movielistitem (list_id, movie_id, position) VALUES ($1, $2, 1), ($1, $3, 2)",
+			listID, extraID, movieID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert user data: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE id = $1", extraID)
+
+	if _, err := RestoreArchive(context.Background(), bytes.NewReader(archive.Bytes()), true); err != nil {
+		t.Fatalf("Failed to restore archive: %v", err)
+	}
+
+	var score, ratingCount int
+	err = db.QueryRow("SELECT score FROM userrating WHERE person_id = $1 AND movie_id = $2", userID, movieID).Scan(&score)
+	if err == nil {
+		err = db.QueryRow("SELECT rating_count FROM movie_rating_stats WHERE movie_id = $1", movieID).Scan(&ratingCount)
+	}
+	if err != nil {
+		t.Fatalf("Failed to query restored rating: %v", err)
+	}
+	if score != 8 || ratingCount != 1 {
+		t.Errorf("Expected rating 8 counted once, got score %d and count %d", score, ratingCount)
+	}
+
+	var items []string
+	rows, err := db.Query("SELECT movie_id, position FROM movielistitem WHERE list_id = $1 ORDER BY position", listID)
+	if err != nil {
+		t.Fatalf("Failed to query list items: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemMovieID, position int
+		if err := rows.Scan(&itemMovieID, &position); err != nil {
+			t.Fatalf("Failed to scan list item: %v", err)
+		}
+		items = append(items, fmt.Sprintf("%d@%d", itemMovieID, position))
+	}
+	if !reflect.DeepEqual(items, []string{fmt.Sprintf("%d@1", movieID)}) {
+		t.Errorf("Expected only the archived movie at position 1, got %v", items)
+	}
+}
+
+func TestWriteExport_IncludesGenresWithoutMovies(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	var genreID int
+	err := db.QueryRow("INSERT INTO genre (name) VALUES ('Export Unused Genre') RETURNING id").Scan(&genreID)
+	if err != nil {
+		t.Fatalf("Failed to insert genre: %v", err)
+	}
+	defer db.Exec("DELETE FROM genre WHERE id = $1", genreID)
+
+	var out bytes.Buffer
+	if _, err := WriteExport(context.Background(), &out, exportDatasetGenres, importFormatCSV); err != nil {
+		t.Fatalf("Failed to export genres: %v", err)
+	}
+	if !strings.Contains(out.String(), "Export Unused Genre") {
+		t.Errorf("Expected genre without movies in export, got %s", out.String())
+	}
+}
+
+func TestListStreamer_WritesJSONArrayAndNDJSON(t *testing.T) {
+	tests := []struct {
+		accept      string
+		items       []model.Actor
+		contentType string
+		body        string
+	}{
+		{accept: "", items: nil, contentType: "application/json", body: "[]"},
+		{accept: "application/json", items: []model.Actor{{ID: 1}, {ID: 2}}, contentType: "application/json",
+			body: `[{"id":1,"firstName":"","lastName":"","sex":"","birthDate":"0001-01-01T00:00:00Z"}` + "\n" +
+				`,{"id":2,"firstName":"","lastName":"","sex":"","birthDate":"0001-01-01T00:00:00Z"}` + "\n]"},
+		{accept: "application/x-ndjson", items: []model.Actor{{ID: 1}, {ID: 2}}, contentType: "application/x-ndjson",
+			body: `{"id":1,"firstName":"","lastName":"","sex":"","birthDate":"0001-01-01T00:00:00Z"}` + "\n" +
+				`{"id":2,"firstName":"","lastName":"","sex":"","birthDate":"0001-01-01T00:00:00Z"}` + "\n"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", test.accept)
+
+		stream := newListStreamer(w, r)
+		for _, item := range test.items {
+			if err := stream.write(item); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if err := stream.close(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if w.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("Expected Content-Type %s, got %s", test.contentType, w.Header().Get("Content-Type"))
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("Expected body %q, got %q", test.body, w.Body.String())
+		}
+	}
+}
+
+func TestApplyBatchRefs_SubstitutesEarlierIDs(t *testing.T) {
+	ids := []int{42, 0, 0}
+
+	data, err := applyBatchRefs(json.RawMessage(`{"firstName":"Ann"}`), map[string]int{"movieID": 0}, ids, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var actorMovie model.ActorMovie
+	if err := json.Unmarshal(data, &actorMovie); err != nil {
+		t.Fatalf("Failed to decode data: %v", err)
+	}
+	if actorMovie.MovieID != 42 || actorMovie.FirstName != "Ann" {
+		t.Errorf("Expected movieID 42 and firstName Ann, got %+v", actorMovie)
+	}
+
+	// A reference may only point to an earlier operation that returned an id
+	if _, err := applyBatchRefs(nil, map[string]int{"movieID": 2}, ids, 2); err == nil {
+		t.Error("Expected an error for a reference to the current operation")
+	}
+	if _, err := applyBatchRefs(nil, map[string]int{"movieID": 1}, ids, 2); err == nil {
+		t.Error("Expected an error for a reference to an operation without id")
+	}
+}
+
+func TestBatch_AllOrNothing(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	defer db.Exec("DELETE FROM actor WHERE firstName = 'Batch' AND lastName = 'Actor'")
+	defer db.Exec("DELETE FROM movie WHERE name = 'Batch Test'")
+
+	operations := []model.BatchOperation{
+		{Op: "add_movie", Data: json.RawMessage(`{"name":"Batch Test","date":"2001-02-03T00:00:00Z","rating":7}`)},
+		{Op: "add_actor_to_movie", Refs: map[string]int{"movieID": 0},
+			Data: json.RawMessage(`{"firstName":"Batch","lastName":"Actor","sex":"female","birthDate":"1980-01-01T00:00:00Z"}`)},
+		{Op: "delete_movie", Data: json.RawMessage(`{"id":2147483647}`)},
+		{Op: "add_actor", Data: json.RawMessage(`{"firstName":"Never","lastName":"Added"}`)},
+	}
+
+	batch := func(operations []model.BatchOperation) (*httptest.ResponseRecorder, model.BatchResponse) {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(model.BatchRequest{Operations: operations})
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(requestBody))
+		r.AddCookie(cookie)
+
+		Batch(w, r)
+
+		var response model.BatchResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		return w, response
+	}
+
+	countMovies := func() int {
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM movie WHERE name = 'Batch Test'").Scan(&count); err != nil {
+			t.Fatalf("Failed to count movies: %v", err)
+		}
+		return count
+	}
+
+	w, response := batch(operations)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if response.Committed || len(response.Results) != 4 {
+		t.Fatalf("Expected an uncommitted batch with 4 results, got %+v", response)
+	}
+	if response.Results[2].Error != "Movie doesn't exist. Nothing deleted" {
+		t.Errorf("Unexpected error for operation 2: %s", response.Results[2].Error)
+	}
+	if response.Results[3].Status != http.StatusFailedDependency {
+		t.Errorf("Expected status %d for operation 3, got %d", http.StatusFailedDependency, response.Results[3].Status)
+	}
+	if count := countMovies(); count != 0 {
+		t.Errorf("Expected the failed batch to be rolled back, found %d movies", count)
+	}
+
+	w, response = batch(operations[:2])
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if !response.Committed || response.Results[0].ID == 0 {
+		t.Fatalf("Expected a committed batch with the movie id, got %+v", response)
+	}
+	if count := countMovies(); count != 1 {
+		t.Errorf("Expected 1 movie after the batch, found %d", count)
+	}
+
+	var linked int
+	err = db.QueryRow("SELECT count(*) FROM actormovie WHERE movie_id = $1 AND actor_id = $2",
+		response.Results[0].ID, response.Results[1].ID).Scan(&linked)
+	if err != nil || linked != 1 {
+		t.Errorf("Expected the actor to be linked to the new movie, got %d (%v)", linked, err)
+	}
+
+	// add_movie accepts the same payload as POST /api/add-movie, which does not require a date
+	w, response = batch([]model.BatchOperation{{Op: "add_movie", Data: json.RawMessage(`{"name":"Batch Test","rating":7}`)}})
+	if w.Code != http.StatusOK || !response.Committed {
+		t.Errorf("Expected a committed batch, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSortedUniqueIDs(t *testing.T) {
+	ids := sortedUniqueIDs([]int{5, 2, 5, 1, 2})
+	if !reflect.DeepEqual(ids, []int{1, 2, 5}) {
+		t.Errorf("Expected [1 2 5], got %v", ids)
+	}
+
+	if ids := sortedUniqueIDs(nil); ids == nil || len(ids) != 0 {
+		t.Errorf("Expected an empty non-nil slice, got %#v", ids)
+	}
+}
+
+func TestSetMovieCast_LinksActorsByID(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID, firstActorID, secondActorID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Cast Test', '', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Cast', 'First', 'female', '1970-01-01') RETURNING id`).Scan(&firstActorID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Cast', 'Second', 'male', '1971-01-01') RETURNING id`).Scan(&secondActorID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id IN ($1, $2)", firstActorID, secondActorID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	setCast := func(actorIDs ...int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(model.Cast{ActorIDs: actorIDs})
+		r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.AddCookie(cookie)
+
+		SetMovieCast(w, r)
+
+		return w
+	}
+
+	unlink := func(actorID int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "/", nil)
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.SetPathValue("actorID", strconv.Itoa(actorID))
+		r.AddCookie(cookie)
+
+		UnlinkActor(w, r)
+
+		return w
+	}
+
+	w := setCast(secondActorID, firstActorID, firstActorID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var change model.CastChange
+	json.Unmarshal(w.Body.Bytes(), &change)
+	if len(change.Added) != 2 || len(change.Removed) != 0 {
+		t.Errorf("Expected 2 added and 0 removed actors, got %+v", change)
+	}
+
+	// An unknown id rejects the whole change
+	w = setCast(secondActorID, 2147483647)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Actors not found: 2147483647") {
+		t.Errorf("Expected a bad request listing the unknown id, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = setCast(secondActorID)
+	json.Unmarshal(w.Body.Bytes(), &change)
+	if w.Code != http.StatusOK || !reflect.DeepEqual(change.Removed, []int{firstActorID}) {
+		t.Errorf("Expected actor %d to be removed, got %d: %s", firstActorID, w.Code, w.Body.String())
+	}
+
+	if w = unlink(secondActorID); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w = unlink(secondActorID); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestValidateCredit(t *testing.T) {
+	if err := validateCredit("Neil McCauley", 1, "lead"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := validateCredit("", 0, ""); err != nil {
+		t.Errorf("Empty credit should be valid, got %v", err)
+	}
+
+	if err := validateCredit("", -1, ""); err == nil {
+		t.Error("Expected an error for a negative billing order")
+	}
+
+	if err := validateCredit("", 0, "extra"); err == nil || err.Error() != "Role type must be one of lead, supporting, cameo, voice" {
+		t.Errorf("Expected an error for an unknown role type, got %v", err)
+	}
+}
+
+func TestGetMovie_ListsCastInBillingOrder(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID, leadID, cameoID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Credits Test', '', '2001-02-03', 5) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Credits', 'Cameo', 'female', '1970-01-01') RETURNING id`).Scan(&cameoID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Credits', 'Lead', 'male', '1971-01-01') RETURNING id`).Scan(&leadID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id IN ($1, $2)", leadID, cameoID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	credit := func(handler http.HandlerFunc, actorID int, credit model.Credit) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(credit)
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(requestBody))
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.SetPathValue("actorID", strconv.Itoa(actorID))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	if w := credit(LinkActor, cameoID, model.Credit{Character: "Waitress", RoleType: "cameo"}); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := credit(LinkActor, leadID, model.Credit{Character: "Detective", BillingOrder: 1, RoleType: "lead"}); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := credit(UpdateCredit, cameoID, model.Credit{Character: "Waitress", BillingOrder: 2, RoleType: "voice"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := credit(UpdateCredit, cameoID, model.Credit{RoleType: "extra"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(movieID))
+	r.AddCookie(cookie)
+
+	GetMovie(w, r)
+
+	var movie model.MovieDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &movie); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	expected := []model.CastMember{
+		{ID: leadID, Character: "Detective", BillingOrder: 1, RoleType: "lead"},
+		{ID: cameoID, Character: "Waitress", BillingOrder: 2, RoleType: "voice"},
+	}
+	if len(movie.Cast) != len(expected) {
+		t.Fatalf("Expected %d cast members, got %+v", len(expected), movie.Cast)
+	}
+	for i, member := range movie.Cast {
+		if member.ID != expected[i].ID || member.Character != expected[i].Character ||
+			member.BillingOrder != expected[i].BillingOrder || member.RoleType != expected[i].RoleType {
+			t.Errorf("Cast member %d: expected %+v, got %+v", i, expected[i], member)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(leadID))
+	r.AddCookie(cookie)
+
+	GetActor(w, r)
+
+	var actor model.ActorDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &actor); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(actor.Filmography) != 1 || actor.Filmography[0].Character != "Detective" {
+		t.Errorf("Expected the filmography to show the character, got %+v", actor.Filmography)
+	}
+}
+
+// Compiles crew fields into EXISTS over the crew table of the department
+func TestCompileMovieFilter_CrewFields(t *testing.T) {
+	filter := model.MovieFilter{Field: "directorID", Op: "eq", Value: json.RawMessage(`3`)}
+
+	condition, args, err := compileMovieFilter(&filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(condition, "EXISTS (SELECT 1 FROM crew fc") ||
+		!strings.Contains(condition, "fc.department = 'directing' AND fc.person_id = ANY($1::integer[])") {
+		t.Errorf("Unexpected condition: %s", condition)
+	}
+	if len(args) != 1 {
+		t.Fatalf("Expected 1 argument, got %d", len(args))
+	}
+
+	filter = model.MovieFilter{Field: "composerID", Op: "gt", Value: json.RawMessage(`3`)}
+	if _, _, err := compileMovieFilter(&filter); err == nil {
+		t.Error("Expected error for unsupported operator, got nil")
+	}
+}
+
+func TestValidateCrewCredit(t *testing.T) {
+	if err := validateCrewCredit(model.CrewCredit{Department: "writing", Job: "Screenplay"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	invalid := []model.CrewCredit{
+		{Department: "acting"},
+		{Department: ""},
+		{Department: "music", Job: strings.Repeat("j", 101)},
+	}
+	for _, credit := range invalid {
+		if err := validateCrewCredit(credit); err == nil {
+			t.Errorf("Expected error for credit %+v, got nil", credit)
+		}
+	}
+}
+
+func TestAddCrew_ListsCrewAndPersonDepartments(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var directedID, actedID, personID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Crew Directed', '', '2003-01-01', 5) RETURNING id`).Scan(&directedID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Crew Acted', '', '2002-01-01', 5) RETURNING id`).Scan(&actedID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Crew', 'Director', 'male', '1960-01-01') RETURNING id`).Scan(&personID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2), ($1, $3)", personID, actedID, directedID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id = $1", personID)
+	defer db.Exec("DELETE FROM movie WHERE id IN ($1, $2)", directedID, actedID)
+
+	crew := func(handler http.HandlerFunc, credit model.CrewCredit) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(credit)
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(requestBody))
+		r.SetPathValue("id", strconv.Itoa(directedID))
+		r.SetPathValue("personID", strconv.Itoa(personID))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	if w := crew(AddCrew, model.CrewCredit{Department: "directing", Job: "Director"}); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := crew(AddCrew, model.CrewCredit{Department: "directing", Job: "Director"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+	if w := crew(AddCrew, model.CrewCredit{Department: "writing"}); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := crew(RemoveCrew, model.CrewCredit{Department: "writing"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(directedID))
+	r.AddCookie(cookie)
+
+	GetMovie(w, r)
+
+	var movie model.MovieDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &movie); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(movie.Crew) != 1 || movie.Crew[0].ID != personID || movie.Crew[0].Department != "directing" ||
+		movie.Crew[0].Job != "Director" {
+		t.Errorf("Unexpected crew: %+v", movie.Crew)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(personID))
+	r.AddCookie(cookie)
+
+	GetPerson(w, r)
+
+	var person model.PersonDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &person); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(person.Departments) != 2 {
+		t.Fatalf("Expected 2 departments, got %+v", person.Departments)
+	}
+	if acting := person.Departments["acting"]; len(acting) != 2 || acting[0].ID != actedID {
+		t.Errorf("Unexpected acting credits: %+v", acting)
+	}
+	if directing := person.Departments["directing"]; len(directing) != 1 || directing[0].ID != directedID {
+		t.Errorf("Unexpected directing credits: %+v", directing)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/movies?director="+strconv.Itoa(personID), nil)
+	r.AddCookie(cookie)
+
+	GetMoviesOrdered(w, r)
+
+	var movies []model.Movie
+	if err := json.Unmarshal(w.Body.Bytes(), &movies); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(movies) != 1 || movies[0].ID != directedID {
+		t.Errorf("Expected only movie %d, got %+v", directedID, movies)
+	}
+}
+
+func TestValidateTermName(t *testing.T) {
+	if err := validateTermName(genreTaxonomy, "Film Noir"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := validateTermName(tagTaxonomy, ""); err == nil || err.Error() != "Tag name cannot be empty" {
+		t.Errorf("Expected empty name error, got %v", err)
+	}
+
+	if err := validateTermName(genreTaxonomy, strings.Repeat("ж", maxTermNameLength+1)); err == nil {
+		t.Error("Expected error for too long name, got nil")
+	}
+}
+
+// Numbers genre and tag parameters as requested
+func TestTaxonomyCondition_UsesGivenParameters(t *testing.T) {
+	condition := taxonomyCondition(4, 5)
+
+	if !strings.HasPrefix(condition, "($4 = '' OR EXISTS") || !strings.Contains(condition, "lower(g.name) = lower($4)") ||
+		!strings.Contains(condition, "AND ($5 = '' OR EXISTS") || !strings.Contains(condition, "lower(t.name) = lower($5)") {
+		t.Errorf("Unexpected condition: %s", condition)
+	}
+}
+
+func TestAttachGenreAndTag_FiltersMovies(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+
+	// Set isAdmin flag for user in db to true
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var taggedID, otherID, actorID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Taxonomy Tagged', '', '2004-01-01', 5) RETURNING id`).Scan(&taggedID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Taxonomy Other', '', '2004-01-02', 5) RETURNING id`).Scan(&otherID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Taxonomy', 'Actor', 'male', '1970-01-01') RETURNING id`).Scan(&actorID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2), ($1, $3)", actorID, taggedID, otherID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM tag WHERE lower(name) = 'taxonomy-test-tag'")
+	defer db.Exec("DELETE FROM genre WHERE name = 'Taxonomy Test Genre'")
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	defer db.Exec("DELETE FROM movie WHERE id IN ($1, $2)", taggedID, otherID)
+	defer db.Exec("DELETE FROM actormovie WHERE actor_id = $1", actorID)
+
+	request := func(handler http.HandlerFunc, body interface{}, pathValues ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(body)
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(requestBody))
+		for i := 0; i+1 < len(pathValues); i += 2 {
+			r.SetPathValue(pathValues[i], pathValues[i+1])
+		}
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	w := request(AddGenre, model.Genre{Name: " Taxonomy Test Genre "})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var genre model.Genre
+	if err := json.Unmarshal(w.Body.Bytes(), &genre); err != nil || genre.Name != "Taxonomy Test Genre" {
+		t.Fatalf("Unexpected genre %+v: %v", genre, err)
+	}
+
+	if w := request(AddGenre, model.Genre{Name: "taxonomy test genre"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	if w := request(AttachGenre, nil, "id", strconv.Itoa(taggedID), "genreID", strconv.Itoa(genre.ID)); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := request(AttachTag, model.Tag{Name: "taxonomy-test-tag"}, "id", strconv.Itoa(taggedID)); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := request(AttachTag, model.Tag{Name: "Taxonomy-Test-Tag"}, "id", strconv.Itoa(taggedID)); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(taggedID))
+	r.AddCookie(cookie)
+
+	GetMovie(w, r)
+
+	var movie model.MovieDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &movie); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(movie.Genres) != 1 || movie.Genres[0].ID != genre.ID || len(movie.Tags) != 1 || movie.Tags[0].Name != "taxonomy-test-tag" {
+		t.Errorf("Unexpected genres %+v and tags %+v", movie.Genres, movie.Tags)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/movies?genre=taxonomy+test+genre&tag=TAXONOMY-TEST-TAG", nil)
+	r.AddCookie(cookie)
+
+	GetMoviesOrdered(w, r)
+
+	var movies []model.Movie
+	if err := json.Unmarshal(w.Body.Bytes(), &movies); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(movies) != 1 || movies[0].ID != taggedID {
+		t.Errorf("Expected only movie %d, got %+v", taggedID, movies)
+	}
+
+	w = request(SearchMovie, model.SearchMovie{Genre: "Taxonomy Test Genre"})
+
+	movies = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &movies); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if len(movies) != 1 || movies[0].Name != "Taxonomy Tagged" {
+		t.Errorf("Expected only movie %q, got %+v", "Taxonomy Tagged", movies)
+	}
+
+	if w := request(DetachGenre, nil, "id", strconv.Itoa(otherID), "genreID", strconv.Itoa(genre.ID)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestValidateScore(t *testing.T) {
+	for _, score := range []int16{1, 10} {
+		if err := validateScore(score); err != nil {
+			t.Errorf("Unexpected error for score %d: %v", score, err)
+		}
+	}
+
+	for _, score := range []int16{0, 11, -1} {
+		if err := validateScore(score); err == nil {
+			t.Errorf("Expected error for score %d, got nil", score)
+		}
+	}
+}
+
+// Puts movies without community ratings last in both directions
+func TestMovieOrderBy(t *testing.T) {
+	if orderBy := movieOrderBy("community", "desc"); orderBy != "rs.community_rating desc NULLS LAST, m.id" {
+		t.Errorf("Unexpected ORDER BY: %s", orderBy)
+	}
+
+	if orderBy := movieOrderBy("name", "asc"); orderBy != "m.name asc NULLS LAST, m.id" {
+		t.Errorf("Unexpected ORDER BY: %s", orderBy)
+	}
+}
+
+func TestRateMovie_AggregatesCommunityRating(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	var ratedID, unratedID, actorID, otherUserID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Community Rated', '', '2005-01-01', 3) RETURNING id`).Scan(&ratedID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Community Unrated', '', '2005-01-02', 9) RETURNING id`).Scan(&unratedID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Community', 'Actor', 'female', '1975-01-01') RETURNING id`).Scan(&actorID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2), ($1, $3)", actorID, ratedID, unratedID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('community_rater', 'x', false) RETURNING id`).Scan(&otherUserID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id = $1", otherUserID)
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	defer db.Exec("DELETE FROM movie WHERE id IN ($1, $2)", ratedID, unratedID)
+	defer db.Exec("DELETE FROM actormovie WHERE actor_id = $1", actorID)
+
+	cookieFor := func(issuer string) *http.Cookie {
+		token := jwt.New(jwt.SigningMethodHS256)
+		claims := token.Claims.(jwt.MapClaims)
+		claims["iss"] = issuer
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signedToken, _ := token.SignedString([]byte(secretKey))
+		return &http.Cookie{Name: jwtName, Value: signedToken}
+	}
+	cookie := cookieFor("1")
+	otherCookie := cookieFor(strconv.Itoa(otherUserID))
+
+	rate := func(handler http.HandlerFunc, cookie *http.Cookie, score int16) (*httptest.ResponseRecorder, model.UserRatingResult) {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(model.UserRating{Score: score})
+		r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+		r.SetPathValue("id", strconv.Itoa(ratedID))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		var result model.UserRatingResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+
+		return w, result
+	}
+
+	if w, _ := rate(RateMovie, cookie, 8); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w, result := rate(RateMovie, otherCookie, 5); w.Code != http.StatusOK || result.CommunityRating != (model.RatingSummary{Average: 6.5, Count: 2}) {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if w, result := rate(RateMovie, cookie, 10); result.CommunityRating != (model.RatingSummary{Average: 7.5, Count: 2}) {
+		t.Errorf("Unexpected response after changing rating: %s", w.Body.String())
+	}
+	if w, _ := rate(RateMovie, cookie, 11); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w, result := rate(DeleteRating, otherCookie, 0); w.Code != http.StatusOK || result.CommunityRating != (model.RatingSummary{Average: 10, Count: 1}) {
+		t.Errorf("Unexpected response after deleting rating %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := rate(DeleteRating, otherCookie, 0); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(ratedID))
+	r.AddCookie(cookie)
+
+	GetMovie(w, r)
+
+	var movie model.MovieDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &movie); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	if movie.Rating != 3 || movie.MyRating != 10 || movie.CommunityRating == nil || movie.CommunityRating.Count != 1 {
+		t.Errorf("Unexpected movie ratings: %+v", movie.Movie)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/movies?by=community&order=asc", nil)
+	r.AddCookie(cookie)
+
+	GetMoviesOrdered(w, r)
+
+	var movies []model.Movie
+	if err := json.Unmarshal(w.Body.Bytes(), &movies); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	ratedIndex, unratedIndex := -1, -1
+	for i, m := range movies {
+		switch m.ID {
+		case ratedID:
+			ratedIndex = i
+		case unratedID:
+			unratedIndex = i
+		}
+	}
+	if ratedIndex < 0 || unratedIndex < ratedIndex {
+		t.Errorf("Expected rated movie before unrated one, got positions %d and %d", ratedIndex, unratedIndex)
+	}
+}
+
+func TestRateMovie_KeepsMovieVersion(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	_, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var movieID, otherUserID int
+	err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Version Rated', '', '2006-01-01', 5) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('version_rater', 'x', false) RETURNING id`).Scan(&otherUserID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id = $1", otherUserID)
+	defer db.Exec("DELETE FROM revision WHERE entity = 'movie' AND entity_id = $1", movieID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	cookieFor := func(issuer string) *http.Cookie {
+		token := jwt.New(jwt.SigningMethodHS256)
+		claims := token.Claims.(jwt.MapClaims)
+		claims["iss"] = issuer
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signedToken, _ := token.SignedString([]byte(secretKey))
+		return &http.Cookie{Name: jwtName, Value: signedToken}
+	}
+	cookie := cookieFor("1")
+	otherCookie := cookieFor(strconv.Itoa(otherUserID))
+
+	getMovie := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.AddCookie(cookie)
+
+		GetMovie(w, r)
+
+		return w
+	}
+
+	rate := func(cookie *http.Cookie, score int16) {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(model.UserRating{Score: score})
+		r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.AddCookie(cookie)
+
+		RateMovie(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	w := getMovie(cookie)
+	etag := w.Header().Get("ETag")
+	if w.Header().Get("Vary") != "Cookie" {
+		t.Errorf("Expected Vary: Cookie, got %q", w.Header().Get("Vary"))
+	}
+
+	rate(cookie, 9)
+	rate(otherCookie, 4)
+
+	// Ratings change the per-user ETag but not the movie version
+	w = getMovie(cookie)
+	if newETag := w.Header().Get("ETag"); newETag == etag || !strings.HasPrefix(newETag, `"1-`) {
+		t.Errorf("Expected new ETag of version 1, got %s (was %s)", newETag, etag)
+	}
+	if w.Header().Get("ETag") == getMovie(otherCookie).Header().Get("ETag") {
+		t.Error("Expected different ETags for users with different ratings")
+	}
+
+	w = httptest.NewRecorder()
+	requestBody, _ := json.Marshal(model.Movie{ID: movieID, Description: "Rated", Rating: 6})
+	r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(requestBody))
+	r.Header.Set("If-Match", etag)
+	r.AddCookie(cookie)
+
+	UpdateMovie(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+}
+
+func TestValidateReview(t *testing.T) {
+	if err := validateReview(model.ReviewInput{Title: "Title", Body: "Body"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	invalid := []model.ReviewInput{
+		{Title: "Title"},
+		{Title: strings.Repeat("т", maxReviewTitleLength+1), Body: "Body"},
+		{Body: strings.Repeat("т", maxReviewBodyLength+1)},
+	}
+	for _, input := range invalid {
+		if err := validateReview(input); err == nil {
+			t.Errorf("Expected error for title of %d and body of %d symbols, got nil",
+				len([]rune(input.Title)), len([]rune(input.Body)))
+		}
+	}
+
+	if err := validateModeration(model.ReviewModeration{Status: reviewStatusPending}); err == nil {
+		t.Error("Expected error for moderation to pending, got nil")
+	}
+}
+
+func TestParseReviewPage(t *testing.T) {
+	limit, offset, err := parsePage(url.Values{})
+	if err != nil || limit != pageDefaultLimit || offset != 0 {
+		t.Errorf("Unexpected default page: %d, %d, %v", limit, offset, err)
+	}
+
+	limit, offset, err = parsePage(url.Values{"limit": {"5"}, "offset": {"10"}})
+	if err != nil || limit != 5 || offset != 10 {
+		t.Errorf("Unexpected page: %d, %d, %v", limit, offset, err)
+	}
+
+	for _, query := range []url.Values{{"limit": {"0"}}, {"limit": {"101"}}, {"offset": {"-1"}}, {"offset": {"a"}}} {
+		if _, _, err := parsePage(query); err == nil {
+			t.Errorf("Expected error for %v, got nil", query)
+		}
+	}
+}
+
+func TestReviews_ModerationFlow(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	var movieID, authorID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Reviewed Movie', '', '2006-01-01', 7) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('review_author', 'x', false) RETURNING id`).Scan(&authorID)
+	}
+	if err == nil {
+		_, err = db.Exec("UPDATE person SET isAdmin = true WHERE id = 1")
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id = $1", authorID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	cookieFor := func(issuer string) *http.Cookie {
+		token := jwt.New(jwt.SigningMethodHS256)
+		claims := token.Claims.(jwt.MapClaims)
+		claims["iss"] = issuer
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signedToken, _ := token.SignedString([]byte(secretKey))
+		return &http.Cookie{Name: jwtName, Value: signedToken}
+	}
+	adminCookie := cookieFor("1")
+	authorCookie := cookieFor(strconv.Itoa(authorID))
+
+	call := func(handler http.HandlerFunc, cookie *http.Cookie, id int, target string, body interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(body)
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(requestBody))
+		r.SetPathValue("id", strconv.Itoa(id))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	w := call(AddReview, authorCookie, movieID, "/", model.ReviewInput{Title: " Good ", Body: "Worth watching"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var review model.Review
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if review.Title != "Good" || review.Status != reviewStatusPending || review.Username != "review_author" {
+		t.Errorf("Unexpected review: %+v", review)
+	}
+
+	if w := call(AddReview, authorCookie, movieID, "/", model.ReviewInput{Body: "Again"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+	if w := call(UpdateReview, adminCookie, review.ID, "/", model.ReviewInput{Body: "Not mine"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := call(ReportReview, authorCookie, review.ID, "/", model.ReviewReport{Reason: "Mine"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := call(ReportReview, adminCookie, review.ID, "/", model.ReviewReport{Reason: "Spoilers"}); w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := call(ReportReview, adminCookie, review.ID, "/", model.ReviewReport{Reason: "Spoilers"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	var queue []model.Review
+	w = call(GetModerationQueue, adminCookie, 0, "/api/moderation/reviews?limit=100", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &queue); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(queue) == 0 || queue[0].ID != review.ID || queue[0].Reports != 1 {
+		t.Errorf("Expected the reported review first in the queue, got %+v", queue)
+	}
+
+	if w := call(ModerateReview, authorCookie, review.ID, "/", model.ReviewModeration{Status: reviewStatusApproved}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := call(ModerateReview, adminCookie, review.ID, "/", model.ReviewModeration{Status: reviewStatusHidden}); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var reports int
+	db.QueryRow("SELECT count(*) FROM ReviewReport WHERE review_id = $1", review.ID).Scan(&reports)
+	if reports != 0 {
+		t.Errorf("Expected moderation to close reports, %d left", reports)
+	}
+
+	var reviews []model.Review
+	w = call(GetMovieReviews, adminCookie, movieID, "/", nil)
+	json.Unmarshal(w.Body.Bytes(), &reviews)
+	if w.Code != http.StatusOK || len(reviews) != 0 {
+		t.Errorf("Expected hidden review to be excluded, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = call(GetUserReviews, authorCookie, authorID, "/", nil)
+	json.Unmarshal(w.Body.Bytes(), &reviews)
+	if len(reviews) != 1 || reviews[0].Status != reviewStatusHidden {
+		t.Errorf("Expected author to see the hidden review, got %s", w.Body.String())
+	}
+
+	if w := call(ReportReview, adminCookie, review.ID, "/", model.ReviewReport{}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = call(UpdateReview, authorCookie, review.ID, "/", model.ReviewInput{Body: "Edited"})
+	json.Unmarshal(w.Body.Bytes(), &review)
+	if w.Code != http.StatusOK || review.Status != reviewStatusPending || review.Body != "Edited" {
+		t.Errorf("Expected edit to return the review to the queue, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call(DeleteReview, adminCookie, review.ID, "/", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := call(GetReview, authorCookie, review.ID, "/", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestValidateWatchedOn(t *testing.T) {
+	for _, watchedOn := range []time.Time{{}, time.Now(), time.Now().AddDate(-10, 0, 0)} {
+		if err := validateWatchedOn(watchedOn); err != nil {
+			t.Errorf("Unexpected error for %v: %v", watchedOn, err)
+		}
+	}
+
+	if err := validateWatchedOn(time.Now().AddDate(0, 0, 3)); err == nil {
+		t.Error("Expected error for a date in the future, got nil")
+	}
+}
+
+func TestWatchlist_FlagsAndListing(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	var firstID, secondID, actorID, userID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Watch First', '', '2007-01-01', 6) RETURNING id`).Scan(&firstID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Watch Second', '', '2007-01-02', 6) RETURNING id`).Scan(&secondID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Watch', 'Actor', 'male', '1970-01-01') RETURNING id`).Scan(&actorID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2), ($1, $3)", actorID, firstID, secondID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('watchlist_user', 'x', false) RETURNING id`).Scan(&userID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	defer db.Exec("DELETE FROM movie WHERE id IN ($1, $2)", firstID, secondID)
+	defer db.Exec("DELETE FROM actormovie WHERE actor_id = $1", actorID)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = strconv.Itoa(userID)
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	call := func(handler http.HandlerFunc, movieID int, target string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	etag := call(GetMovie, firstID, "/", "").Header().Get("ETag")
+
+	if w := call(AddToWatchlist, firstID, "/", ""); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := call(AddToWatchlist, firstID, "/", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+	if w := call(AddToWatchlist, secondID, "/", ""); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var state model.WatchState
+	w := call(MarkWatched, secondID, "/", `{"watchedOn": "2024-03-17T00:00:00Z"}`)
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if w.Code != http.StatusOK || !state.OnWatchlist || !state.Watched || state.WatchedOn.Format(time.DateOnly) != "2024-03-17" {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	var entries []model.WatchlistEntry
+	w = call(GetWatchlist, 0, "/api/watchlist?order=asc", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != firstID || entries[1].ID != secondID || !entries[1].Watched {
+		t.Errorf("Unexpected watchlist: %s", w.Body.String())
+	}
+
+	w = call(GetMovie, firstID, "/", "")
+	var movie model.MovieDetails
+	json.Unmarshal(w.Body.Bytes(), &movie)
+	if !movie.OnWatchlist || movie.Watched {
+		t.Errorf("Unexpected movie flags: %+v", movie.Movie)
+	}
+
+	// Watchlist flags change the user's ETag but not the shared movie version
+	if newETag := w.Header().Get("ETag"); newETag == etag || !strings.HasPrefix(newETag, `"1-`) {
+		t.Errorf("Expected new ETag of version 1, got %s (was %s)", newETag, etag)
+	}
+	var version int
+	if err := db.QueryRow("SELECT version FROM movie WHERE id = $1", secondID).Scan(&version); err != nil || version != 1 {
+		t.Errorf("Expected version 1 after marking watched, got %d (%v)", version, err)
+	}
+
+	if w := call(RemoveFromWatchlist, firstID, "/", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := call(UnmarkWatched, firstID, "/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = call(GetWatched, 0, "/api/watched", "")
+	json.Unmarshal(w.Body.Bytes(), &entries)
+	if len(entries) != 1 || entries[0].ID != secondID || !entries[0].OnWatchlist {
+		t.Errorf("Unexpected watched history: %s", w.Body.String())
+	}
+
+	// An unknown order is rejected instead of falling back to desc
+	w = call(GetWatched, 0, "/api/watched?order=newest", "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "order must be asc or desc") {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestValidateListOrder(t *testing.T) {
+	current := []int64{3, 1, 2}
+
+	if err := validateListOrder([]int{2, 3, 1}, current); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for _, order := range [][]int{{1, 2}, {1, 2, 2}, {1, 2, 4}, {1, 2, 3, 4}} {
+		if err := validateListOrder(order, current); err == nil {
+			t.Errorf("Expected error for %v, got nil", order)
+		}
+	}
+
+	if err := validateMovieList(model.MovieListInput{Name: strings.Repeat("т", maxListNameLength)}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateMovieList(model.MovieListInput{Description: "No name"}); err == nil {
+		t.Error("Expected error for an empty name, got nil")
+	}
+}
+
+func TestMovieLists_OrderAndVisibility(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	movieIDs := make([]int, 3)
+	var ownerID, otherID int
+	var err error
+	for i := range movieIDs {
+		if err == nil {
+			err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+				VALUES ($1, '', '1999-01-01', 8) RETURNING id`, fmt.Sprintf("Best of 1999 #%d", i)).Scan(&movieIDs[i])
+		}
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('list_owner', 'x', false) RETURNING id`).Scan(&ownerID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('list_reader', 'x', false) RETURNING id`).Scan(&otherID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id IN ($1, $2)", ownerID, otherID)
+	defer db.Exec("DELETE FROM movie WHERE id IN ($1, $2, $3)", movieIDs[0], movieIDs[1], movieIDs[2])
+
+	cookieFor := func(issuer int) *http.Cookie {
+		token := jwt.New(jwt.SigningMethodHS256)
+		claims := token.Claims.(jwt.MapClaims)
+		claims["iss"] = strconv.Itoa(issuer)
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signedToken, _ := token.SignedString([]byte(secretKey))
+		return &http.Cookie{Name: jwtName, Value: signedToken}
+	}
+	ownerCookie := cookieFor(ownerID)
+	otherCookie := cookieFor(otherID)
+
+	call := func(handler http.HandlerFunc, cookie *http.Cookie, pathValues map[string]int, body interface{}) (*httptest.ResponseRecorder, model.MovieListDetails) {
+		w := httptest.NewRecorder()
+		requestBody, _ := json.Marshal(body)
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(requestBody))
+		for name, value := range pathValues {
+			r.SetPathValue(name, strconv.Itoa(value))
+		}
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		var details model.MovieListDetails
+		json.Unmarshal(w.Body.Bytes(), &details)
+
+		return w, details
+	}
+
+	w, list := call(AddMovieList, ownerCookie, nil, model.MovieListInput{Name: "Best of 1999", Description: "Favourites"})
+	if w.Code != http.StatusCreated || list.Public {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := call(AddMovieList, ownerCookie, nil, model.MovieListInput{Name: "best of 1999"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	listPath := map[string]int{"id": list.ID}
+	for _, movieID := range movieIDs {
+		if w, _ := call(AddMovieToList, ownerCookie, listPath, model.MovieListAdd{MovieID: movieID}); w.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+
+	// Inserting at the top shifts the other movies down
+	if w, _ := call(RemoveMovieFromList, ownerCookie, map[string]int{"id": list.ID, "movieID": movieIDs[2]}, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	_, list = call(AddMovieToList, ownerCookie, listPath, model.MovieListAdd{MovieID: movieIDs[2], Position: 1})
+	if len(list.Movies) != 3 || list.Movies[0].ID != movieIDs[2] || list.Movies[2].Position != 3 {
+		t.Errorf("Unexpected list after insert: %+v", list.Movies)
+	}
+
+	if w, _ := call(GetMovieList, otherCookie, listPath, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected private list to be hidden, got %d", w.Code)
+	}
+
+	reversed := []int{movieIDs[1], movieIDs[0], movieIDs[2]}
+	w, list = call(ReorderMovieList, ownerCookie, listPath, model.MovieListOrder{MovieIDs: reversed})
+	if w.Code != http.StatusOK || list.Movies[0].ID != movieIDs[1] || list.Movies[2].ID != movieIDs[2] {
+		t.Errorf("Unexpected response after reorder %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := call(ReorderMovieList, ownerCookie, listPath, model.MovieListOrder{MovieIDs: reversed[:2]}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	call(UpdateMovieList, ownerCookie, listPath, model.MovieListInput{Name: "Best of 1999", Public: true})
+
+	if w, list := call(GetMovieList, otherCookie, listPath, nil); w.Code != http.StatusOK || len(list.Movies) != 3 {
+		t.Errorf("Expected public list to be readable, got %d: %s", w.Code, w.Body.String())
+	}
+	if w, _ := call(AddMovieToList, otherCookie, listPath, model.MovieListAdd{MovieID: movieIDs[0]}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetPathValue("id", strconv.Itoa(ownerID))
+	r.AddCookie(otherCookie)
+
+	GetUserProfile(w, r)
+
+	var profile model.UserProfile
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if profile.Username != "list_owner" || len(profile.Lists) != 1 || profile.Lists[0].MovieCount != 3 {
+		t.Errorf("Unexpected profile: %s", w.Body.String())
+	}
+}
+
+func TestParseRecommendationsLimit(t *testing.T) {
+	if limit, err := parseRecommendationsLimit(""); err != nil || limit != recommendationsDefaultLimit {
+		t.Errorf("Expected default limit %d, got %d (%v)", recommendationsDefaultLimit, limit, err)
+	}
+	if limit, err := parseRecommendationsLimit("5"); err != nil || limit != 5 {
+		t.Errorf("Expected limit 5, got %d (%v)", limit, err)
+	}
+
+	for _, value := range []string{"0", "-1", "abc", strconv.Itoa(recommendationsMaxLimit + 1)} {
+		if _, err := parseRecommendationsLimit(value); err == nil {
+			t.Errorf("Expected error for limit %q, got nil", value)
+		}
+	}
+}
+
+func TestRecommendationReasons(t *testing.T) {
+	reasons := recommendationReasons([2]string{"cast", "genre"}, [2][]string{{"Actor One"}, nil})
+	if len(reasons) != 1 || reasons[0].Kind != "cast" || len(reasons[0].Names) != 1 {
+		t.Errorf("Unexpected reasons: %+v", reasons)
+	}
+
+	if reasons := recommendationReasons([2]string{}, [2][]string{}); reasons == nil || len(reasons) != 0 {
+		t.Errorf("Expected empty non-nil reasons, got %+v", reasons)
+	}
+}
+
+func TestRecommendations_CastGenreAndHistory(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// source shares an actor with castMatch and a genre with genreMatch, unrelated shares nothing
+	var sourceID, castMatchID, genreMatchID, unrelatedID, actorID, genreID, userID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Recommend Source', '', '2008-01-01', 5) RETURNING id`).Scan(&sourceID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Recommend Cast', '', '2008-01-02', 5) RETURNING id`).Scan(&castMatchID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Recommend Genre', '', '2008-01-03', 5) RETURNING id`).Scan(&genreMatchID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+			VALUES ('Recommend Unrelated', '', '2008-01-04', 5) RETURNING id`).Scan(&unrelatedID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Recommend', 'Actor', 'female', '1975-01-01') RETURNING id`).Scan(&actorID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO genre (name) VALUES ('Recommend Genre') RETURNING id`).Scan(&genreID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2), ($1, $3)", actorID, sourceID, castMatchID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO moviegenre (movie_id, genre_id) VALUES ($1, $3), ($2, $3)", sourceID, genreMatchID, genreID)
+	}
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO person (username, password, isAdmin)
+			VALUES ('recommend_user', 'x', false) RETURNING id`).Scan(&userID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM person WHERE id = $1", userID)
+	defer db.Exec("DELETE FROM genre WHERE id = $1", genreID)
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	defer db.Exec("DELETE FROM movie WHERE id IN ($1, $2, $3, $4)", sourceID, castMatchID, genreMatchID, unrelatedID)
+	defer db.Exec("DELETE FROM actormovie WHERE actor_id = $1", actorID)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = strconv.Itoa(userID)
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	call := func(handler http.HandlerFunc, movieID int, target string) ([]model.Recommendation, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.SetPathValue("id", strconv.Itoa(movieID))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		var recommendations []model.Recommendation
+		json.Unmarshal(w.Body.Bytes(), &recommendations)
+		return recommendations, w
+	}
+
+	recommendations, w := call(GetMovieRecommendations, sourceID, "/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if len(recommendations) != 2 || recommendations[0].ID != castMatchID || recommendations[1].ID != genreMatchID {
+		t.Fatalf("Expected cast match before genre match, got %+v", recommendations)
+	}
+	if recommendations[0].Score != recommendCastWeight || recommendations[0].Reasons[0].Kind != "cast" ||
+		recommendations[0].Reasons[0].Names[0] != "Recommend Actor" {
+		t.Errorf("Unexpected cast recommendation: %+v", recommendations[0])
+	}
+	if recommendations[1].Score != recommendGenreWeight || recommendations[1].Reasons[0].Kind != "genre" ||
+		recommendations[1].Reasons[0].Names[0] != "Recommend Genre" {
+		t.Errorf("Unexpected genre recommendation: %+v", recommendations[1])
+	}
+
+	if _, w := call(GetMovieRecommendations, sourceID, "/?limit=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if _, w := call(GetMovieRecommendations, 0x7fffffff, "/"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// A highly rated source recommends both matches, but never the rated or watched movies themselves
+	_, err = db.Exec("INSERT INTO userrating (person_id, movie_id, score) VALUES ($1, $2, 9)", userID, sourceID)
+	if err == nil {
+		_, err = db.Exec("INSERT INTO watchedmovie (person_id, movie_id) VALUES ($1, $2)", userID, genreMatchID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert user history: %v", err)
+	}
+
+	recommendations, w = call(GetRecommendations, 0, "/?limit=50")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	found := false
+	for _, recommendation := range recommendations {
+		switch recommendation.ID {
+		case castMatchID:
+			found = true
+			if recommendation.Reasons[0].Kind != "rated" || recommendation.Reasons[0].Names[0] != "Recommend Source" {
+				t.Errorf("Unexpected reasons: %+v", recommendation.Reasons)
+			}
+		case sourceID, genreMatchID, unrelatedID:
+			t.Errorf("Movie %d must not be recommended", recommendation.ID)
+		}
+	}
+	if !found {
+		t.Errorf("Expected movie %d among recommendations, got %+v", castMatchID, recommendations)
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	if depth, err := parseMaxDepth(""); err != nil || depth != graphMaxDepth {
+		t.Errorf("Expected default depth %d, got %d (%v)", graphMaxDepth, depth, err)
+	}
+	if depth, err := parseMaxDepth("2"); err != nil || depth != 2 {
+		t.Errorf("Expected depth 2, got %d (%v)", depth, err)
+	}
+
+	for _, value := range []string{"0", "abc", strconv.Itoa(graphMaxDepth + 1)} {
+		if _, err := parseMaxDepth(value); err == nil {
+			t.Errorf("Expected error for maxDepth %q, got nil", value)
+		}
+	}
+}
+
+func TestJoinActorPath(t *testing.T) {
+	// 1 -(10)- 2 -(20)- 3 -(30)- 4, halves meet on actor 3
+	parents := [2]map[int]actorLink{
+		{1: {}, 2: {actorID: 1, movieID: 10}, 3: {actorID: 2, movieID: 20}},
+		{4: {}, 3: {actorID: 4, movieID: 30}},
+	}
+
+	actorIDs, movieIDs := joinActorPath(parents, 1, 4, 3)
+	if !reflect.DeepEqual(actorIDs, []int{1, 2, 3, 4}) || !reflect.DeepEqual(movieIDs, []int{10, 20, 30}) {
+		t.Errorf("Unexpected path: actors %v, movies %v", actorIDs, movieIDs)
+	}
+}
+
+func TestActorGraph_CoStarsAndPath(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Chain a -(m1, m2)- b -(m3)- c -(m4)- d, e has no movies
+	var actorIDs [5]int
+	var movieIDs [4]int
+	var err error
+	for i := range actorIDs {
+		if err == nil {
+			err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+				VALUES ('Graph', $1, 'male', '1970-01-01') RETURNING id`, string(rune('A'+i))).Scan(&actorIDs[i])
+		}
+	}
+	for i := range movieIDs {
+		if err == nil {
+			err = db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+				VALUES ($1, '', '2009-01-01', 5) RETURNING id`, "Graph Movie "+strconv.Itoa(i+1)).Scan(&movieIDs[i])
+		}
+	}
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO actormovie (actor_id, movie_id) VALUES
+			($1, $5), ($2, $5), ($1, $6), ($2, $6), ($2, $7), ($3, $7), ($3, $8), ($4, $8)`,
+			actorIDs[0], actorIDs[1], actorIDs[2], actorIDs[3], movieIDs[0], movieIDs[1], movieIDs[2], movieIDs[3])
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id = ANY($1)", pq.Array(actorIDs[:]))
+	defer db.Exec("DELETE FROM movie WHERE id = ANY($1)", pq.Array(movieIDs[:]))
+	defer db.Exec("DELETE FROM actormovie WHERE actor_id = ANY($1)", pq.Array(actorIDs[:]))
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	call := func(handler http.HandlerFunc, id, targetID int, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.SetPathValue("id", strconv.Itoa(id))
+		r.SetPathValue("targetID", strconv.Itoa(targetID))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	w := call(GetCoStars, actorIDs[1], 0, "/")
+	var coStars []model.CoStar
+	json.Unmarshal(w.Body.Bytes(), &coStars)
+	if w.Code != http.StatusOK || len(coStars) != 2 {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if coStars[0].ID != actorIDs[0] || coStars[0].SharedMovies != 2 || coStars[1].ID != actorIDs[2] || coStars[1].SharedMovies != 1 {
+		t.Errorf("Unexpected co-stars: %+v", coStars)
+	}
+
+	w = call(GetActorPath, actorIDs[0], actorIDs[3], "/")
+	var path model.ActorPath
+	json.Unmarshal(w.Body.Bytes(), &path)
+	if w.Code != http.StatusOK || path.Degrees != 3 || len(path.Steps) != 4 {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if path.Steps[0].ID != actorIDs[0] || path.Steps[0].Movie != nil || path.Steps[3].ID != actorIDs[3] ||
+		path.Steps[1].Movie.ID != movieIDs[0] || path.Steps[3].Movie.ID != movieIDs[3] {
+		t.Errorf("Unexpected path: %+v", path)
+	}
+
+	if w := call(GetActorPath, actorIDs[0], actorIDs[3], "/?maxDepth=2"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := call(GetActorPath, actorIDs[0], actorIDs[4], "/"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := call(GetActorPath, actorIDs[0], 0x7fffffff, "/"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// Deleting the middle movie breaks the chain
+	db.Exec("UPDATE movie SET deleted_at = now() WHERE id = $1", movieIDs[2])
+	if w := call(GetActorPath, actorIDs[0], actorIDs[3], "/"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d after deleting the linking movie, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestDetails_LinkedChangesUpdateETag(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	var movieID, actorID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Linked Movie', '', '2010-01-01', 7) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Linked', 'Actor', 'female', '1980-01-01') RETURNING id`).Scan(&actorID)
+	}
+	if err == nil {
+		_, err = db.Exec("INSERT INTO actormovie (actor_id, movie_id) VALUES ($1, $2)", actorID, movieID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	get := func(handler http.HandlerFunc, id int, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetPathValue("id", strconv.Itoa(id))
+		r.Header.Set("If-None-Match", etag)
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	movieETag := get(GetMovie, movieID, "").Header().Get("ETag")
+
+	// Renaming the actor changes the cast shown with the movie
+	if _, err := db.Exec("UPDATE actor SET lastName = 'Renamed' WHERE id = $1", actorID); err != nil {
+		t.Fatalf("Failed to update actor: %v", err)
+	}
+	w := get(GetMovie, movieID, movieETag)
+	var movie model.MovieDetails
+	json.Unmarshal(w.Body.Bytes(), &movie)
+	if w.Code != http.StatusOK || len(movie.Cast) != 1 || movie.Cast[0].LastName != "Renamed" {
+		t.Errorf("Expected fresh movie details after actor update, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Changing the movie changes the actor's filmography
+	actorETag := get(GetActor, actorID, "").Header().Get("ETag")
+	if _, err := db.Exec("UPDATE movie SET rating = 9 WHERE id = $1", movieID); err != nil {
+		t.Fatalf("Failed to update movie: %v", err)
+	}
+	w = get(GetActor, actorID, actorETag)
+	var actor model.ActorDetails
+	json.Unmarshal(w.Body.Bytes(), &actor)
+	if w.Code != http.StatusOK || len(actor.Filmography) != 1 || actor.Filmography[0].Rating != 9 {
+		t.Errorf("Expected fresh actor details after movie update, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Version-only changes do not cascade back
+	actorETag = get(GetActor, actorID, "").Header().Get("ETag")
+	if _, err := db.Exec("UPDATE movie SET version = version + 1 WHERE id = $1", movieID); err != nil {
+		t.Fatalf("Failed to bump movie version: %v", err)
+	}
+	if w := get(GetActor, actorID, actorETag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotModified, w.Code)
+	}
+
+	if w := get(GetMovie, 0x7fffffff, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := get(GetActor, 0x7fffffff, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestDetails_MovieChangeUpdatesCrewOnlyPersonETag(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	var movieID, personID int
+	err := db.QueryRow(`INSERT INTO movie (name, description, date, rating)
+		VALUES ('Crew Linked Movie', '', '2011-01-01', 7) RETURNING id`).Scan(&movieID)
+	if err == nil {
+		err = db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+			VALUES ('Crew', 'Only', 'male', '1960-01-01') RETURNING id`).Scan(&personID)
+	}
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO crew (person_id, movie_id, department, job)
+			VALUES ($1, $2, 'directing', 'Director')`, personID, movieID)
+	}
+	if err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id = $1", personID)
+	defer db.Exec("DELETE FROM movie WHERE id = $1", movieID)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	getPerson := func(etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetPathValue("id", strconv.Itoa(personID))
+		r.Header.Set("If-None-Match", etag)
+		r.AddCookie(cookie)
+
+		GetPerson(w, r)
+
+		return w
+	}
+
+	etag := getPerson("").Header().Get("ETag")
+
+	// The person is not in the cast, only in the crew of the renamed movie
+	if _, err := db.Exec("UPDATE movie SET name = 'Crew Renamed Movie' WHERE id = $1", movieID); err != nil {
+		t.Fatalf("Failed to update movie: %v", err)
+	}
+	w := getPerson(etag)
+	var person model.PersonDetails
+	json.Unmarshal(w.Body.Bytes(), &person)
+	credits := person.Departments["directing"]
+	if w.Code != http.StatusOK || len(credits) != 1 || credits[0].Name != "Crew Renamed Movie" {
+		t.Errorf("Expected fresh person details after movie update, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// Linking an actor by id to a new movie changes the actor's filmography and ETag
+func TestAddMovie_ActorByIDUpdatesActorETag(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	if _, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+
+	var actorID int
+	err := db.QueryRow(`INSERT INTO actor (firstName, lastName, sex, birthDate)
+		VALUES ('Linked', 'ByID', 'female', '1981-01-01') RETURNING id`).Scan(&actorID)
+	if err != nil {
+		t.Fatalf("Failed to insert actor: %v", err)
+	}
+	defer db.Exec("DELETE FROM actor WHERE id = $1", actorID)
+	defer db.Exec("DELETE FROM movie WHERE name = 'Linked By ID Movie'")
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	getActor := func(etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetPathValue("id", strconv.Itoa(actorID))
+		r.Header.Set("If-None-Match", etag)
+		r.AddCookie(cookie)
+
+		GetActor(w, r)
+
+		return w
+	}
+
+	etag := getActor("").Header().Get("ETag")
+
+	w := httptest.NewRecorder()
+	requestBody := fmt.Sprintf(`{"name": "Linked By ID Movie", "date": "2012-01-01T00:00:00Z", "rating": 6, "actors": [{"id": %d}]}`, actorID)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
+	r.AddCookie(cookie)
+
+	AddMovie(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w = getActor(etag)
+	var actor model.ActorDetails
+	json.Unmarshal(w.Body.Bytes(), &actor)
+	if w.Code != http.StatusOK || len(actor.Filmography) != 1 || actor.Filmography[0].Name != "Linked By ID Movie" {
+		t.Errorf("Expected fresh actor details after adding a movie, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestValidateMovieMetadata(t *testing.T) {
+	valid := model.Movie{Runtime: 120, Countries: []string{"RU", "US"}, OriginalLanguage: "en",
+		OriginalTitle: "Heat", AgeCertification: "16+",
+		ExternalIDs: &model.ExternalIDs{IMDb: "tt0113277", TMDb: "949", Kinopoisk: "409"}}
+	if err := validateMovieMetadata(valid); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateMovieMetadata(model.Movie{}); err != nil {
+		t.Errorf("Unexpected error for empty metadata: %v", err)
+	}
+
+	invalid := []model.Movie{
+		{Runtime: -1},
+		{Runtime: 1001},
+		{Countries: []string{"ru"}},
+		{Countries: []string{"RUS"}},
+		{Countries: []string{"US", "US"}},
+		{OriginalLanguage: "eng"},
+		{OriginalTitle: strings.Repeat("t", 151)},
+		{AgeCertification: "PG-13"},
+		{ExternalIDs: &model.ExternalIDs{IMDb: "0113277"}},
+		{ExternalIDs: &model.ExternalIDs{TMDb: "abc"}},
+		{ExternalIDs: &model.ExternalIDs{Kinopoisk: "0409"}},
+	}
+	for _, movie := range invalid {
+		if err := validateMovieMetadata(movie); err == nil {
+			t.Errorf("Expected error for %+v, got nil", movie)
+		}
+	}
+}
+
+// Only metadata present in an update is validated, empty values mean clearing
+func TestMovieUpdateMetadata(t *testing.T) {
+	var update model.MovieUpdate
+	err := json.Unmarshal([]byte(`{"id": 3, "runtime": 0, "originalTitle": "Heat", "externalIDs": {"tmdb": ""}}`), &update)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if update.Runtime == nil || *update.Runtime != 0 || update.OriginalLanguage != nil || update.ExternalIDs.IMDb != nil ||
+		update.ExternalIDs.TMDb == nil {
+		t.Errorf("Unexpected update: %+v", update)
+	}
+
+	metadata := movieUpdateMetadata(update)
+	if metadata.OriginalTitle != "Heat" || metadata.ExternalIDs == nil || *metadata.ExternalIDs != (model.ExternalIDs{}) {
+		t.Errorf("Unexpected metadata: %+v", metadata)
+	}
+	if err := validateMovieUpdate(update); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	runtime := -1
+	if err := validateMovieUpdate(model.MovieUpdate{Runtime: &runtime}); err == nil {
+		t.Error("Expected error for negative runtime, got nil")
+	}
+}
+
+func TestCompileMovieFilter_MetadataFields(t *testing.T) {
+	filter := model.MovieFilter{And: []model.MovieFilter{
+		{Field: "runtime", Op: "lte", Value: json.RawMessage(`120`)},
+		{Field: "country", Op: "all", Value: json.RawMessage(`["US", "FR"]`)},
+		{Field: "externalID", Op: "eq", Value: json.RawMessage(`{"source": "imdb", "id": "tt0113277"}`)},
+		{Field: "externalID", Op: "exists", Value: json.RawMessage(`"kinopoisk"`)},
+		{Field: "ageCertification", Op: "eq", Value: json.RawMessage(`"16+"`)},
+	}}
+
+	condition, args, err := compileMovieFilter(&filter)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "(m.runtime <= $1 AND m.countries @> $2::varchar[] AND m.imdb_id = $3 AND m.kinopoisk_id IS NOT NULL" +
+		" AND m.age_certification = $4)"
+	if condition != expected {
+		t.Errorf("Unexpected condition: %s", condition)
+	}
+	if len(args) != 4 {
+		t.Fatalf("Expected 4 arguments, got %d", len(args))
+	}
+
+	invalid := []model.MovieFilter{
+		{Field: "country", Op: "eq", Value: json.RawMessage(`"us"`)},
+		{Field: "country", Op: "in", Value: json.RawMessage(`[]`)},
+		{Field: "externalID", Op: "eq", Value: json.RawMessage(`{"source": "letterboxd", "id": "heat"}`)},
+		{Field: "externalID", Op: "exists", Value: json.RawMessage(`"letterboxd"`)},
+		{Field: "originalLanguage", Op: "contains", Value: json.RawMessage(`"e"`)},
+	}
+	for _, filter := range invalid {
+		if _, _, err := compileMovieFilter(&filter); err == nil {
+			t.Errorf("Expected error for filter %+v, got nil", filter)
+		}
+	}
+}
+
+func TestMovieMetadata_AddUpdateLookupAndFilter(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	if _, err := db.Exec("UPDATE person SET isAdmin = true WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to set isAdmin flag for user in db: %v", err)
+	}
+	defer db.Exec("DELETE FROM movie WHERE name IN ('Metadata Movie', 'Metadata Duplicate')")
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+	cookie := &http.Cookie{Name: jwtName, Value: signedToken}
+
+	call := func(handler http.HandlerFunc, target string, body interface{}) *httptest.ResponseRecorder {
+		requestBody, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(requestBody))
+		r.AddCookie(cookie)
+
+		handler(w, r)
+
+		return w
+	}
+
+	movie := model.Movie{Name: "Metadata Movie", Description: "", Date: time.Date(1995, 12, 15, 0, 0, 0, 0, time.UTC),
+		Rating: 8, Runtime: 170, Countries: []string{"US"}, OriginalLanguage: "en", OriginalTitle: "Heat",
+		AgeCertification: "16+", ExternalIDs: &model.ExternalIDs{IMDb: "tt9999901"}}
+	if w := call(AddMovie, "/", movie); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	duplicate := model.Movie{Name: "Metadata Duplicate", Date: time.Now(), Rating: 5,
+		ExternalIDs: &model.ExternalIDs{IMDb: "tt9999901"}}
+	if w := call(AddMovie, "/", duplicate); w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a duplicate IMDb ID, got %d", http.StatusConflict, w.Code)
+	}
+	if w := call(AddMovie, "/", model.Movie{Name: "Metadata Duplicate", Date: time.Now(), Countries: []string{"usa"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid country, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w := call(GetMovieByExternalID, "/?source=imdb&externalID=tt9999901", nil)
+	var details model.MovieDetails
+	json.Unmarshal(w.Body.Bytes(), &details)
+	if w.Code != http.StatusOK || details.Runtime != 170 || details.OriginalTitle != "Heat" ||
+		!reflect.DeepEqual(details.Countries, []string{"US"}) || details.ExternalIDs == nil || details.ExternalIDs.IMDb != "tt9999901" {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	// Empty fields are left unchanged, countries are replaced as a whole
+	update := model.Movie{ID: details.ID, Rating: 8, Countries: []string{"US", "FR"}, ExternalIDs: &model.ExternalIDs{TMDb: "949"}}
+	if w := call(UpdateMovie, "/", update); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	w = call(GetMovieByExternalID, "/?source=tmdb&externalID=949", nil)
+	details = model.MovieDetails{}
+	json.Unmarshal(w.Body.Bytes(), &details)
+	if w.Code != http.StatusOK || details.Runtime != 170 || len(details.Countries) != 2 || details.ExternalIDs.IMDb != "tt9999901" {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	// Empty metadata values present in the body clear the fields
+	cleared := json.RawMessage(fmt.Sprintf(`{"id": %d, "rating": 8, "runtime": 0, "externalIDs": {"imdb": ""}}`, details.ID))
+	if w := call(UpdateMovie, "/", cleared); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := call(GetMovieByExternalID, "/?source=imdb&externalID=tt9999901", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d after removing the IMDb ID, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = call(GetMovieByExternalID, "/?source=tmdb&externalID=949", nil)
+	details = model.MovieDetails{}
+	json.Unmarshal(w.Body.Bytes(), &details)
+	if w.Code != http.StatusOK || details.Runtime != 0 || details.OriginalTitle != "Heat" ||
+		details.ExternalIDs == nil || details.ExternalIDs.IMDb != "" || details.ExternalIDs.TMDb != "949" {
+		t.Errorf("Unexpected response after clearing fields %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call(GetMovieByExternalID, "/?source=imdb&externalID=tt9999902", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := call(GetMovieByExternalID, "/?source=letterboxd&externalID=heat", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	filter := model.FilterMovies{Filter: &model.MovieFilter{And: []model.MovieFilter{
+		{Field: "country", Op: "eq", Value: json.RawMessage(`"FR"`)},
+		{Field: "runtime", Op: "gt", Value: json.RawMessage(`150`)},
+	}}}
+	w = call(FilterMovies, "/", filter)
+	var movies []model.Movie
+	json.Unmarshal(w.Body.Bytes(), &movies)
+	found := false
+	for _, m := range movies {
+		found = found || m.ID == details.ID
+	}
+	if w.Code != http.StatusOK || !found {
+		t.Errorf("Expected movie %d among filtered movies, got %d: %s", details.ID, w.Code, w.Body.String())
+	}
+}
+
+// Returns distinct ids whose keys start with the prefix in key order
+func TestLookupPrefix_ReturnsMatchesInKeyOrder(t *testing.T) {
+	entries := []suggestEntry{
+		{key: "al pacino", id: 1},
+		{key: "de niro", id: 2},
+		{key: "pacino", id: 1},
+		{key: "pattinson", id: 3},
+		{key: "robert de niro", id: 2},
+		{key: "robert pattinson", id: 3},
+	}
+
+	ids := lookupPrefix(entries, "pa", 5)
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("Expected [1 3], got %v", ids)
+	}
+
+	ids = lookupPrefix(entries, "robert", 1)
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("Expected [2], got %v", ids)
+	}
+
+	ids = lookupPrefix(entries, "zz", 5)
+	if len(ids) != 0 {
+		t.Errorf("Expected no matches, got %v", ids)
+	}
+}
+
+// Marks the in-process index stale after a write
+func TestInvalidateSuggestions_MarksIndexStale(t *testing.T) {
+	suggestions.mu.Lock()
+	suggestions.loaded = true
+	suggestions.loadedAt = time.Now()
+	suggestions.loadedGeneration = atomic.LoadUint64(&suggestions.generation)
+	fresh := suggestions.isFresh()
+	suggestions.mu.Unlock()
+
+	if !fresh {
+		t.Fatal("Expected freshly loaded index to be fresh")
+	}
+
+	invalidateSuggestions()
+
+	suggestions.mu.RLock()
+	fresh = suggestions.isFresh()
+	suggestions.mu.RUnlock()
+
+	if fresh {
+		t.Error("Expected index to be stale after invalidation")
+	}
+}
+
+// Returns movie and actor suggestions for a prefix
+func TestSuggest_ValidPrefix(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	for _, cacheEnabled := range []bool{false, true} {
+		suggestCacheEnabled = cacheEnabled
+		invalidateSuggestions()
+
+		// Create a new request with the JWT token in the cookie
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?q=Ro&limit=3", nil)
+		cookie := &http.Cookie{
+			Name:  jwtName,
+			Value: signedToken,
+		}
+		r.AddCookie(cookie)
+
+		// Call the Suggest function directly
+		Suggest(w, r)
+
+		// Check response status code
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var result model.Suggestions
+		err := json.NewDecoder(w.Body).Decode(&result)
+		if err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+
+		if len(result.Movies) > 3 || len(result.Actors) > 3 {
+			t.Errorf("Expected at most 3 suggestions of each kind, got %d movies and %d actors",
+				len(result.Movies), len(result.Actors))
+		}
+		for _, movie := range result.Movies {
+			if !strings.HasPrefix(strings.ToLower(movie.Name), "ro") {
+				t.Errorf("Movie %q does not start with prefix", movie.Name)
+			}
+		}
+	}
+
+	suggestCacheEnabled = false
+}
+
+// Returns bad request when q is empty
+func TestSuggest_EmptyQuery(t *testing.T) {
+	db = setupTestDB()
+	defer db.Close()
+
+	queryTimeLimit = 5
+	secretKey = "filmoteka_test"
+	jwtName = "filmoteka_test_jwt"
+
+	// Create a new JWT token for authentication
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = "1"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signedToken, _ := token.SignedString([]byte(secretKey))
+
+	// Create a new request with the JWT token in the cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/?q=", nil)
+	cookie := &http.Cookie{
+		Name:  jwtName,
+		Value: signedToken,
+	}
+	r.AddCookie(cookie)
+
+	// Call the Suggest function directly
+	Suggest(w, r)
+
+	// Check response status code
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
 }
 
 // Verify that the function returns true if the user is an admin.
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const streamDefaultTimeLimit = 60
+
+// Ограничение времени потоковой выдачи списка в секундах (STREAM_TIME_LIMIT). Список читается из БД
+// всё время, пока отправляется клиенту, поэтому QUERY_TIME_LIMIT для него слишком мал.
+var streamTimeLimit = streamDefaultTimeLimit
+
+// streamError - последняя строка NDJSON, если список оборвался из-за ошибки
+type streamError struct {
+	Error string `json:"error"`
+}
+
+// listStreamer пишет элементы списка в ответ по мере их готовности, не собирая весь список в памяти:
+// массивом JSON или, если клиент запросил Accept: application/x-ndjson, по одному объекту в строке.
+// Заголовки отправляются вместе с первым элементом, поэтому до него ещё можно ответить ошибкой.
+type listStreamer struct {
+	w       http.ResponseWriter
+	ndjson  bool
+	encoder *json.Encoder
+	count   int
+}
+
+func newListStreamer(w http.ResponseWriter, r *http.Request) *listStreamer {
+	return &listStreamer{
+		w:       w,
+		ndjson:  strings.Contains(r.Header.Get("Accept"), "application/x-ndjson"),
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (s *listStreamer) start() error {
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		s.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(http.StatusOK)
+	_, err := s.w.Write([]byte("["))
+	return err
+}
+
+// write отправляет очередной элемент. Первый элемент и далее каждые exportFlushEvery
+// элементов сразу передаются клиенту.
+func (s *listStreamer) write(item interface{}) error {
+	var err error
+
+	if s.count == 0 {
+		err = s.start()
+	} else if !s.ndjson {
+		_, err = s.w.Write([]byte(","))
+	}
+
+	if err == nil {
+		err = s.encoder.Encode(item)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if s.count%exportFlushEvery == 0 {
+		flushExport(s.w)
+	}
+	s.count++
+
+	return nil
+}
+
+// close завершает список. Пустой список отправляется как [] (или пустое тело для NDJSON).
+func (s *listStreamer) close() error {
+	if s.count == 0 {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+
+	if s.ndjson {
+		return nil
+	}
+
+	_, err := s.w.Write([]byte("]"))
+	return err
+}
+
+// fail сообщает об ошибке чтения списка из БД. Пока заголовки не отправлены, клиент получает статус ошибки.
+// После этого массив JSON остаётся незакрытым, а в NDJSON последней строкой пишется {"error": "..."},
+// чтобы клиент мог отличить оборванный список от полного.
+func (s *listStreamer) fail(ctx context.Context, name string, err error) {
+	status, message := http.StatusInternalServerError, "Internal server error"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Println(name, "stream deadline exceeded: ", err)
+		status, message = http.StatusGatewayTimeout, "Database query time limit exceeded"
+	} else {
+		log.Println(name, "stream error: ", err)
+	}
+
+	if !s.started() {
+		http.Error(s.w, message, status)
+		return
+	}
+
+	if s.ndjson {
+		if err := s.encoder.Encode(streamError{Error: message}); err != nil {
+			log.Printf("Write failed: %v\n", err)
+		}
+	}
+}
+
+// started показывает, отправлены ли уже заголовки ответа
+func (s *listStreamer) started() bool {
+	return s.count > 0
+}
@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+const (
+	suggestDefaultLimit = 5
+	suggestMaxLimit     = 20
+	suggestMaxQuery     = 150
+	// Индекс в памяти перечитывается не реже, чем раз в suggestCacheMaxAge,
+	// чтобы подхватывать изменения, сделанные другими экземплярами приложения.
+	suggestCacheMaxAge = 5 * time.Minute
+)
+
+var suggestCacheEnabled bool // Включает префиксный индекс в памяти (SUGGEST_CACHE=true)
+
+type suggestEntry struct {
+	key string
+	id  int
+}
+
+// suggestIndex - префиксный индекс названий фильмов и имён актёров в памяти процесса.
+// Операции записи увеличивают generation, и индекс перестраивается при следующем запросе.
+type suggestIndex struct {
+	generation uint64
+
+	mu               sync.RWMutex
+	loaded           bool
+	loadedGeneration uint64
+	loadedAt         time.Time
+	movies           []suggestEntry
+	actors           []suggestEntry
+	movieByID        map[int]model.MovieSuggestion
+	actorByID        map[int]model.ActorSuggestion
+}
+
+var suggestions = &suggestIndex{}
+
+// invalidateSuggestions помечает индекс подсказок устаревшим. Вызывается после изменения фильмов и актёров.
+func invalidateSuggestions() {
+	atomic.AddUint64(&suggestions.generation, 1)
+}
+
+func (s *suggestIndex) isFresh() bool {
+	return s.loaded && s.loadedGeneration == atomic.LoadUint64(&s.generation) &&
+		time.Since(s.loadedAt) < suggestCacheMaxAge
+}
+
+func (s *suggestIndex) refresh(ctx context.Context) error {
+	s.mu.RLock()
+	fresh := s.isFresh()
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isFresh() {
+		return nil
+	}
+
+	// Поколение запоминается до чтения, чтобы запись во время загрузки снова пометила индекс устаревшим
+	generation := atomic.LoadUint64(&s.generation)
+
+	movies := []suggestEntry{}
+	movieByID := map[int]model.MovieSuggestion{}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, name FROM movie WHERE deleted_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var movie model.MovieSuggestion
+		if err := rows.Scan(&movie.ID, &movie.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		movieByID[movie.ID] = movie
+		movies = append(movies, suggestEntry{key: strings.ToLower(movie.Name), id: movie.ID})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	actors := []suggestEntry{}
+	actorByID := map[int]model.ActorSuggestion{}
+
+	rows, err = db.QueryContext(ctx, `SELECT id, firstName, lastName FROM actor WHERE deleted_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var actor model.ActorSuggestion
+		if err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName); err != nil {
+			rows.Close()
+			return err
+		}
+		actorByID[actor.ID] = actor
+		actors = append(actors,
+			suggestEntry{key: strings.ToLower(actor.FirstName + " " + actor.LastName), id: actor.ID},
+			suggestEntry{key: strings.ToLower(actor.LastName), id: actor.ID})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sortSuggestEntries(movies)
+	sortSuggestEntries(actors)
+
+	s.movies, s.actors = movies, actors
+	s.movieByID, s.actorByID = movieByID, actorByID
+	s.loaded = true
+	s.loadedGeneration = generation
+	s.loadedAt = time.Now()
+
+	return nil
+}
+
+func sortSuggestEntries(entries []suggestEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].id < entries[j].id
+	})
+}
+
+// lookupPrefix возвращает до limit различных id, ключи которых начинаются с prefix, в порядке ключей
+func lookupPrefix(entries []suggestEntry, prefix string, limit int) []int {
+	ids := []int{}
+	seen := map[int]bool{}
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].key >= prefix })
+	for ; i < len(entries) && len(ids) < limit && strings.HasPrefix(entries[i].key, prefix); i++ {
+		if !seen[entries[i].id] {
+			seen[entries[i].id] = true
+			ids = append(ids, entries[i].id)
+		}
+	}
+
+	return ids
+}
+
+func (s *suggestIndex) suggest(ctx context.Context, prefix string, limit int) (model.Suggestions, error) {
+	if err := s.refresh(ctx); err != nil {
+		return model.Suggestions{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	result := model.Suggestions{Movies: []model.MovieSuggestion{}, Actors: []model.ActorSuggestion{}}
+
+	for _, id := range lookupPrefix(s.movies, prefix, limit) {
+		result.Movies = append(result.Movies, s.movieByID[id])
+	}
+	for _, id := range lookupPrefix(s.actors, prefix, limit) {
+		result.Actors = append(result.Actors, s.actorByID[id])
+	}
+
+	return result, nil
+}
+
+// querySuggestions ищет подсказки в БД по префиксу, используя индексы text_pattern_ops
+func querySuggestions(ctx context.Context, prefix string, limit int) (model.Suggestions, error) {
+	result := model.Suggestions{Movies: []model.MovieSuggestion{}, Actors: []model.ActorSuggestion{}}
+
+	pattern := escapeLike(strings.ToLower(prefix)) + "%"
+
+	suggestMoviesQuery := `
+	SELECT id, name FROM movie
+	WHERE deleted_at IS NULL AND lower(name) LIKE $1
+	ORDER BY lower(name), id
+	LIMIT $2;
+	`
+
+	rows, err := db.QueryContext(ctx, suggestMoviesQuery, pattern, limit)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var movie model.MovieSuggestion
+		if err := rows.Scan(&movie.ID, &movie.Name); err != nil {
+			rows.Close()
+			return result, err
+		}
+		result.Movies = append(result.Movies, movie)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	suggestActorsQuery := `
+	SELECT id, firstName, lastName FROM actor
+	WHERE deleted_at IS NULL
+	AND (lower(firstName || ' ' || lastName) LIKE $1 OR lower(lastName) LIKE $1)
+	ORDER BY lower(firstName || ' ' || lastName), id
+	LIMIT $2;
+	`
+
+	rows, err = db.QueryContext(ctx, suggestActorsQuery, pattern, limit)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var actor model.ActorSuggestion
+		if err := rows.Scan(&actor.ID, &actor.FirstName, &actor.LastName); err != nil {
+			rows.Close()
+			return result, err
+		}
+		result.Actors = append(result.Actors, actor)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func Suggest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, ok := authorizeUser(w, r); !ok {
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	if q == "" {
+		http.Error(w, "Query parameter q cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	if len([]rune(q)) > suggestMaxQuery {
+		http.Error(w, fmt.Sprintf("Maximum q length is %d characters", suggestMaxQuery), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+
+	limit := suggestDefaultLimit
+	if strLimit := r.URL.Query().Get("limit"); strLimit != "" {
+		limit, err = strconv.Atoi(strLimit)
+		if err != nil || limit < 1 || limit > suggestMaxLimit {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", suggestMaxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	var result model.Suggestions
+	if suggestCacheEnabled {
+		result, err = suggestions.suggest(ctx, q, limit)
+	} else {
+		result, err = querySuggestions(ctx, q, limit)
+	}
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("Suggest QueryContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
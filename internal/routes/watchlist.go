@@ -0,0 +1,373 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BukhryakovVladimir/vkTest/internal/model"
+)
+
+// userMovieList описывает личный список фильмов пользователя. Имя таблицы подставляется в SQL только отсюда.
+type userMovieList struct {
+	table string
+	name  string
+}
+
+var (
+	watchlistList = userMovieList{table: "watchlist", name: "Watchlist"}
+	watchedList   = userMovieList{table: "watchedmovie", name: "Watched"}
+)
+
+// watchOperation меняет отметки фильма пользователем issuer в транзакции
+type watchOperation func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) error
+
+// watchColumns возвращает колонки отметок фильма m пользователем из параметра $param:
+// есть ли фильм в списке «посмотреть позже» и дату просмотра (NULL, если не смотрел)
+func watchColumns(param int) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM watchlist wl WHERE wl.movie_id = m.id AND wl.person_id = $%[1]d),
+	(SELECT wm.watched_on FROM watchedmovie wm WHERE wm.movie_id = m.id AND wm.person_id = $%[1]d)`, param)
+}
+
+// setWatchState переносит отметки пользователя из колонок watchColumns в фильм
+func setWatchState(movie *model.Movie, onWatchlist bool, watchedOn sql.NullTime) {
+	movie.OnWatchlist = onWatchlist
+	if watchedOn.Valid {
+		movie.Watched = true
+		movie.WatchedOn = &watchedOn.Time
+	}
+}
+
+// validateWatchedOn проверяет дату просмотра. Дата до суток вперёд допускается из-за часовых поясов.
+func validateWatchedOn(watchedOn time.Time) error {
+	if watchedOn.After(time.Now().Add(24 * time.Hour)) {
+		return badRequest("Watched date cannot be in the future")
+	}
+
+	return nil
+}
+
+// getWatchState возвращает отметки фильма пользователем
+func getWatchState(ctx context.Context, q queryer, issuer string, movieID int) (model.WatchState, error) {
+	state := model.WatchState{MovieID: movieID}
+
+	watchStateQuery := `
+	SELECT EXISTS (SELECT 1 FROM watchlist WHERE person_id = $1 AND movie_id = $2),
+	(SELECT watched_on FROM watchedmovie WHERE person_id = $1 AND movie_id = $2);
+	`
+
+	var watchedOn sql.NullTime
+
+	err := q.QueryRowContext(ctx, watchStateQuery, issuer, movieID).Scan(&state.OnWatchlist, &watchedOn)
+	if watchedOn.Valid {
+		state.Watched = true
+		state.WatchedOn = &watchedOn.Time
+	}
+
+	return state, err
+}
+
+// AddToWatchlist добавляет фильм в список «посмотреть позже» текущего пользователя
+func AddToWatchlist(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	changeWatchState(w, r, issuer, "AddToWatchlist", http.StatusCreated, func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) error {
+		err := requireActive(ctx, tx, auditEntityMovie, movieID)
+
+		var result sql.Result
+		if err == nil {
+			result, err = tx.ExecContext(ctx, `INSERT INTO Watchlist (person_id, movie_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+				issuer, movieID)
+		}
+
+		var rowsAffected int64
+		if err == nil {
+			rowsAffected, err = result.RowsAffected()
+		}
+
+		if err == nil && rowsAffected == 0 {
+			err = &catalogError{status: http.StatusConflict, message: "Movie is already on your watchlist"}
+		}
+
+		return err
+	})
+}
+
+// RemoveFromWatchlist убирает фильм из списка «посмотреть позже» текущего пользователя
+func RemoveFromWatchlist(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	changeWatchState(w, r, issuer, "RemoveFromWatchlist", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) error {
+		return deleteWatchEntry(ctx, tx, watchlistList, issuer, movieID, "Movie is not on your watchlist")
+	})
+}
+
+// MarkWatched отмечает фильм просмотренным в указанную дату, повторная отметка меняет дату
+func MarkWatched(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	var watched model.Watched
+
+	err := json.NewDecoder(r.Body).Decode(&watched)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if err := validateWatchedOn(watched.WatchedOn); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Пустая дата заменяется текущей датой базы данных. Дата передаётся строкой,
+	// чтобы часовой пояс сессии не сдвинул её при приведении к DATE.
+	var watchedOn sql.NullString
+	if !watched.WatchedOn.IsZero() {
+		watchedOn = sql.NullString{String: watched.WatchedOn.Format(time.DateOnly), Valid: true}
+	}
+
+	changeWatchState(w, r, issuer, "MarkWatched", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) error {
+		err := requireActive(ctx, tx, auditEntityMovie, movieID)
+
+		markWatchedQuery := `
+		INSERT INTO WatchedMovie (person_id, movie_id, watched_on)
+		VALUES ($1, $2, COALESCE($3::date, CURRENT_DATE))
+		ON CONFLICT (person_id, movie_id) DO UPDATE SET watched_on = EXCLUDED.watched_on;
+		`
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, markWatchedQuery, issuer, movieID, watchedOn)
+		}
+
+		return err
+	})
+}
+
+// UnmarkWatched убирает фильм из истории просмотров текущего пользователя
+func UnmarkWatched(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	changeWatchState(w, r, issuer, "UnmarkWatched", http.StatusOK, func(ctx context.Context, tx *sql.Tx, issuer string, movieID int) error {
+		return deleteWatchEntry(ctx, tx, watchedList, issuer, movieID, "You have not watched this movie")
+	})
+}
+
+// deleteWatchEntry убирает фильм из списка пользователя, если фильма в списке нет - возвращает ответ 404 с notFound
+func deleteWatchEntry(ctx context.Context, tx *sql.Tx, list userMovieList, issuer string, movieID int, notFound string) error {
+	result, err := tx.ExecContext(ctx, `DELETE FROM `+list.table+` WHERE person_id = $1 AND movie_id = $2;`, issuer, movieID)
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, err = result.RowsAffected()
+	}
+
+	if err == nil && rowsAffected == 0 {
+		err = &catalogError{status: http.StatusNotFound, message: notFound}
+	}
+
+	return err
+}
+
+// changeWatchState проверяет id фильма из пути, выполняет operation в транзакции от имени issuer
+// и возвращает отметки фильма после изменения
+func changeWatchState(w http.ResponseWriter, r *http.Request, issuer, name string, status int, operation watchOperation) {
+	movieID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || movieID < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		http.Error(w, "Error starting transaction", http.StatusInternalServerError)
+		return
+	}
+
+	err = operation(ctx, tx, issuer, movieID)
+
+	var state model.WatchState
+	if err == nil {
+		state, err = getWatchState(ctx, tx, issuer, movieID)
+	}
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("%s Failed to rollback transaction: %v\n", name, rollbackErr)
+		} else {
+			log.Println(name, "transaction rollback")
+		}
+
+		var errCatalog *catalogError
+		if errors.As(err, &errCatalog) {
+			log.Println(name, errCatalog.message)
+			http.Error(w, errCatalog.message, errCatalog.status)
+			return
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println(name, "ExecContext deadline exceeded: ", err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(name, "error committing transaction: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(state)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}
+
+// GetWatchlist возвращает список «посмотреть позже» текущего пользователя
+func GetWatchlist(w http.ResponseWriter, r *http.Request) {
+	listUserMovies(w, r, watchlistList)
+}
+
+// GetWatched возвращает историю просмотров текущего пользователя
+func GetWatched(w http.ResponseWriter, r *http.Request) {
+	listUserMovies(w, r, watchedList)
+}
+
+// listUserMovies возвращает страницу личного списка фильмов текущего пользователя,
+// отсортированную по дате добавления (order=asc или desc, по умолчанию новые первыми).
+// Другое значение order возвращает ответ 400.
+func listUserMovies(w http.ResponseWriter, r *http.Request, list userMovieList) {
+	defer r.Body.Close()
+
+	issuer, ok := authorizeUser(w, r)
+	if !ok {
+		return
+	}
+
+	order := r.URL.Query().Get("order")
+
+	if order == "" {
+		order = "desc"
+	}
+
+	if order != "asc" && order != "desc" {
+		http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// order проверен выше по списку допустимых значений
+	listQuery := `
+	SELECT m.id, m.name, m.description, m.date, m.rating, ` + communityColumns + `,
+	` + watchColumns(1) + `, l.added_at
+	FROM ` + list.table + ` l
+	JOIN movie m ON m.id = l.movie_id AND m.deleted_at IS NULL
+	` + ratingStatsJoin + `
+	WHERE l.person_id = $1
+	ORDER BY l.added_at ` + order + `, m.id
+	LIMIT $2 OFFSET $3;
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(queryTimeLimit)*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, listQuery, issuer, limit, offset)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Get%s QueryContext deadline exceeded: %v\n", list.name, err)
+			http.Error(w, "Database query time limit exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		log.Println("Database error: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []model.WatchlistEntry{}
+	for rows.Next() {
+		var entry model.WatchlistEntry
+		var community model.RatingSummary
+		var onWatchlist bool
+		var watchedOn sql.NullTime
+
+		if err := rows.Scan(&entry.ID, &entry.Name, &entry.Description, &entry.Date, &entry.Rating,
+			&community.Average, &community.Count, &onWatchlist, &watchedOn, &entry.AddedAt); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		entry.CommunityRating = &community
+		setWatchState(&entry.Movie, onWatchlist, watchedOn)
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Printf("Write failed: %v\n", err)
+	}
+}